@@ -24,6 +24,11 @@ func main() {
 	defer database.UserDB.Close()
 	defer database.WorkspaceDB.Close()
 
+	// Bring existing workspaces up to the current schema
+	if err := database.MigrateWorkspaces(); err != nil {
+		log.Printf("Failed to migrate workspaces: %v", err)
+	}
+
 	e := echo.New()
 
 	// Middleware
@@ -58,10 +63,50 @@ func main() {
 	r.POST("/collaborator", handlers.HandlerAddCollaborator)
 	e.POST("/runAT", handlers.HandlePostAT)
 	r.POST("/runATFromSaved",handlers.HandlerRunSavedAT)
+	r.GET("/ats/runs", handlers.HandlerListATRuns)
+	r.GET("/ats/runs/compare", handlers.HandlerCompareATRuns)
+	r.GET("/ats/runs/:rid", handlers.HandlerGetATRun)
+	r.DELETE("/ats/runs/:rid", handlers.HandlerDeleteATRun)
+	r.GET("/ats/flaky", handlers.HandlerListFlakyATs)
+	r.GET("/ats/quarantine", handlers.HandlerListQuarantine)
+	r.POST("/ats/quarantine", handlers.HandlerQuarantineCase)
+	r.DELETE("/ats/quarantine", handlers.HandlerReleaseCase)
+	r.GET("/trends", handlers.HandlerQualityTrends)
 	
+	r.POST("/sessions", handlers.HandlerCreateSession)
+	r.GET("/sessions/:sid/cookies", handlers.HandlerGetSessionCookies)
+	r.DELETE("/sessions/:sid/cookies", handlers.HandlerClearSessionCookies)
+	r.POST("/sessions/:sid/persist", handlers.HandlerPersistSession)
+
+	r.GET("/blobs/:hash", handlers.HandlerFetchBlob)
+	r.POST("/runs/:rid/cancel", handlers.HandlerCancelRun)
+
 	r.GET("/load-flow", handlers.LoadSpecificFlow)
 	r.POST("/save-flow", handlers.SaveFlow)
-	// r.GET("/runATsave", handlers.HandlerRunATSave)
+	r.POST("/flows/:fid/run", handlers.HandlerRunFlow)
+	r.GET("/flows/runs", handlers.HandlerListFlowRuns)
+	r.GET("/flows/runs/compare", handlers.HandlerCompareFlowRuns)
+	r.GET("/flows/runs/:rid", handlers.HandlerGetFlowRun)
+	r.DELETE("/flows/runs/:rid", handlers.HandlerDeleteFlowRun)
+	r.GET("/flows/runs/:rid/events", handlers.HandlerRunEvents)
+	r.POST("/flows/runs/:rid/resume", handlers.HandlerResumeFlowRun)
+	r.GET("/flows/runs/:rid/debug", handlers.HandlerGetFlowDebug)
+	r.PUT("/flows/runs/:rid/debug/breakpoints", handlers.HandlerSetFlowBreakpoints)
+	r.PUT("/flows/runs/:rid/debug/env", handlers.HandlerSetFlowDebugEnv)
+	r.POST("/flows/runs/:rid/debug/continue", handlers.HandlerContinueFlowDebug)
+	r.POST("/flows/runs/:rid/debug/step", handlers.HandlerStepFlowDebug)
+	r.POST("/flows/runs/:rid/debug/abort", handlers.HandlerAbortFlowDebug)
+	r.GET("/flows/:fid/revisions", handlers.HandlerListFlowRevisions)
+	r.GET("/flows/:fid/revisions/:version", handlers.HandlerGetFlowRevision)
+	r.POST("/flows/:fid/revisions/:version/restore", handlers.HandlerRestoreFlowRevision)
+	r.GET("/flows/:fid/diff", handlers.HandlerDiffFlowRevisions)
+	r.GET("/flows/:fid/diagram", handlers.HandlerFlowDiagram)
+
+	r.POST("/datasets", handlers.HandlerUploadDataset)
+	r.GET("/datasets", handlers.HandlerListDatasets)
+	r.GET("/datasets/:did", handlers.HandlerGetDataset)
+	r.DELETE("/datasets/:did", handlers.HandlerDeleteDataset)
+	r.POST("/datasets/:did/run", handlers.HandlerRunDataset)
 
 
 	// Start server
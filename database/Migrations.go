@@ -0,0 +1,119 @@
+package database
+
+import (
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"log"
+	"strings"
+)
+
+// workspaceTables lists the per-workspace schema steps added after a
+// workspace may already have been created. Each step is idempotent, so
+// running them against an up-to-date workspace is a no-op.
+var workspaceTables = []func(tablePrefix string) error{
+	CreateSessionTable,
+	MigrateATTable,
+	CreateDatasetTable,
+	MigrateFlowTable,
+	CreateFlowRunTable,
+	CreateFlowRevisionTable,
+	CreateATRunTable,
+	CreateQuarantineTable,
+}
+
+// CreateWorkspaceTables creates or upgrades the per-workspace tables.
+func CreateWorkspaceTables(tablePrefix string) error {
+	for _, create := range workspaceTables {
+		if err := create(tablePrefix); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// MigrateWorkspaces brings every known workspace up to the current schema.
+func MigrateWorkspaces() error {
+	rows, err := UserDB.Query("SELECT workspace FROM users")
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+
+	seen := make(map[string]bool)
+	for rows.Next() {
+		var workspaces sql.NullString
+		if err := rows.Scan(&workspaces); err != nil {
+			return err
+		}
+		if !workspaces.Valid || workspaces.String == "" {
+			continue
+		}
+
+		var workspaceList []WorkspaceInfo
+		if err := json.Unmarshal([]byte(workspaces.String), &workspaceList); err != nil {
+			log.Printf("Failed to unmarshal workspaces: %v", err)
+			continue
+		}
+
+		for _, ws := range workspaceList {
+			if seen[ws.WID] {
+				continue
+			}
+			seen[ws.WID] = true
+			if err := CreateWorkspaceTables(ws.WID); err != nil {
+				log.Printf("Failed to migrate workspace %s: %v", ws.WID, err)
+			}
+		}
+	}
+
+	return rows.Err()
+}
+
+// addColumnIfMissing adds a column to an existing table unless it is
+// already there.
+func addColumnIfMissing(table, column, definition string) error {
+	var count int
+	err := WorkspaceDB.QueryRow(`
+		SELECT COUNT(*) FROM information_schema.COLUMNS
+		WHERE TABLE_SCHEMA = DATABASE() AND TABLE_NAME = ? AND COLUMN_NAME = ?
+	`, table, column).Scan(&count)
+	if err != nil {
+		return err
+	}
+	if count > 0 {
+		return nil
+	}
+
+	_, err = WorkspaceDB.Exec(fmt.Sprintf("ALTER TABLE %s ADD COLUMN %s %s", table, column, definition))
+	if err != nil {
+		log.Printf("Failed to add column %s to %s: %v", column, table, err)
+	}
+	return err
+}
+
+// modifyColumnIfDifferent redefines an existing column as a nullable or NOT
+// NULL column of dataType, unless it already has that type and nullability.
+func modifyColumnIfDifferent(table, column, dataType string, nullable bool) error {
+	var currentType, isNullable string
+	err := WorkspaceDB.QueryRow(`
+		SELECT DATA_TYPE, IS_NULLABLE FROM information_schema.COLUMNS
+		WHERE TABLE_SCHEMA = DATABASE() AND TABLE_NAME = ? AND COLUMN_NAME = ?
+	`, table, column).Scan(&currentType, &isNullable)
+	if err != nil {
+		return err
+	}
+	if strings.EqualFold(currentType, dataType) && (isNullable == "YES") == nullable {
+		return nil
+	}
+
+	definition := dataType + " NOT NULL"
+	if nullable {
+		definition = dataType + " NULL"
+	}
+	_, err = WorkspaceDB.Exec(fmt.Sprintf("ALTER TABLE %s MODIFY %s %s", table, column, definition))
+	if err != nil {
+		log.Printf("Failed to modify column %s of %s: %v", column, table, err)
+	}
+	return err
+}
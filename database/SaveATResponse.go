@@ -4,8 +4,23 @@ import (
 	"fmt"
 )
 
-func SaveATResponse(wid, id string, response string) error {
-	query := fmt.Sprintf("UPDATE %s_at SET response = ? WHERE id = ?", wid)
-	_, err := WorkspaceDB.Exec(query, response, id)
+// SaveATResponse stores the last response of an AT. responseRef is the blob
+// store key of the full body when it was too large to keep inline.
+func SaveATResponse(wid, id string, response string, responseRef string) error {
+	query := fmt.Sprintf("UPDATE %s_at SET response = ?, response_ref = ? WHERE id = ?", wid)
+	_, err := WorkspaceDB.Exec(query, response, responseRef, id)
 	return err
 }
+
+// BlobReferenced reports whether ref is the blob store key of a response
+// kept by the workspace, either as the last response of an AT or in its run
+// history.
+func BlobReferenced(wid, ref string) (bool, error) {
+	query := fmt.Sprintf(`
+		SELECT EXISTS(SELECT 1 FROM %s_at WHERE response_ref = ?)
+			OR EXISTS(SELECT 1 FROM %s_at_run WHERE response_ref = ?)
+	`, wid, wid)
+	var referenced bool
+	err := WorkspaceDB.QueryRow(query, ref, ref).Scan(&referenced)
+	return referenced, err
+}
@@ -0,0 +1,322 @@
+package database
+
+import (
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"log"
+	"strings"
+)
+
+// Sources that trigger an AT run.
+const (
+	TriggerManual  = "manual"  // run on its own through the API
+	TriggerFlow    = "flow"    // run as a node of a flow
+	TriggerDataset = "dataset" // run as a row of a dataset run
+)
+
+// ATRunData is one persisted execution of an AT.
+type ATRunData struct {
+	RID        string `json:"rid"`
+	ATID       int    `json:"at_id"`
+	ATHash     string `json:"at_hash,omitempty"` // ATHash of the AT when it ran
+	Status     string `json:"status"`
+	Trigger    string `json:"trigger"`               // manual, flow or dataset
+	TriggerRef string `json:"trigger_ref,omitempty"` // flow or dataset run ID for runs they triggered
+	Method     string `json:"method"`
+	URL        string `json:"url"`
+	StatusCode int    `json:"status_code"`
+	DurationMs int64  `json:"duration_ms"` // response time
+	RunBy      int    `json:"run_by"`
+	StartedAt  string `json:"started_at"`
+
+	// Detail fields, only set by FetchATRun
+	Request         json.RawMessage `json:"request,omitempty"`          // rendered request: method, URL, headers, body
+	ResponseHeaders json.RawMessage `json:"response_headers,omitempty"` // response headers
+	ResponseBody    string          `json:"response_body,omitempty"`    // inline body, possibly truncated
+	ResponseRef     string          `json:"response_ref,omitempty"`     // blob store key of the full body
+	ResponseSize    int64           `json:"response_size,omitempty"`
+	Results         json.RawMessage `json:"results,omitempty"` // assertion results
+	EnvIn           json.RawMessage `json:"env_in,omitempty"`
+	EnvOut          json.RawMessage `json:"env_out,omitempty"`
+}
+
+// ATRunFilter narrows FetchATRuns. Empty fields do not filter; From and To
+// are UTC times in RunTimeFormat.
+type ATRunFilter struct {
+	ATID       string
+	Status     string
+	Trigger    string
+	TriggerRef string
+	From       string
+	To         string
+	Limit      int
+	Offset     int
+}
+
+func CreateATRunTable(tablePrefix string) error {
+	_, err := WorkspaceDB.Exec(fmt.Sprintf(`
+		CREATE TABLE IF NOT EXISTS %s_at_run (
+			rid VARCHAR(64) PRIMARY KEY,
+			at_id INT(11) NOT NULL,
+			at_hash CHAR(64) NULL,
+			status VARCHAR(16) NOT NULL,
+			trigger_source VARCHAR(16) NOT NULL,
+			trigger_ref VARCHAR(64) NULL,
+			method VARCHAR(16) NULL,
+			url TEXT NULL,
+			request LONGTEXT NULL,
+			status_code INT(11) NOT NULL DEFAULT 0,
+			response_headers LONGTEXT NULL,
+			response_body LONGTEXT NULL,
+			response_ref VARCHAR(64) NULL,
+			response_size BIGINT NOT NULL DEFAULT 0,
+			duration_ms BIGINT NOT NULL DEFAULT 0,
+			results LONGTEXT NULL,
+			env_in LONGTEXT NULL,
+			env_out LONGTEXT NULL,
+			run_by INT(11) NULL,
+			started_at DATETIME(3) NOT NULL,
+			INDEX idx_at_run_at (at_id, started_at),
+			INDEX idx_at_run_started (started_at),
+			INDEX idx_at_run_trigger (trigger_ref)
+		)
+	`, tablePrefix))
+	if err != nil {
+		log.Printf("Failed to create AT run table: %v", err)
+		return err
+	}
+	return addColumnIfMissing(fmt.Sprintf("%s_at_run", tablePrefix), "at_hash", "CHAR(64) NULL")
+}
+
+// InsertATRun records a finished AT run.
+func InsertATRun(wid string, run *ATRunData) error {
+	_, err := WorkspaceDB.Exec(fmt.Sprintf(`
+		INSERT INTO %s_at_run (rid, at_id, at_hash, status, trigger_source, trigger_ref, method, url, request,
+			status_code, response_headers, response_body, response_ref, response_size, duration_ms,
+			results, env_in, env_out, run_by, started_at)
+		VALUES (?, ?, NULLIF(?, ''), ?, ?, NULLIF(?, ''), ?, ?, ?, ?, ?, ?, NULLIF(?, ''), ?, ?, ?, ?, ?, ?, ?)
+	`, wid), run.RID, run.ATID, run.ATHash, run.Status, run.Trigger, run.TriggerRef, run.Method, run.URL, string(run.Request),
+		run.StatusCode, string(run.ResponseHeaders), run.ResponseBody, run.ResponseRef, run.ResponseSize, run.DurationMs,
+		string(run.Results), string(run.EnvIn), string(run.EnvOut), run.RunBy, run.StartedAt)
+	return err
+}
+
+const atRunColumns = `rid, at_id, COALESCE(at_hash, ''), status, trigger_source, COALESCE(trigger_ref, ''), COALESCE(method, ''),
+	COALESCE(url, ''), status_code, duration_ms, COALESCE(run_by, 0), started_at`
+
+// scanATRun scans atRunColumns into run, followed by any extra columns.
+func scanATRun(scan func(dest ...interface{}) error, run *ATRunData, extra ...interface{}) error {
+	dest := []interface{}{&run.RID, &run.ATID, &run.ATHash, &run.Status, &run.Trigger, &run.TriggerRef, &run.Method,
+		&run.URL, &run.StatusCode, &run.DurationMs, &run.RunBy, &run.StartedAt}
+	return scan(append(dest, extra...)...)
+}
+
+// FetchATRuns lists AT runs, newest first, without their request, response
+// and env details.
+func FetchATRuns(wid string, filter ATRunFilter) ([]ATRunData, error) {
+	var where []string
+	var args []interface{}
+	if filter.ATID != "" {
+		where = append(where, "at_id = ?")
+		args = append(args, filter.ATID)
+	}
+	if filter.Status != "" {
+		where = append(where, "status = ?")
+		args = append(args, filter.Status)
+	}
+	if filter.Trigger != "" {
+		where = append(where, "trigger_source = ?")
+		args = append(args, filter.Trigger)
+	}
+	if filter.TriggerRef != "" {
+		where = append(where, "trigger_ref = ?")
+		args = append(args, filter.TriggerRef)
+	}
+	if filter.From != "" {
+		where = append(where, "started_at >= ?")
+		args = append(args, filter.From)
+	}
+	if filter.To != "" {
+		where = append(where, "started_at < ?")
+		args = append(args, filter.To)
+	}
+
+	query := fmt.Sprintf("SELECT %s FROM %s_at_run", atRunColumns, wid)
+	if len(where) > 0 {
+		query += " WHERE " + strings.Join(where, " AND ")
+	}
+	query += " ORDER BY started_at DESC LIMIT ? OFFSET ?"
+	args = append(args, filter.Limit, filter.Offset)
+
+	rows, err := WorkspaceDB.Query(query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	result := []ATRunData{}
+	for rows.Next() {
+		var run ATRunData
+		if err := scanATRun(rows.Scan, &run); err != nil {
+			return nil, err
+		}
+		result = append(result, run)
+	}
+	return result, rows.Err()
+}
+
+// FetchATRun returns an AT run with all its details.
+func FetchATRun(wid, rid string) (*ATRunData, error) {
+	query := fmt.Sprintf(`
+		SELECT %s, COALESCE(request, 'null'), COALESCE(response_headers, 'null'), COALESCE(response_body, ''),
+			COALESCE(response_ref, ''), response_size, COALESCE(results, 'null'), COALESCE(env_in, 'null'),
+			COALESCE(env_out, 'null')
+		FROM %s_at_run WHERE rid = ?
+	`, atRunColumns, wid)
+	var run ATRunData
+	var request, headers, results, envIn, envOut string
+	err := scanATRun(WorkspaceDB.QueryRow(query, rid).Scan, &run, &request, &headers, &run.ResponseBody,
+		&run.ResponseRef, &run.ResponseSize, &results, &envIn, &envOut)
+	if err == sql.ErrNoRows {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	run.Request = json.RawMessage(request)
+	run.ResponseHeaders = json.RawMessage(headers)
+	run.Results = json.RawMessage(results)
+	run.EnvIn = json.RawMessage(envIn)
+	run.EnvOut = json.RawMessage(envOut)
+	return &run, nil
+}
+
+// DeleteATRun deletes an AT run.
+func DeleteATRun(wid, rid string) (bool, error) {
+	result, err := WorkspaceDB.Exec(fmt.Sprintf("DELETE FROM %s_at_run WHERE rid = ?", wid), rid)
+	if err != nil {
+		return false, err
+	}
+	n, _ := result.RowsAffected()
+	return n > 0, nil
+}
+
+// ATRunOutcome is the outcome of an AT run, as needed to score flakiness.
+type ATRunOutcome struct {
+	ATID    int
+	ATHash  string
+	Status  string
+	Results json.RawMessage
+}
+
+// FetchATRunOutcomes lists the outcomes of AT runs started in [from, to),
+// grouped by AT and oldest first within each AT. atID, from and to may be
+// empty.
+func FetchATRunOutcomes(wid, atID, from, to string) ([]ATRunOutcome, error) {
+	where := []string{"at_hash IS NOT NULL"}
+	var args []interface{}
+	if atID != "" {
+		where = append(where, "at_id = ?")
+		args = append(args, atID)
+	}
+	if from != "" {
+		where = append(where, "started_at >= ?")
+		args = append(args, from)
+	}
+	if to != "" {
+		where = append(where, "started_at < ?")
+		args = append(args, to)
+	}
+
+	rows, err := WorkspaceDB.Query(fmt.Sprintf(`
+		SELECT at_id, at_hash, status, COALESCE(results, 'null') FROM %s_at_run
+		WHERE %s ORDER BY at_id, started_at
+	`, wid, strings.Join(where, " AND ")), args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	result := []ATRunOutcome{}
+	for rows.Next() {
+		var outcome ATRunOutcome
+		var results string
+		if err := rows.Scan(&outcome.ATID, &outcome.ATHash, &outcome.Status, &results); err != nil {
+			return nil, err
+		}
+		outcome.Results = json.RawMessage(results)
+		result = append(result, outcome)
+	}
+	return result, rows.Err()
+}
+
+// ATRunSample is an AT run with the saved AT's name, tag, method and URL, as
+// needed to aggregate quality trends.
+type ATRunSample struct {
+	ATID       int
+	Path       string
+	Tag        string
+	Method     string
+	URL        string
+	Status     string
+	StatusCode int
+	DurationMs int64
+	StartedAt  string
+	Results    json.RawMessage
+}
+
+// FetchATRunSamples lists at most limit AT runs started in [from, to),
+// newest first. atID and tag narrow the runs to one AT or to the ATs with
+// that tag; any filter may be empty.
+func FetchATRunSamples(wid, atID, tag, from, to string, limit int) ([]ATRunSample, error) {
+	var where []string
+	var args []interface{}
+	if atID != "" {
+		where = append(where, "r.at_id = ?")
+		args = append(args, atID)
+	}
+	if tag != "" {
+		where = append(where, "a.tag = ?")
+		args = append(args, tag)
+	}
+	if from != "" {
+		where = append(where, "r.started_at >= ?")
+		args = append(args, from)
+	}
+	if to != "" {
+		where = append(where, "r.started_at < ?")
+		args = append(args, to)
+	}
+
+	query := fmt.Sprintf(`
+		SELECT r.at_id, COALESCE(a.path, ''), COALESCE(a.tag, ''), COALESCE(a.Method, r.method, ''),
+			COALESCE(a.url, r.url, ''), r.status, r.status_code, r.duration_ms, r.started_at,
+			COALESCE(r.results, 'null')
+		FROM %s_at_run r LEFT JOIN %s_at a ON a.id = r.at_id
+	`, wid, wid)
+	if len(where) > 0 {
+		query += " WHERE " + strings.Join(where, " AND ")
+	}
+	query += " ORDER BY r.started_at DESC LIMIT ?"
+	args = append(args, limit)
+
+	rows, err := WorkspaceDB.Query(query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	result := []ATRunSample{}
+	for rows.Next() {
+		var sample ATRunSample
+		var results string
+		if err := rows.Scan(&sample.ATID, &sample.Path, &sample.Tag, &sample.Method, &sample.URL, &sample.Status,
+			&sample.StatusCode, &sample.DurationMs, &sample.StartedAt, &results); err != nil {
+			return nil, err
+		}
+		sample.Results = json.RawMessage(results)
+		result = append(result, sample)
+	}
+	return result, rows.Err()
+}
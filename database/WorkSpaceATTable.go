@@ -17,6 +17,7 @@ type ATData struct {
 	Body      string `json:"body"`
 	Testcases string `json:"testcases"`
 	Response  string `json:"response"`
+	Protocol  string `json:"protocol"`
 }
 
 
@@ -37,6 +38,7 @@ type AllATData struct {
 	Body      string `json:"body"`
 	Testcases string `json:"testcases"`
 	Response  string `json:"response"`
+	Protocol  string `json:"protocol"`
 }
 
 func CreateATTable(tablePrefix string) error {
@@ -50,7 +52,9 @@ func CreateATTable(tablePrefix string) error {
 			header LONGTEXT NULL,
 			body LONGTEXT NULL,
 			testcases LONGTEXT NULL,
-			response TEXT NULL,
+			response LONGTEXT NULL,
+			response_ref VARCHAR(64) NULL,
+			protocol VARCHAR(8) NOT NULL DEFAULT '',
 			modified_by INT(11) NULL,
 			created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
 			updated_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP ON UPDATE CURRENT_TIMESTAMP
@@ -63,12 +67,25 @@ func CreateATTable(tablePrefix string) error {
 	return nil
 }
 
+// MigrateATTable widens the response column of AT tables created before
+// large response bodies were supported and adds the blob reference column.
+func MigrateATTable(tablePrefix string) error {
+	table := fmt.Sprintf("%s_at", tablePrefix)
+	if err := modifyColumnIfDifferent(table, "response", "LONGTEXT", true); err != nil {
+		return err
+	}
+	if err := addColumnIfMissing(table, "response_ref", "VARCHAR(64) NULL"); err != nil {
+		return err
+	}
+	return addColumnIfMissing(table, "protocol", "VARCHAR(8) NOT NULL DEFAULT ''")
+}
+
 // SaveAsAT creates a new AT record (renamed from SaveATData)
 func SaveAsAT(tablePrefix string, data *ATData, uid int) error {
 	_, err := WorkspaceDB.Exec(fmt.Sprintf(`
-		INSERT INTO %s_at (path, tag, Method, url, header, body, testcases, response, modified_by)
-		VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?)
-	`, tablePrefix), data.Path, data.Tag, data.Method, data.URL, data.Header, data.Body, data.Testcases, data.Response, uid)
+		INSERT INTO %s_at (path, tag, Method, url, header, body, testcases, response, protocol, modified_by)
+		VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?)
+	`, tablePrefix), data.Path, data.Tag, data.Method, data.URL, data.Header, data.Body, data.Testcases, data.Response, data.Protocol, uid)
 
 	return err
 }
@@ -99,6 +116,7 @@ func SaveAT(tablePrefix string, data *ATData, uid int) error {
 			body = ?,
 			testcases = ?,
 			response = ?,
+			protocol = ?,
 			modified_by = ?,
 			updated_at = CURRENT_TIMESTAMP
 		WHERE id = ?
@@ -112,6 +130,7 @@ func SaveAT(tablePrefix string, data *ATData, uid int) error {
 		data.Body,
 		data.Testcases,
 		data.Response,
+		data.Protocol,
 		uid,
 		data.ID)
 
@@ -146,11 +165,11 @@ func FetchPathAT(wid string) ([]PathATData, error) {
 }
 
 func FetchAllAT(wid, id string) (*AllATData, error) {
-	query := fmt.Sprintf("SELECT id, path, tag, Method, url, header, body, testcases, response FROM %s_at WHERE id = ?", wid)
+	query := fmt.Sprintf("SELECT id, path, tag, Method, url, header, body, testcases, response, protocol FROM %s_at WHERE id = ?", wid)
 	var data AllATData
 	err := WorkspaceDB.QueryRow(query, id).Scan(
 		&data.ID, &data.Path, &data.Tag, &data.Method, &data.URL,
-		&data.Header, &data.Body, &data.Testcases, &data.Response,
+		&data.Header, &data.Body, &data.Testcases, &data.Response, &data.Protocol,
 	)
 	if err == sql.ErrNoRows {
 		return nil, nil
@@ -187,11 +206,11 @@ func UserHasAccessToWorkspace(uid int, wid string) (bool, error) {
 }
 
 func GetWorkspaceFromID(wid, id string) (*AllATData, error) {
-    query := fmt.Sprintf("SELECT id, path, tag, Method, url, header, body, testcases, response FROM %s_at WHERE id = ?", wid)
+    query := fmt.Sprintf("SELECT id, path, tag, Method, url, header, body, testcases, response, protocol FROM %s_at WHERE id = ?", wid)
     var data AllATData
     err := WorkspaceDB.QueryRow(query, id).Scan(
         &data.ID, &data.Path, &data.Tag, &data.Method, &data.URL,
-        &data.Header, &data.Body, &data.Testcases, &data.Response,
+        &data.Header, &data.Body, &data.Testcases, &data.Response, &data.Protocol,
     )
     if err == sql.ErrNoRows {
         return nil, nil
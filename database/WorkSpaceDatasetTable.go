@@ -0,0 +1,99 @@
+package database
+
+import (
+	"database/sql"
+	"fmt"
+	"log"
+)
+
+type DatasetData struct {
+	DID      int    `json:"did"`
+	Name     string `json:"name"`
+	Format   string `json:"format"`
+	Data     string `json:"data"` // JSON array of row objects
+	RowCount int    `json:"row_count"`
+}
+
+type PathDatasetData struct {
+	DID      int    `json:"did"`
+	Name     string `json:"name"`
+	Format   string `json:"format"`
+	RowCount int    `json:"row_count"`
+}
+
+func CreateDatasetTable(tablePrefix string) error {
+	_, err := WorkspaceDB.Exec(fmt.Sprintf(`
+		CREATE TABLE IF NOT EXISTS %s_dataset (
+			did INT(11) AUTO_INCREMENT PRIMARY KEY,
+			name TINYTEXT NULL,
+			format VARCHAR(8) NULL,
+			data LONGTEXT NULL,
+			row_count INT(11) NOT NULL DEFAULT 0,
+			modified_by INT(11) NULL,
+			created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP
+		)
+	`, tablePrefix))
+	if err != nil {
+		log.Printf("Failed to create Dataset table: %v", err)
+		return err
+	}
+	return nil
+}
+
+func SaveDataset(wid string, data *DatasetData, uid int) (int, error) {
+	result, err := WorkspaceDB.Exec(fmt.Sprintf(`
+		INSERT INTO %s_dataset (name, format, data, row_count, modified_by)
+		VALUES (?, ?, ?, ?, ?)
+	`, wid), data.Name, data.Format, data.Data, data.RowCount, uid)
+	if err != nil {
+		return 0, err
+	}
+
+	id, err := result.LastInsertId()
+	if err != nil {
+		return 0, err
+	}
+	return int(id), nil
+}
+
+func FetchPathDataset(wid string) ([]PathDatasetData, error) {
+	query := fmt.Sprintf("SELECT did, COALESCE(name, ''), COALESCE(format, ''), row_count FROM %s_dataset", wid)
+	rows, err := WorkspaceDB.Query(query)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	result := []PathDatasetData{}
+	for rows.Next() {
+		var data PathDatasetData
+		if err := rows.Scan(&data.DID, &data.Name, &data.Format, &data.RowCount); err != nil {
+			return nil, err
+		}
+		result = append(result, data)
+	}
+
+	return result, rows.Err()
+}
+
+func FetchDataset(wid, did string) (*DatasetData, error) {
+	query := fmt.Sprintf("SELECT did, COALESCE(name, ''), COALESCE(format, ''), COALESCE(data, '[]'), row_count FROM %s_dataset WHERE did = ?", wid)
+	var data DatasetData
+	err := WorkspaceDB.QueryRow(query, did).Scan(&data.DID, &data.Name, &data.Format, &data.Data, &data.RowCount)
+	if err == sql.ErrNoRows {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	return &data, nil
+}
+
+func DeleteDataset(wid, did string) (bool, error) {
+	result, err := WorkspaceDB.Exec(fmt.Sprintf("DELETE FROM %s_dataset WHERE did = ?", wid), did)
+	if err != nil {
+		return false, err
+	}
+	n, _ := result.RowsAffected()
+	return n > 0, nil
+}
@@ -0,0 +1,108 @@
+package database
+
+import (
+	"database/sql"
+	"fmt"
+	"log"
+)
+
+// FlowRevisionData is an immutable snapshot of a flow, taken on every save.
+// Version matches the flow's version column at the time of the save.
+type FlowRevisionData struct {
+	FID        int    `json:"fid"`
+	Version    int    `json:"version"`
+	Name       string `json:"name"`
+	FlowData   string `json:"flow_data,omitempty"`
+	NodeData   string `json:"node_data,omitempty"`
+	Message    string `json:"message"`
+	ModifiedBy int    `json:"modified_by"`
+	CreatedAt  string `json:"created_at"`
+}
+
+// CreateFlowRevisionTable creates the revision table and records the
+// current state of flows saved before revisions existed as their first
+// revision.
+func CreateFlowRevisionTable(tablePrefix string) error {
+	_, err := WorkspaceDB.Exec(fmt.Sprintf(`
+		CREATE TABLE IF NOT EXISTS %s_flow_revision (
+			id INT(11) AUTO_INCREMENT PRIMARY KEY,
+			fid INT(11) NOT NULL,
+			version INT(11) NOT NULL,
+			name TINYTEXT NULL,
+			flow_data LONGTEXT NULL,
+			node_data LONGTEXT NULL,
+			message TEXT NULL,
+			modified_by INT(11) NULL,
+			created_at DATETIME(3) NOT NULL DEFAULT CURRENT_TIMESTAMP(3),
+			UNIQUE KEY uniq_flow_revision (fid, version)
+		)
+	`, tablePrefix))
+	if err != nil {
+		log.Printf("Failed to create Flow revision table: %v", err)
+		return err
+	}
+	if err := addColumnIfMissing(fmt.Sprintf("%s_flow_revision", tablePrefix), "node_data", "LONGTEXT NULL"); err != nil {
+		return err
+	}
+
+	_, err = WorkspaceDB.Exec(fmt.Sprintf(`
+		INSERT INTO %[1]s_flow_revision (fid, version, name, flow_data, node_data, message, modified_by)
+		SELECT f.fid, f.version, f.name, f.flow_data, f.node_data, 'Existing flow', f.modified_by
+		FROM %[1]s_flow f
+		WHERE NOT EXISTS (
+			SELECT 1 FROM %[1]s_flow_revision r WHERE r.fid = f.fid AND r.version = f.version
+		)
+	`, tablePrefix))
+	if err != nil {
+		log.Printf("Failed to record existing flows as revisions: %v", err)
+	}
+	return err
+}
+
+func insertFlowRevision(tx *sql.Tx, wid string, fid, version int, name, flowData, nodeData, message string, uid int) error {
+	_, err := tx.Exec(fmt.Sprintf(`
+		INSERT INTO %s_flow_revision (fid, version, name, flow_data, node_data, message, modified_by)
+		VALUES (?, ?, ?, ?, ?, ?, ?)
+	`, wid), fid, version, name, flowData, nodeData, message, uid)
+	return err
+}
+
+// FetchFlowRevisions lists the revisions of a flow, newest first, without
+// their flow data.
+func FetchFlowRevisions(wid, fid string) ([]FlowRevisionData, error) {
+	rows, err := WorkspaceDB.Query(fmt.Sprintf(`
+		SELECT fid, version, COALESCE(name, ''), COALESCE(message, ''), COALESCE(modified_by, 0), created_at
+		FROM %s_flow_revision WHERE fid = ? ORDER BY version DESC
+	`, wid), fid)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	result := []FlowRevisionData{}
+	for rows.Next() {
+		var data FlowRevisionData
+		if err := rows.Scan(&data.FID, &data.Version, &data.Name, &data.Message, &data.ModifiedBy, &data.CreatedAt); err != nil {
+			return nil, err
+		}
+		result = append(result, data)
+	}
+	return result, rows.Err()
+}
+
+// FetchFlowRevision returns one revision of a flow.
+func FetchFlowRevision(wid, fid string, version int) (*FlowRevisionData, error) {
+	var data FlowRevisionData
+	err := WorkspaceDB.QueryRow(fmt.Sprintf(`
+		SELECT fid, version, COALESCE(name, ''), COALESCE(flow_data, ''), COALESCE(node_data, ''), COALESCE(message, ''),
+			COALESCE(modified_by, 0), created_at
+		FROM %s_flow_revision WHERE fid = ? AND version = ?
+	`, wid), fid, version).Scan(&data.FID, &data.Version, &data.Name, &data.FlowData, &data.NodeData, &data.Message, &data.ModifiedBy, &data.CreatedAt)
+	if err == sql.ErrNoRows {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	return &data, nil
+}
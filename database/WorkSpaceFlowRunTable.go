@@ -0,0 +1,291 @@
+package database
+
+import (
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"log"
+	"strings"
+)
+
+// RunTimeFormat is the layout of run timestamps, which are stored in UTC.
+const RunTimeFormat = "2006-01-02 15:04:05.000"
+
+// FlowRunData is a persisted flow run.
+type FlowRunData struct {
+	RID         string          `json:"rid"`
+	FID         int             `json:"fid"`
+	FlowVersion int             `json:"flow_version"`         // revision the run executed
+	ParentRID   string          `json:"parent_rid,omitempty"` // run this run resumed
+	Status      string          `json:"status"`
+	Trigger     string          `json:"trigger"`               // manual or dataset
+	TriggerRef  string          `json:"trigger_ref,omitempty"` // dataset run ID for dataset rows
+	Error       string          `json:"error,omitempty"`
+	Env         json.RawMessage `json:"env"`       // env the run started with
+	FinalEnv    json.RawMessage `json:"final_env"` // env when the run finished
+	SessionID   string          `json:"session_id,omitempty"`
+	StartedBy   int             `json:"started_by"`
+	StartedAt   string          `json:"started_at"`
+	FinishedAt  string          `json:"finished_at,omitempty"`
+	DurationMs  int64           `json:"duration_ms"`
+	// SessionCookies is a snapshot of the run's cookie session when it
+	// finished, restored when the run is resumed after the session is gone.
+	// It holds credentials, so it is never sent to clients.
+	SessionCookies json.RawMessage `json:"-"`
+}
+
+// FlowRunNodeData is the result of one top-level node of a persisted run.
+// Data holds the full node result, including the request, the response,
+// the assertion results and any loop iterations or sub-flow report.
+type FlowRunNodeData struct {
+	Position   int             `json:"position"`
+	NodeID     string          `json:"node_id"`
+	ATID       string          `json:"at_id,omitempty"`
+	Status     string          `json:"status"`
+	Error      string          `json:"error,omitempty"`
+	DurationMs int64           `json:"duration_ms"`
+	Data       json.RawMessage `json:"data"`
+}
+
+// FlowRunFilter narrows FetchFlowRuns. Empty fields do not filter; From and
+// To are UTC times in RunTimeFormat.
+type FlowRunFilter struct {
+	FID        string
+	Status     string
+	ParentRID  string
+	Trigger    string
+	TriggerRef string
+	From       string
+	To         string
+	Limit      int
+	Offset     int
+}
+
+func CreateFlowRunTable(tablePrefix string) error {
+	_, err := WorkspaceDB.Exec(fmt.Sprintf(`
+		CREATE TABLE IF NOT EXISTS %s_flow_run (
+			rid VARCHAR(64) PRIMARY KEY,
+			fid INT(11) NOT NULL,
+			flow_version INT(11) NOT NULL DEFAULT 0,
+			parent_rid VARCHAR(64) NULL,
+			status VARCHAR(16) NOT NULL,
+			trigger_source VARCHAR(16) NOT NULL DEFAULT 'manual',
+			trigger_ref VARCHAR(64) NULL,
+			error TEXT NULL,
+			env LONGTEXT NULL,
+			final_env LONGTEXT NULL,
+			session_id VARCHAR(64) NULL,
+			started_by INT(11) NULL,
+			started_at DATETIME(3) NOT NULL,
+			finished_at DATETIME(3) NULL,
+			duration_ms BIGINT NOT NULL DEFAULT 0,
+			session_cookies LONGTEXT NULL,
+			INDEX idx_flow_run_fid (fid),
+			INDEX idx_flow_run_started (started_at),
+			INDEX idx_flow_run_status (status),
+			INDEX idx_flow_run_trigger (trigger_ref)
+		)
+	`, tablePrefix))
+	if err != nil {
+		log.Printf("Failed to create Flow run table: %v", err)
+		return err
+	}
+	if err := addColumnIfMissing(fmt.Sprintf("%s_flow_run", tablePrefix), "parent_rid", "VARCHAR(64) NULL"); err != nil {
+		return err
+	}
+	if err := addColumnIfMissing(fmt.Sprintf("%s_flow_run", tablePrefix), "session_cookies", "LONGTEXT NULL"); err != nil {
+		return err
+	}
+	if err := addColumnIfMissing(fmt.Sprintf("%s_flow_run", tablePrefix), "trigger_source", "VARCHAR(16) NOT NULL DEFAULT 'manual'"); err != nil {
+		return err
+	}
+	if err := addColumnIfMissing(fmt.Sprintf("%s_flow_run", tablePrefix), "trigger_ref", "VARCHAR(64) NULL"); err != nil {
+		return err
+	}
+
+	_, err = WorkspaceDB.Exec(fmt.Sprintf(`
+		CREATE TABLE IF NOT EXISTS %s_flow_run_node (
+			id INT(11) AUTO_INCREMENT PRIMARY KEY,
+			rid VARCHAR(64) NOT NULL,
+			position INT(11) NOT NULL,
+			node_id VARCHAR(255) NOT NULL,
+			at_id VARCHAR(64) NULL,
+			status VARCHAR(16) NOT NULL,
+			error TEXT NULL,
+			duration_ms BIGINT NOT NULL DEFAULT 0,
+			data LONGTEXT NULL,
+			INDEX idx_flow_run_node_rid (rid)
+		)
+	`, tablePrefix))
+	if err != nil {
+		log.Printf("Failed to create Flow run node table: %v", err)
+		return err
+	}
+	return nil
+}
+
+// InsertFlowRun records the start of a flow run.
+func InsertFlowRun(wid string, run *FlowRunData) error {
+	_, err := WorkspaceDB.Exec(fmt.Sprintf(`
+		INSERT INTO %s_flow_run (rid, fid, flow_version, parent_rid, status, trigger_source, trigger_ref, env, session_id,
+			started_by, started_at)
+		VALUES (?, ?, ?, NULLIF(?, ''), ?, ?, NULLIF(?, ''), ?, ?, ?, ?)
+	`, wid), run.RID, run.FID, run.FlowVersion, run.ParentRID, run.Status, run.Trigger, run.TriggerRef, string(run.Env),
+		run.SessionID, run.StartedBy, run.StartedAt)
+	return err
+}
+
+// FinishFlowRun stores the outcome of a flow run and its node results.
+func FinishFlowRun(wid string, run *FlowRunData, nodes []FlowRunNodeData) error {
+	tx, err := WorkspaceDB.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	_, err = tx.Exec(fmt.Sprintf(`
+		UPDATE %s_flow_run SET status = ?, error = ?, final_env = ?, finished_at = ?, duration_ms = ?,
+			session_cookies = NULLIF(?, '')
+		WHERE rid = ?
+	`, wid), run.Status, run.Error, string(run.FinalEnv), run.FinishedAt, run.DurationMs, string(run.SessionCookies), run.RID)
+	if err != nil {
+		return err
+	}
+
+	insert := fmt.Sprintf(`
+		INSERT INTO %s_flow_run_node (rid, position, node_id, at_id, status, error, duration_ms, data)
+		VALUES (?, ?, ?, ?, ?, ?, ?, ?)
+	`, wid)
+	for _, node := range nodes {
+		_, err := tx.Exec(insert, run.RID, node.Position, node.NodeID, node.ATID, node.Status, node.Error, node.DurationMs, string(node.Data))
+		if err != nil {
+			return err
+		}
+	}
+	return tx.Commit()
+}
+
+const flowRunColumns = `rid, fid, flow_version, COALESCE(parent_rid, ''), status, trigger_source, COALESCE(trigger_ref, ''),
+	COALESCE(error, ''), COALESCE(env, 'null'),
+	COALESCE(final_env, 'null'), COALESCE(session_id, ''), COALESCE(started_by, 0),
+	started_at, COALESCE(finished_at, ''), duration_ms, COALESCE(session_cookies, 'null')`
+
+func scanFlowRun(scan func(dest ...interface{}) error) (FlowRunData, error) {
+	var run FlowRunData
+	var env, finalEnv, cookies string
+	err := scan(&run.RID, &run.FID, &run.FlowVersion, &run.ParentRID, &run.Status, &run.Trigger, &run.TriggerRef, &run.Error, &env,
+		&finalEnv, &run.SessionID, &run.StartedBy, &run.StartedAt, &run.FinishedAt, &run.DurationMs, &cookies)
+	run.Env = json.RawMessage(env)
+	run.FinalEnv = json.RawMessage(finalEnv)
+	run.SessionCookies = json.RawMessage(cookies)
+	return run, err
+}
+
+// FetchFlowRuns lists flow runs, newest first.
+func FetchFlowRuns(wid string, filter FlowRunFilter) ([]FlowRunData, error) {
+	var where []string
+	var args []interface{}
+	if filter.FID != "" {
+		where = append(where, "fid = ?")
+		args = append(args, filter.FID)
+	}
+	if filter.Status != "" {
+		where = append(where, "status = ?")
+		args = append(args, filter.Status)
+	}
+	if filter.ParentRID != "" {
+		where = append(where, "parent_rid = ?")
+		args = append(args, filter.ParentRID)
+	}
+	if filter.Trigger != "" {
+		where = append(where, "trigger_source = ?")
+		args = append(args, filter.Trigger)
+	}
+	if filter.TriggerRef != "" {
+		where = append(where, "trigger_ref = ?")
+		args = append(args, filter.TriggerRef)
+	}
+	if filter.From != "" {
+		where = append(where, "started_at >= ?")
+		args = append(args, filter.From)
+	}
+	if filter.To != "" {
+		where = append(where, "started_at < ?")
+		args = append(args, filter.To)
+	}
+
+	query := fmt.Sprintf("SELECT %s FROM %s_flow_run", flowRunColumns, wid)
+	if len(where) > 0 {
+		query += " WHERE " + strings.Join(where, " AND ")
+	}
+	query += " ORDER BY started_at DESC LIMIT ? OFFSET ?"
+	args = append(args, filter.Limit, filter.Offset)
+
+	rows, err := WorkspaceDB.Query(query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	result := []FlowRunData{}
+	for rows.Next() {
+		run, err := scanFlowRun(rows.Scan)
+		if err != nil {
+			return nil, err
+		}
+		result = append(result, run)
+	}
+	return result, rows.Err()
+}
+
+// FetchFlowRun returns a flow run with its node results in run order.
+func FetchFlowRun(wid, rid string) (*FlowRunData, []FlowRunNodeData, error) {
+	query := fmt.Sprintf("SELECT %s FROM %s_flow_run WHERE rid = ?", flowRunColumns, wid)
+	run, err := scanFlowRun(WorkspaceDB.QueryRow(query, rid).Scan)
+	if err == sql.ErrNoRows {
+		return nil, nil, nil
+	}
+	if err != nil {
+		return nil, nil, err
+	}
+
+	rows, err := WorkspaceDB.Query(fmt.Sprintf(`
+		SELECT position, node_id, COALESCE(at_id, ''), status, COALESCE(error, ''), duration_ms, COALESCE(data, 'null')
+		FROM %s_flow_run_node WHERE rid = ? ORDER BY position
+	`, wid), rid)
+	if err != nil {
+		return nil, nil, err
+	}
+	defer rows.Close()
+
+	nodes := []FlowRunNodeData{}
+	for rows.Next() {
+		var node FlowRunNodeData
+		var data string
+		if err := rows.Scan(&node.Position, &node.NodeID, &node.ATID, &node.Status, &node.Error, &node.DurationMs, &data); err != nil {
+			return nil, nil, err
+		}
+		node.Data = json.RawMessage(data)
+		nodes = append(nodes, node)
+	}
+	return &run, nodes, rows.Err()
+}
+
+// DeleteFlowRun deletes a flow run and its node results.
+func DeleteFlowRun(wid, rid string) (bool, error) {
+	tx, err := WorkspaceDB.Begin()
+	if err != nil {
+		return false, err
+	}
+	defer tx.Rollback()
+
+	if _, err := tx.Exec(fmt.Sprintf("DELETE FROM %s_flow_run_node WHERE rid = ?", wid), rid); err != nil {
+		return false, err
+	}
+	result, err := tx.Exec(fmt.Sprintf("DELETE FROM %s_flow_run WHERE rid = ?", wid), rid)
+	if err != nil {
+		return false, err
+	}
+	n, _ := result.RowsAffected()
+	return n > 0, tx.Commit()
+}
@@ -22,6 +22,7 @@ type AllFlowData struct {
 	Name     string `json:"name"`
 	FlowData string `json:"flow_data"`
 	NodeData string `json:"node_data"`
+	Version  int    `json:"version"`
 }
 
 func CreateFlowTable(tablePrefix string) error {
@@ -31,6 +32,7 @@ func CreateFlowTable(tablePrefix string) error {
 			name TINYTEXT NULL,
 			flow_data LONGTEXT NULL,
 			node_data LONGTEXT NULL,
+			version INT(11) NOT NULL DEFAULT 1,
 			modified_by INT(11) NULL
 		)
 	`, tablePrefix))
@@ -41,16 +43,12 @@ func CreateFlowTable(tablePrefix string) error {
 	return nil
 }
 
-func SaveFlowData(tablePrefix string, data *FlowData, uid int) error {
-	_, err := WorkspaceDB.Exec(fmt.Sprintf(`
-		INSERT INTO %s_flow (name, flow_data, node_data, modified_by)
-		VALUES (?, ?, ?, ?)
-	`, tablePrefix), data.Name, data.FlowData, data.NodeData, uid)
-
-	return err
+// MigrateFlowTable adds the version column to flow tables created before
+// flows were versioned.
+func MigrateFlowTable(tablePrefix string) error {
+	return addColumnIfMissing(fmt.Sprintf("%s_flow", tablePrefix), "version", "INT(11) NOT NULL DEFAULT 1")
 }
 
-
 func FetchPathFlow(wid string) ([]PathFlowData, error) {
 	query := fmt.Sprintf("SELECT fid, name FROM %s_flow", wid)
 	rows, err := WorkspaceDB.Query(query)
@@ -72,10 +70,10 @@ func FetchPathFlow(wid string) ([]PathFlowData, error) {
 }
 
 func FetchAllFlow(wid, fid string) (*AllFlowData, error) {
-	query := fmt.Sprintf("SELECT fid, name, flow_data, node_data FROM %s_flow WHERE fid = ?", wid)
+	query := fmt.Sprintf("SELECT fid, COALESCE(name, ''), COALESCE(flow_data, ''), COALESCE(node_data, ''), version FROM %s_flow WHERE fid = ?", wid)
 	var data AllFlowData
 	err := WorkspaceDB.QueryRow(query, fid).Scan(
-		&data.FID, &data.Name, &data.FlowData, &data.NodeData,
+		&data.FID, &data.Name, &data.FlowData, &data.NodeData, &data.Version,
 	)
 	if err == sql.ErrNoRows {
 		return nil, nil
@@ -84,4 +82,60 @@ func FetchAllFlow(wid, fid string) (*AllFlowData, error) {
 		return nil, err
 	}
 	return &data, nil
-}
\ No newline at end of file
+}
+
+// InsertFlow stores a new flow as version 1 and records it as the flow's
+// first revision. It returns the new fid.
+func InsertFlow(wid, name, flowData, nodeData, message string, uid int) (int, error) {
+	tx, err := WorkspaceDB.Begin()
+	if err != nil {
+		return 0, err
+	}
+	defer tx.Rollback()
+
+	result, err := tx.Exec(fmt.Sprintf(`
+		INSERT INTO %s_flow (name, flow_data, node_data, modified_by)
+		VALUES (?, ?, ?, ?)
+	`, wid), name, flowData, nodeData, uid)
+	if err != nil {
+		return 0, err
+	}
+
+	id, err := result.LastInsertId()
+	if err != nil {
+		return 0, err
+	}
+	if err := insertFlowRevision(tx, wid, int(id), 1, name, flowData, nodeData, message, uid); err != nil {
+		return 0, err
+	}
+	return int(id), tx.Commit()
+}
+
+// UpdateFlow replaces the name, graph and node data of an existing flow,
+// bumps its version and records the new version as a revision. It returns
+// the new version.
+func UpdateFlow(wid, fid, name, flowData, nodeData, message string, uid int) (int, error) {
+	tx, err := WorkspaceDB.Begin()
+	if err != nil {
+		return 0, err
+	}
+	defer tx.Rollback()
+
+	_, err = tx.Exec(fmt.Sprintf(`
+		UPDATE %s_flow SET name = ?, flow_data = ?, node_data = ?, modified_by = ?, version = version + 1
+		WHERE fid = ?
+	`, wid), name, flowData, nodeData, uid, fid)
+	if err != nil {
+		return 0, err
+	}
+
+	var id, version int
+	err = tx.QueryRow(fmt.Sprintf("SELECT fid, version FROM %s_flow WHERE fid = ?", wid), fid).Scan(&id, &version)
+	if err != nil {
+		return 0, err
+	}
+	if err := insertFlowRevision(tx, wid, id, version, name, flowData, nodeData, message, uid); err != nil {
+		return 0, err
+	}
+	return version, tx.Commit()
+}
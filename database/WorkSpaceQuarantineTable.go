@@ -0,0 +1,102 @@
+package database
+
+import (
+	"fmt"
+	"log"
+)
+
+// QuarantineData is a test case of an AT that is known to be flaky. It is
+// still run and reported, but does not fail the AT.
+type QuarantineData struct {
+	ATID      int    `json:"at_id"`
+	Case      string `json:"case"` // case key, e.g. "check_status" or "check_status#2"
+	Reason    string `json:"reason,omitempty"`
+	CreatedBy int    `json:"created_by"`
+	CreatedAt string `json:"created_at"`
+}
+
+func CreateQuarantineTable(tablePrefix string) error {
+	_, err := WorkspaceDB.Exec(fmt.Sprintf(`
+		CREATE TABLE IF NOT EXISTS %s_at_quarantine (
+			at_id INT(11) NOT NULL,
+			case_key VARCHAR(255) NOT NULL,
+			reason TEXT NULL,
+			created_by INT(11) NULL,
+			created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+			PRIMARY KEY (at_id, case_key)
+		)
+	`, tablePrefix))
+	if err != nil {
+		log.Printf("Failed to create AT quarantine table: %v", err)
+	}
+	return err
+}
+
+// QuarantineCase quarantines a test case, or updates the reason of one that
+// already is.
+func QuarantineCase(wid string, atID int, caseKey, reason string, uid int) error {
+	_, err := WorkspaceDB.Exec(fmt.Sprintf(`
+		INSERT INTO %s_at_quarantine (at_id, case_key, reason, created_by)
+		VALUES (?, ?, NULLIF(?, ''), ?)
+		ON DUPLICATE KEY UPDATE reason = VALUES(reason)
+	`, wid), atID, caseKey, reason, uid)
+	return err
+}
+
+// FetchQuarantine lists the quarantined test cases of an AT, or of every AT
+// when atID is empty.
+func FetchQuarantine(wid, atID string) ([]QuarantineData, error) {
+	query := fmt.Sprintf("SELECT at_id, case_key, COALESCE(reason, ''), COALESCE(created_by, 0), created_at FROM %s_at_quarantine", wid)
+	var args []interface{}
+	if atID != "" {
+		query += " WHERE at_id = ?"
+		args = append(args, atID)
+	}
+	query += " ORDER BY at_id, case_key"
+
+	rows, err := WorkspaceDB.Query(query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	result := []QuarantineData{}
+	for rows.Next() {
+		var data QuarantineData
+		if err := rows.Scan(&data.ATID, &data.Case, &data.Reason, &data.CreatedBy, &data.CreatedAt); err != nil {
+			return nil, err
+		}
+		result = append(result, data)
+	}
+	return result, rows.Err()
+}
+
+// FetchQuarantinedCases returns the keys of the quarantined test cases of an
+// AT.
+func FetchQuarantinedCases(wid, atID string) ([]string, error) {
+	rows, err := WorkspaceDB.Query(fmt.Sprintf("SELECT case_key FROM %s_at_quarantine WHERE at_id = ?", wid), atID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var keys []string
+	for rows.Next() {
+		var key string
+		if err := rows.Scan(&key); err != nil {
+			return nil, err
+		}
+		keys = append(keys, key)
+	}
+	return keys, rows.Err()
+}
+
+// ReleaseCase takes a test case out of quarantine.
+func ReleaseCase(wid string, atID int, caseKey string) (bool, error) {
+	result, err := WorkspaceDB.Exec(fmt.Sprintf("DELETE FROM %s_at_quarantine WHERE at_id = ? AND case_key = ?", wid), atID, caseKey)
+	if err != nil {
+		return false, err
+	}
+	n, _ := result.RowsAffected()
+	return n > 0, nil
+}
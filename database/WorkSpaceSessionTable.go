@@ -0,0 +1,60 @@
+package database
+
+import (
+	"database/sql"
+	"fmt"
+	"log"
+)
+
+type SessionData struct {
+	SID       string `json:"sid"`
+	Cookies   string `json:"cookies"`
+	UpdatedAt string `json:"updated_at"`
+}
+
+func CreateSessionTable(tablePrefix string) error {
+	_, err := WorkspaceDB.Exec(fmt.Sprintf(`
+		CREATE TABLE IF NOT EXISTS %s_session (
+			sid VARCHAR(64) PRIMARY KEY,
+			cookies LONGTEXT NULL,
+			modified_by INT(11) NULL,
+			updated_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP ON UPDATE CURRENT_TIMESTAMP
+		)
+	`, tablePrefix))
+	if err != nil {
+		log.Printf("Failed to create Session table: %v", err)
+		return err
+	}
+	return nil
+}
+
+func SaveSession(wid, sid, cookies string, uid int) error {
+	query := fmt.Sprintf(`
+		INSERT INTO %s_session (sid, cookies, modified_by)
+		VALUES (?, ?, ?)
+		ON DUPLICATE KEY UPDATE
+		cookies = VALUES(cookies),
+		modified_by = VALUES(modified_by)
+	`, wid)
+	_, err := WorkspaceDB.Exec(query, sid, cookies, uid)
+	return err
+}
+
+func FetchSession(wid, sid string) (*SessionData, error) {
+	query := fmt.Sprintf("SELECT sid, cookies, updated_at FROM %s_session WHERE sid = ?", wid)
+	var data SessionData
+	err := WorkspaceDB.QueryRow(query, sid).Scan(&data.SID, &data.Cookies, &data.UpdatedAt)
+	if err == sql.ErrNoRows {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	return &data, nil
+}
+
+func DeleteSession(wid, sid string) error {
+	query := fmt.Sprintf("DELETE FROM %s_session WHERE sid = ?", wid)
+	_, err := WorkspaceDB.Exec(query, sid)
+	return err
+}
@@ -13,6 +13,7 @@ import (
 	"fmt"
 	"encoding/json"
 	"strings"
+	"time"
 )
 
 func HandlePostAT(c echo.Context) error {
@@ -22,8 +23,20 @@ func HandlePostAT(c echo.Context) error {
 		return c.JSON(http.StatusBadRequest, map[string]string{"error": "Invalid request"})
 	}
 	// fmt.Println("ComplexATRequest:",req)
-	results, newEnv, endpointResponse := services.TestEndpoint(req)
+	// This route is unauthenticated, so it never attaches a workspace
+	// session; each call gets a cookie jar of its own
+	opts := services.ExecOptions{Session: services.NewAnonymousSession()}
+	// Nor can it be cancelled through /api/runs/:rid/cancel, so the run ID
+	// is always generated here; closing the connection aborts the run
+	ctx, runID, done, err := startRun(c.Request().Context(), "", "")
+	if err != nil {
+		return err
+	}
+	defer done()
+	results, newEnv, endpointResponse := services.TestEndpointContext(ctx, req, opts)
 	response := types.ATResponse{
+		RunID:            runID,
+		Status:           services.RunStatus(results),
 		Results:          results.Results,
 		AllImpPassed:     results.AllImpPassed,
 		NewEnv:           newEnv,
@@ -112,18 +125,62 @@ func HandlerRunSavedAT(c echo.Context) error {
         log.Printf("Failed to convert AT data: %v", err)
         return echo.NewHTTPError(http.StatusInternalServerError, "Failed to process AT data")
     }
+    req.EndpointData.Quarantined = quarantinedCases(wid, id)
+
+    // Reuse the caller's cookie session if one was given
+    var opts services.ExecOptions
+    if sid := c.QueryParam("session_id"); sid != "" {
+        session, err := loadSession(wid, sid)
+        if err != nil {
+            return err
+        }
+        opts.Session = session
+    }
 
-    // Run the test endpoint
-    results, newEnv, endpointResponse := services.TestEndpoint(req)
+    // Run the test endpoint; the run can be stopped through /api/runs/:rid/cancel
+    ctx, runID, done, err := startRun(c.Request().Context(), c.QueryParam("run_id"), wid)
+    if err != nil {
+        return err
+    }
+    defer done()
+    started := time.Now()
+    envIn := make(map[string]string, len(req.Env))
+    for k, v := range req.Env {
+        envIn[k] = v
+    }
+    results, newEnv, endpointResponse := services.TestEndpointContext(ctx, req, opts)
 
     // Prepare response
     response := types.ATResponse{
+        RunID:            runID,
+        Status:           services.RunStatus(results),
         Results:          results.Results,
         AllImpPassed:     results.AllImpPassed,
         NewEnv:           newEnv,
         EndpointResponse: endpointResponse,
     }
 
+    // Keep the run in the AT's history and as its latest response
+    recordATRun(wid, atExecution{
+        RunID:     runID,
+        ATID:      id,
+        ATHash:    services.ATHash(req.EndpointData),
+        Status:    response.Status,
+        Trigger:   database.TriggerManual,
+        RunBy:     int(uid),
+        StartedAt: started,
+        Request:   results.Request,
+        Response:  &endpointResponse,
+        Results:   results.Results,
+        EnvIn:     envIn,
+        EnvOut:    newEnv,
+    })
+    if responseJSON, err := json.Marshal(response); err == nil {
+        if err := database.SaveATResponse(wid, id, string(responseJSON), endpointResponse.BodyRef); err != nil {
+            log.Printf("Failed to save response of AT %s: %v", id, err)
+        }
+    }
+
     return c.JSON(http.StatusOK, response)
 }
 
@@ -149,6 +206,7 @@ func convertATDataToRequest(atData *database.AllATData) (types.ComplexATRequest,
     // Set Method and URL
     req.EndpointData.Method = atData.Method
     req.EndpointData.URL = atData.URL
+    req.EndpointData.Protocol = atData.Protocol
 
     // Parse Headers
     var headers []HeaderItem
@@ -0,0 +1,190 @@
+package handlers
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/labstack/echo/v4"
+	"zukify.com/database"
+	"zukify.com/types"
+)
+
+// atExecution is what is known about one execution of an AT, whether it ran
+// on its own or as a flow node.
+type atExecution struct {
+	RunID      string
+	ATID       string
+	ATHash     string
+	Status     string
+	Trigger    string
+	TriggerRef string
+	RunBy      int
+	StartedAt  time.Time
+	Request    *types.RenderedRequest
+	Response   *types.EndpointResponse
+	Results    []types.TestResult
+	EnvIn      map[string]string
+	EnvOut     map[string]string
+}
+
+// recordATRun adds an AT execution to the AT's run history. Executions that
+// never sent a request, and failures to persist, are only logged.
+func recordATRun(wid string, exec atExecution) {
+	atID, err := strconv.Atoi(exec.ATID)
+	if err != nil || exec.Request == nil {
+		return
+	}
+
+	run := &database.ATRunData{
+		RID:        exec.RunID,
+		ATID:       atID,
+		ATHash:     exec.ATHash,
+		Status:     exec.Status,
+		Trigger:    exec.Trigger,
+		TriggerRef: exec.TriggerRef,
+		Method:     exec.Request.Method,
+		URL:        exec.Request.URL,
+		RunBy:      exec.RunBy,
+		StartedAt:  exec.StartedAt.UTC().Format(database.RunTimeFormat),
+	}
+	run.Request, _ = json.Marshal(exec.Request)
+	run.Results, _ = json.Marshal(exec.Results)
+	run.EnvIn, _ = json.Marshal(exec.EnvIn)
+	run.EnvOut, _ = json.Marshal(exec.EnvOut)
+	if res := exec.Response; res != nil {
+		run.StatusCode = res.StatusCode
+		run.DurationMs = res.DurationMs
+		run.ResponseHeaders, _ = json.Marshal(res.Headers)
+		run.ResponseBody = res.Body
+		run.ResponseRef = res.BodyRef
+		run.ResponseSize = res.Size
+	}
+
+	if err := database.InsertATRun(wid, run); err != nil {
+		log.Printf("Failed to record run %s of AT %s: %v", run.RID, exec.ATID, err)
+	}
+}
+
+// recordFlowATRuns adds the AT executions of a flow run, including those in
+// loop bodies and sub-flows, to the run history of their ATs. Each execution
+// is recorded as "<flow run ID>.<n>". Results reused from a resumed run are
+// not new executions and are skipped.
+func recordFlowATRuns(wid string, record *database.FlowRunData, report types.FlowRunReport) {
+	finished, err := time.Parse(database.RunTimeFormat, record.FinishedAt)
+	if err != nil {
+		finished = time.Now()
+	}
+
+	n := 0
+	var walk func(results []types.FlowNodeResult)
+	walk = func(results []types.FlowNodeResult) {
+		for _, result := range results {
+			for _, iteration := range result.Iterations {
+				walk(iteration.Nodes)
+			}
+			if result.SubFlow != nil {
+				walk(result.SubFlow.Nodes)
+			}
+			if result.Reused || result.ATID == "" || result.Request == nil {
+				continue
+			}
+			n++
+			started, err := time.Parse(time.RFC3339Nano, result.StartedAt)
+			if err != nil {
+				started = finished
+			}
+			recordATRun(wid, atExecution{
+				RunID:      fmt.Sprintf("%s.%d", record.RID, n),
+				ATID:       result.ATID,
+				ATHash:     result.ATHash,
+				Status:     result.Status,
+				Trigger:    database.TriggerFlow,
+				TriggerRef: record.RID,
+				RunBy:      record.StartedBy,
+				StartedAt:  started,
+				Request:    result.Request,
+				Response:   result.EndpointResponse,
+				Results:    result.Results,
+				EnvIn:      result.EnvIn,
+				EnvOut:     result.NewEnv,
+			})
+		}
+	}
+	walk(report.Nodes)
+}
+
+// HandlerListATRuns lists the AT runs of a workspace, newest first.
+// Optional query parameters: at_id, status, trigger ("manual", "flow" or
+// "dataset"), trigger_ref, from and to (RFC 3339 or YYYY-MM-DD; to is
+// exclusive), limit and offset.
+func HandlerListATRuns(c echo.Context) error {
+	wid := c.QueryParam("wid")
+	if _, err := requireWorkspaceAccess(c, wid); err != nil {
+		return err
+	}
+
+	filter := database.ATRunFilter{
+		ATID:       c.QueryParam("at_id"),
+		Status:     c.QueryParam("status"),
+		Trigger:    c.QueryParam("trigger"),
+		TriggerRef: c.QueryParam("trigger_ref"),
+	}
+	var err error
+	if filter.From, err = parseRunTime(c.QueryParam("from")); err != nil {
+		return echo.NewHTTPError(http.StatusBadRequest, "Invalid from date")
+	}
+	if filter.To, err = parseRunTime(c.QueryParam("to")); err != nil {
+		return echo.NewHTTPError(http.StatusBadRequest, "Invalid to date")
+	}
+	if filter.Limit, filter.Offset, err = parsePage(c); err != nil {
+		return err
+	}
+
+	runs, err := database.FetchATRuns(wid, filter)
+	if err != nil {
+		log.Printf("Failed to fetch AT runs: %v", err)
+		return echo.NewHTTPError(http.StatusInternalServerError, "Failed to fetch AT runs")
+	}
+	return c.JSON(http.StatusOK, runs)
+}
+
+// HandlerGetATRun returns an AT run with its request, response, assertion
+// results and env.
+func HandlerGetATRun(c echo.Context) error {
+	wid := c.QueryParam("wid")
+	if _, err := requireWorkspaceAccess(c, wid); err != nil {
+		return err
+	}
+
+	run, err := database.FetchATRun(wid, c.Param("rid"))
+	if err != nil {
+		log.Printf("Failed to fetch AT run: %v", err)
+		return echo.NewHTTPError(http.StatusInternalServerError, "Failed to fetch AT run")
+	}
+	if run == nil {
+		return echo.NewHTTPError(http.StatusNotFound, "Run not found")
+	}
+	return c.JSON(http.StatusOK, run)
+}
+
+// HandlerDeleteATRun deletes an AT run from the history.
+func HandlerDeleteATRun(c echo.Context) error {
+	wid := c.QueryParam("wid")
+	if _, err := requireWorkspaceAccess(c, wid); err != nil {
+		return err
+	}
+
+	deleted, err := database.DeleteATRun(wid, c.Param("rid"))
+	if err != nil {
+		log.Printf("Failed to delete AT run: %v", err)
+		return echo.NewHTTPError(http.StatusInternalServerError, "Failed to delete AT run")
+	}
+	if !deleted {
+		return echo.NewHTTPError(http.StatusNotFound, "Run not found")
+	}
+	return c.JSON(http.StatusOK, map[string]string{"message": "Run deleted successfully"})
+}
@@ -0,0 +1,37 @@
+package handlers
+
+import (
+	"log"
+	"net/http"
+
+	"github.com/golang-jwt/jwt"
+	"github.com/labstack/echo/v4"
+	"zukify.com/database"
+)
+
+// requireWorkspaceAccess extracts the UID from the JWT claims and checks that
+// the user has access to the workspace. The returned error is ready to be
+// returned from a handler.
+func requireWorkspaceAccess(c echo.Context, wid string) (int, error) {
+	user := c.Get("user").(jwt.MapClaims)
+	uid, ok := user["uid"].(float64)
+	if !ok {
+		log.Printf("Failed to extract UID from token: %v", user)
+		return 0, echo.NewHTTPError(http.StatusInternalServerError, "Invalid token")
+	}
+
+	if wid == "" {
+		return 0, echo.NewHTTPError(http.StatusBadRequest, "Workspace ID (wid) is required")
+	}
+
+	hasAccess, err := database.UserHasAccessToWorkspace(int(uid), wid)
+	if err != nil {
+		log.Printf("Failed to check workspace access: %v", err)
+		return 0, echo.NewHTTPError(http.StatusInternalServerError, "Failed to verify workspace access")
+	}
+	if !hasAccess {
+		return 0, echo.NewHTTPError(http.StatusForbidden, "You don't have access to this workspace")
+	}
+
+	return int(uid), nil
+}
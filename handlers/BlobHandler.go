@@ -0,0 +1,43 @@
+package handlers
+
+import (
+	"log"
+	"net/http"
+	"os"
+
+	"github.com/labstack/echo/v4"
+	"zukify.com/database"
+	"zukify.com/services"
+)
+
+// HandlerFetchBlob streams a response body that was spilled to the blob
+// store because it exceeded the captured size limit. Only blobs referenced
+// by a response of the workspace, the last one of an AT or one in its run
+// history, can be fetched.
+func HandlerFetchBlob(c echo.Context) error {
+	wid := c.QueryParam("wid")
+	if _, err := requireWorkspaceAccess(c, wid); err != nil {
+		return err
+	}
+
+	hash := c.Param("hash")
+	path, ok := services.BlobPath(hash)
+	if !ok {
+		return echo.NewHTTPError(http.StatusBadRequest, "Invalid blob reference")
+	}
+
+	referenced, err := database.BlobReferenced(wid, hash)
+	if err != nil {
+		log.Printf("Failed to look up blob %s: %v", hash, err)
+		return echo.NewHTTPError(http.StatusInternalServerError, "Failed to fetch blob")
+	}
+	if !referenced {
+		return echo.NewHTTPError(http.StatusNotFound, "Blob not found")
+	}
+
+	if _, err := os.Stat(path); err != nil {
+		return echo.NewHTTPError(http.StatusNotFound, "Blob not found")
+	}
+
+	return c.File(path)
+}
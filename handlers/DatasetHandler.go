@@ -0,0 +1,254 @@
+package handlers
+
+import (
+	"encoding/json"
+	"io"
+	"log"
+	"net/http"
+	"path/filepath"
+	"strings"
+
+	"github.com/labstack/echo/v4"
+	"zukify.com/database"
+	"zukify.com/services"
+	"zukify.com/types"
+)
+
+// HandlerUploadDataset stores a CSV or JSON dataset. The file is taken from
+// the "file" form field, or from a JSON body of {name, format, content}.
+func HandlerUploadDataset(c echo.Context) error {
+	wid := c.QueryParam("wid")
+	uid, err := requireWorkspaceAccess(c, wid)
+	if err != nil {
+		return err
+	}
+
+	var name, format string
+	var content []byte
+	if file, err := c.FormFile("file"); err == nil {
+		src, err := file.Open()
+		if err != nil {
+			return echo.NewHTTPError(http.StatusBadRequest, "Failed to read uploaded file")
+		}
+		defer src.Close()
+		if content, err = io.ReadAll(src); err != nil {
+			return echo.NewHTTPError(http.StatusBadRequest, "Failed to read uploaded file")
+		}
+		name = c.FormValue("name")
+		if name == "" {
+			name = file.Filename
+		}
+		format = c.FormValue("format")
+		if format == "" {
+			format = strings.TrimPrefix(strings.ToLower(filepath.Ext(file.Filename)), ".")
+		}
+	} else {
+		var req struct {
+			Name    string `json:"name"`
+			Format  string `json:"format"`
+			Content string `json:"content"`
+		}
+		if err := c.Bind(&req); err != nil {
+			return echo.NewHTTPError(http.StatusBadRequest, "Invalid request body")
+		}
+		name, format, content = req.Name, req.Format, []byte(req.Content)
+	}
+
+	format = strings.ToLower(format)
+	rows, err := services.ParseDataset(format, content)
+	if err != nil {
+		return echo.NewHTTPError(http.StatusBadRequest, err.Error())
+	}
+
+	// Rows are stored normalized, whatever the upload format
+	data, err := json.Marshal(rows)
+	if err != nil {
+		return echo.NewHTTPError(http.StatusInternalServerError, "Failed to encode dataset")
+	}
+	did, err := database.SaveDataset(wid, &database.DatasetData{
+		Name:     name,
+		Format:   format,
+		Data:     string(data),
+		RowCount: len(rows),
+	}, uid)
+	if err != nil {
+		log.Printf("Failed to save dataset: %v", err)
+		return echo.NewHTTPError(http.StatusInternalServerError, "Failed to save dataset")
+	}
+
+	return c.JSON(http.StatusOK, map[string]interface{}{
+		"message":   "Dataset saved successfully",
+		"did":       did,
+		"row_count": len(rows),
+	})
+}
+
+// HandlerListDatasets lists the datasets of a workspace without their rows.
+func HandlerListDatasets(c echo.Context) error {
+	wid := c.QueryParam("wid")
+	if _, err := requireWorkspaceAccess(c, wid); err != nil {
+		return err
+	}
+
+	datasets, err := database.FetchPathDataset(wid)
+	if err != nil {
+		log.Printf("Failed to fetch datasets: %v", err)
+		return echo.NewHTTPError(http.StatusInternalServerError, "Failed to fetch datasets")
+	}
+	return c.JSON(http.StatusOK, datasets)
+}
+
+// HandlerGetDataset returns a dataset with its rows.
+func HandlerGetDataset(c echo.Context) error {
+	wid := c.QueryParam("wid")
+	if _, err := requireWorkspaceAccess(c, wid); err != nil {
+		return err
+	}
+
+	data, rows, err := loadDataset(wid, c.Param("did"))
+	if err != nil {
+		return err
+	}
+	return c.JSON(http.StatusOK, map[string]interface{}{
+		"did":       data.DID,
+		"name":      data.Name,
+		"format":    data.Format,
+		"row_count": data.RowCount,
+		"rows":      rows,
+	})
+}
+
+// HandlerDeleteDataset deletes a dataset.
+func HandlerDeleteDataset(c echo.Context) error {
+	wid := c.QueryParam("wid")
+	if _, err := requireWorkspaceAccess(c, wid); err != nil {
+		return err
+	}
+
+	deleted, err := database.DeleteDataset(wid, c.Param("did"))
+	if err != nil {
+		log.Printf("Failed to delete dataset: %v", err)
+		return echo.NewHTTPError(http.StatusInternalServerError, "Failed to delete dataset")
+	}
+	if !deleted {
+		return echo.NewHTTPError(http.StatusNotFound, "Dataset not found")
+	}
+	return c.JSON(http.StatusOK, map[string]string{"message": "Dataset deleted successfully"})
+}
+
+// HandlerRunDataset runs a saved AT or flow once per dataset row and returns
+// the aggregated report. The body is {target: "at"|"flow", id, env, run_id}.
+// Each row is recorded in the run history like a run of its own, as
+// "<run_id>.<n>" with the dataset trigger and the dataset run's ID as its
+// trigger_ref, and streams its events under that ID.
+func HandlerRunDataset(c echo.Context) error {
+	wid := c.QueryParam("wid")
+	uid, err := requireWorkspaceAccess(c, wid)
+	if err != nil {
+		return err
+	}
+	did := c.Param("did")
+
+	var req struct {
+		Target string            `json:"target"`
+		ID     types.FlexibleID  `json:"id"`
+		Env    map[string]string `json:"env"`
+		RunID  string            `json:"run_id"`
+	}
+	if err := c.Bind(&req); err != nil {
+		return echo.NewHTTPError(http.StatusBadRequest, "Invalid request body")
+	}
+	if req.ID == "" {
+		return echo.NewHTTPError(http.StatusBadRequest, "ID is required")
+	}
+	id := string(req.ID)
+
+	_, rows, err := loadDataset(wid, did)
+	if err != nil {
+		return err
+	}
+
+	ctx, runID, done, err := startRun(c.Request().Context(), req.RunID, wid)
+	if err != nil {
+		return err
+	}
+	defer done()
+
+	var report types.DatasetRunReport
+	switch req.Target {
+	case "at":
+		atReq, err := newATResolver(wid)(id)
+		if err != nil {
+			return echo.NewHTTPError(http.StatusNotFound, err.Error())
+		}
+		hash := services.ATHash(atReq)
+		recordRow := func(rowRunID string, env map[string]string, session *services.Session) (services.EventSink, func(services.DatasetRowOutcome)) {
+			events := services.NewRunEvents(rowRunID, wid, true)
+			return events.Publish, func(outcome services.DatasetRowOutcome) {
+				defer events.Close()
+				recordATRun(wid, atExecution{
+					RunID:      rowRunID,
+					ATID:       id,
+					ATHash:     hash,
+					Status:     services.RunStatus(outcome.Response),
+					Trigger:    database.TriggerDataset,
+					TriggerRef: runID,
+					RunBy:      uid,
+					StartedAt:  outcome.StartedAt,
+					Request:    outcome.Response.Request,
+					Response:   &outcome.Endpoint,
+					Results:    outcome.Response.Results,
+					EnvIn:      env,
+					EnvOut:     outcome.NewEnv,
+				})
+			}
+		}
+		report = services.RunATDataset(ctx, runID, types.ComplexATRequest{EndpointData: atReq, Env: req.Env}, rows, services.ExecOptions{}, recordRow)
+	case "flow":
+		flow, version, err := loadFlow(wid, id)
+		if err != nil {
+			return err
+		}
+		opts := services.FlowRunOptions{RunID: runID, FID: id, ResolveAT: newATResolver(wid), ResolveFlow: newFlowResolver(wid)}
+		recordRow := func(rowRunID string, env map[string]string, session *services.Session) (services.EventSink, func(services.DatasetRowOutcome)) {
+			events := services.NewRunEvents(rowRunID, wid, true)
+			rowOpts := opts
+			rowOpts.RunID = rowRunID
+			rowOpts.Exec.Session = session
+			record := startFlowRunRecord(wid, id, version, uid, env, "", database.TriggerDataset, runID, rowOpts)
+			return events.Publish, func(outcome services.DatasetRowOutcome) {
+				defer events.Close()
+				outcome.Flow.Revision = version
+				finishFlowRunRecord(wid, record, *outcome.Flow, session)
+			}
+		}
+		report = services.RunFlowDataset(ctx, flow, req.Env, rows, opts, recordRow)
+	default:
+		return echo.NewHTTPError(http.StatusBadRequest, "Target must be \"at\" or \"flow\"")
+	}
+
+	report.RunID = runID
+	report.DID = did
+	report.Target = req.Target
+	report.TargetID = id
+	return c.JSON(http.StatusOK, report)
+}
+
+// loadDataset fetches a dataset and decodes its rows.
+func loadDataset(wid, did string) (*database.DatasetData, []map[string]string, error) {
+	data, err := database.FetchDataset(wid, did)
+	if err != nil {
+		log.Printf("Failed to fetch dataset: %v", err)
+		return nil, nil, echo.NewHTTPError(http.StatusInternalServerError, "Failed to fetch dataset")
+	}
+	if data == nil {
+		return nil, nil, echo.NewHTTPError(http.StatusNotFound, "Dataset not found")
+	}
+
+	var rows []map[string]string
+	if err := json.Unmarshal([]byte(data.Data), &rows); err != nil {
+		log.Printf("Failed to decode dataset %s: %v", did, err)
+		return nil, nil, echo.NewHTTPError(http.StatusInternalServerError, "Dataset data is invalid")
+	}
+	return data, rows, nil
+}
@@ -0,0 +1,170 @@
+package handlers
+
+import (
+	"encoding/json"
+	"log"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/labstack/echo/v4"
+	"zukify.com/database"
+	"zukify.com/services"
+)
+
+const (
+	defaultFlakyWindow  = 30 * 24 * time.Hour
+	defaultFlakyMinRuns = 5
+)
+
+// quarantinedCases returns the quarantined test cases of an AT. A failure is
+// logged and treated as no quarantine, so the AT still runs.
+func quarantinedCases(wid, atID string) []string {
+	keys, err := database.FetchQuarantinedCases(wid, atID)
+	if err != nil {
+		log.Printf("Failed to fetch quarantined cases of AT %s: %v", atID, err)
+	}
+	return keys
+}
+
+// HandlerListFlakyATs scores the ATs and test cases of a workspace by how
+// often they flip between passing and failing with no change to the AT.
+// Optional query parameters: at_id, from and to (RFC 3339 or YYYY-MM-DD; the
+// last 30 days by default), min_runs (default 5) and limit.
+func HandlerListFlakyATs(c echo.Context) error {
+	wid := c.QueryParam("wid")
+	if _, err := requireWorkspaceAccess(c, wid); err != nil {
+		return err
+	}
+
+	from := c.QueryParam("from")
+	if from == "" {
+		from = time.Now().Add(-defaultFlakyWindow).Format(time.RFC3339)
+	}
+	fromTime, err := parseRunTime(from)
+	if err != nil {
+		return echo.NewHTTPError(http.StatusBadRequest, "Invalid from date")
+	}
+	toTime, err := parseRunTime(c.QueryParam("to"))
+	if err != nil {
+		return echo.NewHTTPError(http.StatusBadRequest, "Invalid to date")
+	}
+	minRuns := defaultFlakyMinRuns
+	if value := c.QueryParam("min_runs"); value != "" {
+		if minRuns, err = strconv.Atoi(value); err != nil || minRuns < 2 {
+			return echo.NewHTTPError(http.StatusBadRequest, "min_runs must be at least 2")
+		}
+	}
+	limit, _, err := parsePage(c)
+	if err != nil {
+		return err
+	}
+
+	atID := c.QueryParam("at_id")
+	outcomes, err := database.FetchATRunOutcomes(wid, atID, fromTime, toTime)
+	if err != nil {
+		log.Printf("Failed to fetch AT runs: %v", err)
+		return echo.NewHTTPError(http.StatusInternalServerError, "Failed to fetch AT runs")
+	}
+	quarantine, err := database.FetchQuarantine(wid, atID)
+	if err != nil {
+		log.Printf("Failed to fetch quarantined cases: %v", err)
+		return echo.NewHTTPError(http.StatusInternalServerError, "Failed to fetch quarantined cases")
+	}
+
+	runs := make([]services.FlakyRun, 0, len(outcomes))
+	for _, outcome := range outcomes {
+		run := services.FlakyRun{ATID: outcome.ATID, ATHash: outcome.ATHash, Status: outcome.Status}
+		json.Unmarshal(outcome.Results, &run.Results)
+		runs = append(runs, run)
+	}
+	quarantined := make(map[int][]string)
+	for _, q := range quarantine {
+		quarantined[q.ATID] = append(quarantined[q.ATID], q.Case)
+	}
+
+	report := services.ScoreFlakiness(runs, minRuns, quarantined)
+	report.ATs = report.ATs[:min(len(report.ATs), limit)]
+	report.Cases = report.Cases[:min(len(report.Cases), limit)]
+	return c.JSON(http.StatusOK, report)
+}
+
+// HandlerListQuarantine lists the quarantined test cases of a workspace, or
+// of the AT given by "at_id".
+func HandlerListQuarantine(c echo.Context) error {
+	wid := c.QueryParam("wid")
+	if _, err := requireWorkspaceAccess(c, wid); err != nil {
+		return err
+	}
+
+	quarantine, err := database.FetchQuarantine(wid, c.QueryParam("at_id"))
+	if err != nil {
+		log.Printf("Failed to fetch quarantined cases: %v", err)
+		return echo.NewHTTPError(http.StatusInternalServerError, "Failed to fetch quarantined cases")
+	}
+	return c.JSON(http.StatusOK, quarantine)
+}
+
+// HandlerQuarantineCase quarantines a test case of an AT. The case keeps
+// running and its result is reported with "quarantined": true, but it no
+// longer fails the AT. Body: {"at_id": 12, "case": "check_response_time",
+// "reason": "..."}; case is a key as reported by the flaky endpoint.
+func HandlerQuarantineCase(c echo.Context) error {
+	wid := c.QueryParam("wid")
+	uid, err := requireWorkspaceAccess(c, wid)
+	if err != nil {
+		return err
+	}
+
+	var req struct {
+		ATID   int    `json:"at_id"`
+		Case   string `json:"case"`
+		Reason string `json:"reason"`
+	}
+	if err := c.Bind(&req); err != nil {
+		return echo.NewHTTPError(http.StatusBadRequest, "Invalid request body")
+	}
+	req.Case = strings.TrimSpace(req.Case)
+	if req.Case == "" {
+		return echo.NewHTTPError(http.StatusBadRequest, "Case is required")
+	}
+
+	atData, err := database.FetchAllAT(wid, strconv.Itoa(req.ATID))
+	if err != nil {
+		log.Printf("Failed to fetch AT data: %v", err)
+		return echo.NewHTTPError(http.StatusInternalServerError, "Failed to fetch AT data")
+	}
+	if atData == nil {
+		return echo.NewHTTPError(http.StatusNotFound, "AT data not found")
+	}
+
+	if err := database.QuarantineCase(wid, req.ATID, req.Case, req.Reason, uid); err != nil {
+		log.Printf("Failed to quarantine case: %v", err)
+		return echo.NewHTTPError(http.StatusInternalServerError, "Failed to quarantine case")
+	}
+	return c.JSON(http.StatusOK, map[string]string{"message": "Case quarantined successfully"})
+}
+
+// HandlerReleaseCase takes the test case given by "at_id" and "case" out of
+// quarantine.
+func HandlerReleaseCase(c echo.Context) error {
+	wid := c.QueryParam("wid")
+	if _, err := requireWorkspaceAccess(c, wid); err != nil {
+		return err
+	}
+
+	atID, err := strconv.Atoi(c.QueryParam("at_id"))
+	if err != nil {
+		return echo.NewHTTPError(http.StatusBadRequest, "Invalid at_id")
+	}
+	released, err := database.ReleaseCase(wid, atID, c.QueryParam("case"))
+	if err != nil {
+		log.Printf("Failed to release case: %v", err)
+		return echo.NewHTTPError(http.StatusInternalServerError, "Failed to release case")
+	}
+	if !released {
+		return echo.NewHTTPError(http.StatusNotFound, "Case is not quarantined")
+	}
+	return c.JSON(http.StatusOK, map[string]string{"message": "Case released successfully"})
+}
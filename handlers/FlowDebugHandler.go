@@ -0,0 +1,119 @@
+package handlers
+
+import (
+	"net/http"
+
+	"github.com/labstack/echo/v4"
+	"zukify.com/services"
+)
+
+// debugEnvRequest edits the env of the node a debug run is paused before.
+type debugEnvRequest struct {
+	Env   map[string]string `json:"env"`
+	Unset []string          `json:"unset"`
+}
+
+// HandlerGetFlowDebug returns the state of a debug run: whether it is
+// paused and, if so, the node it is paused before, the env that node will
+// start from and the request it will send.
+func HandlerGetFlowDebug(c echo.Context) error {
+	debug, err := loadDebugSession(c)
+	if err != nil {
+		return err
+	}
+	return c.JSON(http.StatusOK, debug.State())
+}
+
+// HandlerSetFlowBreakpoints replaces the breakpoints of a debug run.
+func HandlerSetFlowBreakpoints(c echo.Context) error {
+	debug, err := loadDebugSession(c)
+	if err != nil {
+		return err
+	}
+
+	var req struct {
+		Breakpoints []string `json:"breakpoints"`
+	}
+	if err := c.Bind(&req); err != nil {
+		return echo.NewHTTPError(http.StatusBadRequest, "Invalid request body")
+	}
+	debug.SetBreakpoints(req.Breakpoints)
+	return c.JSON(http.StatusOK, debug.State())
+}
+
+// HandlerSetFlowDebugEnv edits the env of the paused node and renders its
+// request again.
+func HandlerSetFlowDebugEnv(c echo.Context) error {
+	debug, err := loadDebugSession(c)
+	if err != nil {
+		return err
+	}
+
+	var req debugEnvRequest
+	if err := c.Bind(&req); err != nil {
+		return echo.NewHTTPError(http.StatusBadRequest, "Invalid request body")
+	}
+	if err := debug.SetEnv(req.Env, req.Unset); err != nil {
+		return echo.NewHTTPError(http.StatusConflict, "Run is not paused")
+	}
+	return c.JSON(http.StatusOK, debug.State())
+}
+
+// HandlerContinueFlowDebug resumes a paused debug run until its next
+// breakpoint, after applying any env edits in the body.
+func HandlerContinueFlowDebug(c echo.Context) error {
+	return resumeFlowDebug(c, false)
+}
+
+// HandlerStepFlowDebug runs the paused node and pauses again before the
+// next one, after applying any env edits in the body.
+func HandlerStepFlowDebug(c echo.Context) error {
+	return resumeFlowDebug(c, true)
+}
+
+// HandlerAbortFlowDebug cancels a debug run. Teardown nodes still run.
+func HandlerAbortFlowDebug(c echo.Context) error {
+	debug, err := loadDebugSession(c)
+	if err != nil {
+		return err
+	}
+	if !services.CancelRun(debug.RunID, debug.WID) {
+		return echo.NewHTTPError(http.StatusNotFound, "Run not found")
+	}
+	return c.JSON(http.StatusOK, map[string]string{"message": "Run aborted"})
+}
+
+func resumeFlowDebug(c echo.Context, step bool) error {
+	debug, err := loadDebugSession(c)
+	if err != nil {
+		return err
+	}
+
+	var req debugEnvRequest
+	if err := c.Bind(&req); err != nil {
+		return echo.NewHTTPError(http.StatusBadRequest, "Invalid request body")
+	}
+	if len(req.Env) > 0 || len(req.Unset) > 0 {
+		if err := debug.SetEnv(req.Env, req.Unset); err != nil {
+			return echo.NewHTTPError(http.StatusConflict, "Run is not paused")
+		}
+	}
+	if err := debug.Continue(step); err != nil {
+		return echo.NewHTTPError(http.StatusConflict, "Run is not paused")
+	}
+	return c.JSON(http.StatusOK, debug.State())
+}
+
+// loadDebugSession returns the debug session of the run in the path.
+func loadDebugSession(c echo.Context) (*services.DebugSession, error) {
+	wid := c.QueryParam("wid")
+	if _, err := requireWorkspaceAccess(c, wid); err != nil {
+		return nil, err
+	}
+
+	debug, ok := services.GetDebugSession(c.Param("rid"))
+	if !ok || debug.WID != wid {
+		return nil, echo.NewHTTPError(http.StatusNotFound, "Debug run not found")
+	}
+	return debug, nil
+}
@@ -0,0 +1,70 @@
+package handlers
+
+import (
+	"encoding/json"
+	"log"
+	"net/http"
+	"strconv"
+
+	"github.com/labstack/echo/v4"
+	"zukify.com/database"
+	"zukify.com/services"
+	"zukify.com/types"
+)
+
+// HandlerFlowDiagram renders a saved flow as Mermaid ("format=mermaid", the
+// default) or Graphviz DOT ("format=dot") text. With "run=<rid>" the nodes
+// are coloured with the outcome of that run, and the flow is drawn as it
+// was when the run executed.
+func HandlerFlowDiagram(c echo.Context) error {
+	wid := c.QueryParam("wid")
+	if _, err := requireWorkspaceAccess(c, wid); err != nil {
+		return err
+	}
+	fid := c.Param("fid")
+
+	format := c.QueryParam("format")
+	if format == "" {
+		format = services.DiagramMermaid
+	}
+	if format != services.DiagramMermaid && format != services.DiagramDOT {
+		return echo.NewHTTPError(http.StatusBadRequest, "Format must be mermaid or dot")
+	}
+
+	var flow types.Flow
+	var statuses map[string]string
+	if rid := c.QueryParam("run"); rid != "" {
+		run, nodes, err := database.FetchFlowRun(wid, rid)
+		if err != nil {
+			log.Printf("Failed to fetch flow run: %v", err)
+			return echo.NewHTTPError(http.StatusInternalServerError, "Failed to fetch flow run")
+		}
+		if run == nil || strconv.Itoa(run.FID) != fid {
+			return echo.NewHTTPError(http.StatusNotFound, "Run not found")
+		}
+		if flow, _, err = loadRunFlow(wid, fid, run.FlowVersion); err != nil {
+			return err
+		}
+
+		results := make([]types.FlowNodeResult, 0, len(nodes))
+		for _, node := range nodes {
+			var result types.FlowNodeResult
+			if err := json.Unmarshal(node.Data, &result); err != nil {
+				result = types.FlowNodeResult{NodeID: node.NodeID, Status: node.Status}
+			}
+			results = append(results, result)
+		}
+		statuses = services.DiagramStatuses(results)
+	} else {
+		var err error
+		if flow, _, err = loadFlow(wid, fid); err != nil {
+			return err
+		}
+	}
+
+	text, err := services.RenderFlowDiagram(flow, format, statuses)
+	if err != nil {
+		return echo.NewHTTPError(http.StatusBadRequest, err.Error())
+	}
+	return c.String(http.StatusOK, text)
+}
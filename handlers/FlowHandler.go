@@ -1 +1,243 @@
-package handlers
\ No newline at end of file
+package handlers
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/labstack/echo/v4"
+	"zukify.com/database"
+	"zukify.com/services"
+	"zukify.com/types"
+)
+
+// flowRunRequest is the body of the requests that start a flow run.
+type flowRunRequest struct {
+	Env       map[string]string `json:"env"`
+	SessionID string            `json:"session_id"`
+	RunID     string            `json:"run_id"`
+	Async     bool              `json:"async"`
+	// Debug runs pause before the nodes listed in Breakpoints, and before
+	// the first node with Step; see HandlerGetFlowDebug. They are always
+	// async.
+	Debug       bool     `json:"debug"`
+	Breakpoints []string `json:"breakpoints"`
+	Step        bool     `json:"step"`
+}
+
+// HandlerRunFlow executes a saved flow and returns the per-node report.
+// With "async": true it returns 202 with the run ID straight away and the
+// run continues in the background; its progress and final report are
+// streamed by HandlerRunEvents.
+func HandlerRunFlow(c echo.Context) error {
+	wid := c.QueryParam("wid")
+	uid, err := requireWorkspaceAccess(c, wid)
+	if err != nil {
+		return err
+	}
+	fid := c.Param("fid")
+
+	var req flowRunRequest
+	if err := c.Bind(&req); err != nil {
+		return echo.NewHTTPError(http.StatusBadRequest, "Invalid request body")
+	}
+
+	flow, version, err := loadFlow(wid, fid)
+	if err != nil {
+		return err
+	}
+
+	opts := services.FlowRunOptions{FID: fid, ResolveAT: newATResolver(wid), ResolveFlow: newFlowResolver(wid)}
+	if req.SessionID != "" {
+		if opts.Exec.Session, err = loadSession(wid, req.SessionID); err != nil {
+			return err
+		}
+	}
+
+	return executeFlowRun(c, wid, uid, flow, version, req.Env, opts, req, "")
+}
+
+// executeFlowRun runs a flow with env, records it in the run history and
+// responds with its report. An async run responds with 202 and the run ID
+// straight away and continues in the background. parentRID links a resumed
+// run to the run it resumes. A run without a cookie session gets one of its
+// own, which is dropped once the run finishes unless it was persisted.
+func executeFlowRun(c echo.Context, wid string, uid int, flow types.Flow, version int, env map[string]string, opts services.FlowRunOptions, req flowRunRequest, parentRID string) error {
+	async := req.Async || req.Debug
+
+	// An async run must outlive the request that started it
+	parent := c.Request().Context()
+	if async {
+		parent = context.Background()
+	}
+	ctx, runID, done, err := startRun(parent, req.RunID, wid)
+	if err != nil {
+		return err
+	}
+	// A synchronous run answers with its full report, so its events are
+	// only kept as a summary for the replay window
+	events := services.NewRunEvents(runID, wid, !async)
+	opts.RunID = runID
+	opts.Exec.Events = events.Publish
+	ownSession := opts.Exec.Session == nil
+	if ownSession {
+		opts.Exec.Session = services.NewSession(wid)
+	}
+
+	var debug *services.DebugSession
+	if req.Debug {
+		debug = services.NewDebugSession(ctx, runID, wid, req.Breakpoints, req.Step, events.Publish)
+		opts.BeforeNode = debug.BeforeNode
+	}
+
+	record := startFlowRunRecord(wid, opts.FID, version, uid, env, parentRID, database.TriggerManual, "", opts)
+
+	run := func() types.FlowRunReport {
+		defer done()
+		defer events.Close()
+		if ownSession {
+			defer services.DeleteSession(opts.Exec.Session.ID)
+		}
+		if debug != nil {
+			defer debug.Close()
+		}
+		report := services.RunFlow(ctx, flow, env, opts)
+		report.Revision = version
+		report.ResumedFrom = parentRID
+		finishFlowRunRecord(wid, record, report, opts.Exec.Session)
+		return report
+	}
+
+	if async {
+		go run()
+		response := map[string]string{
+			"run_id": runID,
+			"events": "/api/flows/runs/" + runID + "/events",
+		}
+		if debug != nil {
+			response["debug"] = "/api/flows/runs/" + runID + "/debug"
+		}
+		return c.JSON(http.StatusAccepted, response)
+	}
+	return c.JSON(http.StatusOK, run())
+}
+
+// HandlerRunEvents streams the events of a flow run as server-sent events.
+// Every event is replayed from the start of the run, or from after the
+// sequence number given by the Last-Event-ID header or the "since" query
+// parameter, and the stream ends once the run has finished. Synchronous runs
+// and dataset rows only record a summary of each event, without requests,
+// responses, envs and reports.
+func HandlerRunEvents(c echo.Context) error {
+	wid := c.QueryParam("wid")
+	if _, err := requireWorkspaceAccess(c, wid); err != nil {
+		return err
+	}
+
+	events, ok := services.GetRunEvents(c.Param("rid"))
+	if !ok || events.WID != wid {
+		return echo.NewHTTPError(http.StatusNotFound, "Run not found")
+	}
+
+	seq := 0
+	if last := c.Request().Header.Get("Last-Event-ID"); last != "" {
+		seq, _ = strconv.Atoi(last)
+	} else if since := c.QueryParam("since"); since != "" {
+		seq, _ = strconv.Atoi(since)
+	}
+
+	w := c.Response()
+	w.Header().Set(echo.HeaderContentType, "text/event-stream")
+	w.Header().Set(echo.HeaderCacheControl, "no-cache")
+	w.Header().Set(echo.HeaderConnection, "keep-alive")
+	w.WriteHeader(http.StatusOK)
+
+	keepAlive := time.NewTicker(15 * time.Second)
+	defer keepAlive.Stop()
+	for {
+		pending, finished, changed := events.Since(seq)
+		for _, ev := range pending {
+			data, err := json.Marshal(ev)
+			if err != nil {
+				log.Printf("Failed to encode run event: %v", err)
+				continue
+			}
+			fmt.Fprintf(w, "id: %d\nevent: %s\ndata: %s\n\n", ev.Seq, ev.Type, data)
+			seq = ev.Seq
+		}
+		w.Flush()
+		if finished {
+			return nil
+		}
+
+		select {
+		case <-changed:
+		case <-keepAlive.C:
+			fmt.Fprint(w, ": keep-alive\n\n")
+		case <-c.Request().Context().Done():
+			return nil
+		}
+	}
+}
+
+// loadFlow fetches and parses a saved flow, returning it with its version.
+func loadFlow(wid, fid string) (types.Flow, int, error) {
+	data, err := database.FetchAllFlow(wid, fid)
+	if err != nil {
+		log.Printf("Failed to fetch flow: %v", err)
+		return types.Flow{}, 0, echo.NewHTTPError(http.StatusInternalServerError, "Failed to fetch flow")
+	}
+	if data == nil {
+		return types.Flow{}, 0, echo.NewHTTPError(http.StatusNotFound, "Flow not found")
+	}
+
+	flow, err := services.ParseFlow(data.FlowData)
+	if err != nil {
+		log.Printf("Failed to parse flow %s: %v", fid, err)
+		return types.Flow{}, 0, echo.NewHTTPError(http.StatusUnprocessableEntity, "Flow data is invalid")
+	}
+	return flow, data.Version, nil
+}
+
+// newATResolver resolves flow nodes to the saved ATs of a workspace.
+func newATResolver(wid string) services.ATResolver {
+	return func(atID string) (types.ATRequest, error) {
+		atData, err := database.FetchAllAT(wid, atID)
+		if err != nil {
+			return types.ATRequest{}, fmt.Errorf("failed to fetch AT %s: %v", atID, err)
+		}
+		if atData == nil {
+			return types.ATRequest{}, fmt.Errorf("AT %s not found", atID)
+		}
+
+		req, err := convertATDataToRequest(atData)
+		if err != nil {
+			return types.ATRequest{}, fmt.Errorf("failed to process AT %s: %v", atID, err)
+		}
+		req.EndpointData.Quarantined = quarantinedCases(wid, atID)
+		return req.EndpointData, nil
+	}
+}
+
+// newFlowResolver resolves sub-flow nodes to the saved flows of a workspace.
+func newFlowResolver(wid string) services.FlowResolver {
+	return func(fid string) (types.Flow, error) {
+		data, err := database.FetchAllFlow(wid, fid)
+		if err != nil {
+			return types.Flow{}, fmt.Errorf("failed to fetch flow %s: %v", fid, err)
+		}
+		if data == nil {
+			return types.Flow{}, fmt.Errorf("flow %s not found", fid)
+		}
+
+		flow, err := services.ParseFlow(data.FlowData)
+		if err != nil {
+			return types.Flow{}, fmt.Errorf("flow %s is invalid: %v", fid, err)
+		}
+		return flow, nil
+	}
+}
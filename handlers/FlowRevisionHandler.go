@@ -0,0 +1,109 @@
+package handlers
+
+import (
+	"fmt"
+	"log"
+	"net/http"
+	"strconv"
+
+	"github.com/labstack/echo/v4"
+	"zukify.com/database"
+	"zukify.com/services"
+	"zukify.com/types"
+)
+
+// HandlerListFlowRevisions lists the revisions of a flow, newest first.
+func HandlerListFlowRevisions(c echo.Context) error {
+	wid := c.QueryParam("wid")
+	if _, err := requireWorkspaceAccess(c, wid); err != nil {
+		return err
+	}
+
+	revisions, err := database.FetchFlowRevisions(wid, c.Param("fid"))
+	if err != nil {
+		log.Printf("Failed to fetch flow revisions: %v", err)
+		return echo.NewHTTPError(http.StatusInternalServerError, "Failed to fetch flow revisions")
+	}
+	return c.JSON(http.StatusOK, revisions)
+}
+
+// HandlerGetFlowRevision returns one revision of a flow with its flow data.
+func HandlerGetFlowRevision(c echo.Context) error {
+	wid := c.QueryParam("wid")
+	if _, err := requireWorkspaceAccess(c, wid); err != nil {
+		return err
+	}
+
+	revision, _, err := loadFlowRevision(wid, c.Param("fid"), c.Param("version"))
+	if err != nil {
+		return err
+	}
+	return c.JSON(http.StatusOK, revision)
+}
+
+// HandlerDiffFlowRevisions compares the revisions given by the "from" and
+// "to" query parameters.
+func HandlerDiffFlowRevisions(c echo.Context) error {
+	wid := c.QueryParam("wid")
+	if _, err := requireWorkspaceAccess(c, wid); err != nil {
+		return err
+	}
+	fid := c.Param("fid")
+
+	from, before, err := loadFlowRevision(wid, fid, c.QueryParam("from"))
+	if err != nil {
+		return err
+	}
+	to, after, err := loadFlowRevision(wid, fid, c.QueryParam("to"))
+	if err != nil {
+		return err
+	}
+
+	diff := services.DiffFlows(before, after)
+	diff.From = from.Version
+	diff.To = to.Version
+	return c.JSON(http.StatusOK, diff)
+}
+
+// HandlerRestoreFlowRevision saves an old revision as the flow's newest
+// revision. The restored flow is validated like any other save.
+func HandlerRestoreFlowRevision(c echo.Context) error {
+	wid := c.QueryParam("wid")
+	uid, err := requireWorkspaceAccess(c, wid)
+	if err != nil {
+		return err
+	}
+	fid := c.Param("fid")
+
+	revision, flow, err := loadFlowRevision(wid, fid, c.Param("version"))
+	if err != nil {
+		return err
+	}
+
+	message := fmt.Sprintf("Restore revision %d", revision.Version)
+	return storeFlow(c, wid, fid, revision.Name, flow, revision.FlowData, revision.NodeData, message, uid, http.StatusOK)
+}
+
+// loadFlowRevision fetches and parses a revision of a flow.
+func loadFlowRevision(wid, fid, version string) (*database.FlowRevisionData, types.Flow, error) {
+	v, err := strconv.Atoi(version)
+	if err != nil {
+		return nil, types.Flow{}, echo.NewHTTPError(http.StatusBadRequest, "Invalid revision version")
+	}
+
+	revision, err := database.FetchFlowRevision(wid, fid, v)
+	if err != nil {
+		log.Printf("Failed to fetch flow revision: %v", err)
+		return nil, types.Flow{}, echo.NewHTTPError(http.StatusInternalServerError, "Failed to fetch flow revision")
+	}
+	if revision == nil {
+		return nil, types.Flow{}, echo.NewHTTPError(http.StatusNotFound, "Revision not found")
+	}
+
+	flow, err := services.ParseFlow(revision.FlowData)
+	if err != nil {
+		log.Printf("Failed to parse revision %d of flow %s: %v", v, fid, err)
+		return nil, types.Flow{}, echo.NewHTTPError(http.StatusUnprocessableEntity, "Revision data is invalid")
+	}
+	return revision, flow, nil
+}
@@ -0,0 +1,318 @@
+package handlers
+
+import (
+	"encoding/json"
+	"errors"
+	"log"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/labstack/echo/v4"
+	"zukify.com/database"
+	"zukify.com/services"
+	"zukify.com/types"
+)
+
+const (
+	defaultRunPageSize = 50
+	maxRunPageSize     = 200
+)
+
+// startFlowRunRecord persists a flow run as running, tagged with what
+// triggered it. Persistence failures are logged and never fail the run
+// itself.
+func startFlowRunRecord(wid, fid string, version, uid int, env map[string]string, parentRID, trigger, triggerRef string, opts services.FlowRunOptions) *database.FlowRunData {
+	envJSON, _ := json.Marshal(env)
+	record := &database.FlowRunData{
+		RID:         opts.RunID,
+		FlowVersion: version,
+		ParentRID:   parentRID,
+		Status:      types.StatusRunning,
+		Trigger:     trigger,
+		TriggerRef:  triggerRef,
+		Env:         envJSON,
+		StartedBy:   uid,
+		StartedAt:   time.Now().UTC().Format(database.RunTimeFormat),
+	}
+	record.FID, _ = strconv.Atoi(fid)
+	if opts.Exec.Session != nil {
+		record.SessionID = opts.Exec.Session.ID
+	}
+
+	if err := database.InsertFlowRun(wid, record); err != nil {
+		log.Printf("Failed to record flow run %s: %v", record.RID, err)
+		return nil
+	}
+	return record
+}
+
+// finishFlowRunRecord stores the outcome and node results of a run started
+// with startFlowRunRecord, along with the cookies of the run's session so
+// that a resumed run can log in as the original did.
+func finishFlowRunRecord(wid string, record *database.FlowRunData, report types.FlowRunReport, session *services.Session) {
+	if record == nil {
+		return
+	}
+	if session != nil {
+		record.SessionCookies, _ = json.Marshal(session.List())
+	}
+	record.Status = report.Status
+	record.Error = report.Error
+	record.FinalEnv, _ = json.Marshal(report.Env)
+	record.FinishedAt = time.Now().UTC().Format(database.RunTimeFormat)
+	record.DurationMs = report.DurationMs
+
+	nodes := make([]database.FlowRunNodeData, 0, len(report.Nodes))
+	for i, result := range report.Nodes {
+		data, err := json.Marshal(result)
+		if err != nil {
+			log.Printf("Failed to encode result of node %s: %v", result.NodeID, err)
+			continue
+		}
+		nodes = append(nodes, database.FlowRunNodeData{
+			Position:   i,
+			NodeID:     result.NodeID,
+			ATID:       result.ATID,
+			Status:     result.Status,
+			Error:      result.Error,
+			DurationMs: result.DurationMs,
+			Data:       data,
+		})
+	}
+
+	if err := database.FinishFlowRun(wid, record, nodes); err != nil {
+		log.Printf("Failed to record outcome of flow run %s: %v", record.RID, err)
+	}
+	recordFlowATRuns(wid, record, report)
+}
+
+// HandlerListFlowRuns lists the flow runs of a workspace, newest first.
+// Optional query parameters: fid, status, parent_rid, trigger ("manual" or
+// "dataset"), trigger_ref, from and to (RFC 3339 or YYYY-MM-DD; to is
+// exclusive), limit and offset.
+func HandlerListFlowRuns(c echo.Context) error {
+	wid := c.QueryParam("wid")
+	if _, err := requireWorkspaceAccess(c, wid); err != nil {
+		return err
+	}
+
+	filter := database.FlowRunFilter{
+		FID:        c.QueryParam("fid"),
+		Status:     c.QueryParam("status"),
+		ParentRID:  c.QueryParam("parent_rid"),
+		Trigger:    c.QueryParam("trigger"),
+		TriggerRef: c.QueryParam("trigger_ref"),
+	}
+	var err error
+	if filter.From, err = parseRunTime(c.QueryParam("from")); err != nil {
+		return echo.NewHTTPError(http.StatusBadRequest, "Invalid from date")
+	}
+	if filter.To, err = parseRunTime(c.QueryParam("to")); err != nil {
+		return echo.NewHTTPError(http.StatusBadRequest, "Invalid to date")
+	}
+	if filter.Limit, filter.Offset, err = parsePage(c); err != nil {
+		return err
+	}
+
+	runs, err := database.FetchFlowRuns(wid, filter)
+	if err != nil {
+		log.Printf("Failed to fetch flow runs: %v", err)
+		return echo.NewHTTPError(http.StatusInternalServerError, "Failed to fetch flow runs")
+	}
+	return c.JSON(http.StatusOK, runs)
+}
+
+// HandlerGetFlowRun returns a flow run with the full result of every node.
+func HandlerGetFlowRun(c echo.Context) error {
+	wid := c.QueryParam("wid")
+	if _, err := requireWorkspaceAccess(c, wid); err != nil {
+		return err
+	}
+
+	run, nodes, err := database.FetchFlowRun(wid, c.Param("rid"))
+	if err != nil {
+		log.Printf("Failed to fetch flow run: %v", err)
+		return echo.NewHTTPError(http.StatusInternalServerError, "Failed to fetch flow run")
+	}
+	if run == nil {
+		return echo.NewHTTPError(http.StatusNotFound, "Run not found")
+	}
+	return c.JSON(http.StatusOK, map[string]interface{}{
+		"run":   run,
+		"nodes": nodes,
+	})
+}
+
+// HandlerResumeFlowRun starts a new run of a finished flow run's flow that
+// reuses the results of the nodes that passed. Every node that did not pass
+// runs again, along with the nodes downstream of it and, when "from" names a
+// node, that node and everything downstream of it. The run executes the
+// same flow revision, starts from the original env overlaid with "env" and
+// is linked to the original through its parent_rid.
+func HandlerResumeFlowRun(c echo.Context) error {
+	wid := c.QueryParam("wid")
+	uid, err := requireWorkspaceAccess(c, wid)
+	if err != nil {
+		return err
+	}
+
+	var req struct {
+		flowRunRequest
+		From string `json:"from"`
+	}
+	if err := c.Bind(&req); err != nil {
+		return echo.NewHTTPError(http.StatusBadRequest, "Invalid request body")
+	}
+
+	original, nodes, err := database.FetchFlowRun(wid, c.Param("rid"))
+	if err != nil {
+		log.Printf("Failed to fetch flow run: %v", err)
+		return echo.NewHTTPError(http.StatusInternalServerError, "Failed to fetch flow run")
+	}
+	if original == nil {
+		return echo.NewHTTPError(http.StatusNotFound, "Run not found")
+	}
+	if original.Status == types.StatusRunning {
+		return echo.NewHTTPError(http.StatusConflict, "Run has not finished")
+	}
+	fid := strconv.Itoa(original.FID)
+
+	flow, version, err := loadRunFlow(wid, fid, original.FlowVersion)
+	if err != nil {
+		return err
+	}
+
+	previous := make([]types.FlowNodeResult, 0, len(nodes))
+	for _, node := range nodes {
+		var result types.FlowNodeResult
+		if err := json.Unmarshal(node.Data, &result); err != nil {
+			log.Printf("Failed to decode result of node %s in run %s: %v", node.NodeID, original.RID, err)
+			continue
+		}
+		previous = append(previous, result)
+	}
+
+	resume, err := services.NewFlowResume(flow, previous, req.From)
+	if errors.Is(err, services.ErrNothingToResume) {
+		return echo.NewHTTPError(http.StatusConflict, "Run has no failed nodes to rerun")
+	}
+	if err != nil {
+		return echo.NewHTTPError(http.StatusBadRequest, err.Error())
+	}
+
+	env := map[string]string{}
+	json.Unmarshal(original.Env, &env)
+	for k, v := range req.Env {
+		env[k] = v
+	}
+
+	// Reused nodes may have logged in through the original cookie session,
+	// so keep it while it still exists and otherwise rebuild it from the
+	// snapshot stored when the original run finished
+	opts := services.FlowRunOptions{FID: fid, ResolveAT: newATResolver(wid), ResolveFlow: newFlowResolver(wid), Resume: resume}
+	switch {
+	case req.SessionID != "":
+		if opts.Exec.Session, err = loadSession(wid, req.SessionID); err != nil {
+			return err
+		}
+	case original.SessionID != "":
+		if opts.Exec.Session, err = loadSession(wid, original.SessionID); err != nil {
+			opts.Exec.Session = restoreRunSession(wid, original)
+		}
+	}
+
+	return executeFlowRun(c, wid, uid, flow, version, env, opts, req.flowRunRequest, original.RID)
+}
+
+// restoreRunSession registers the cookie session a run finished with under
+// its original ID, or returns nil when the run stored no snapshot. Like any
+// idle session it is evicted after SessionTTL.
+func restoreRunSession(wid string, run *database.FlowRunData) *services.Session {
+	var cookies []types.SessionCookie
+	if err := json.Unmarshal(run.SessionCookies, &cookies); err != nil || cookies == nil {
+		return nil
+	}
+	return services.RestoreSession(run.SessionID, wid, cookies)
+}
+
+// loadRunFlow loads the revision of a flow that a run executed, falling
+// back to the current flow when that revision is no longer available.
+func loadRunFlow(wid, fid string, version int) (types.Flow, int, error) {
+	revision, err := database.FetchFlowRevision(wid, fid, version)
+	if err != nil {
+		log.Printf("Failed to fetch flow revision: %v", err)
+		return types.Flow{}, 0, echo.NewHTTPError(http.StatusInternalServerError, "Failed to fetch flow revision")
+	}
+	if revision == nil {
+		return loadFlow(wid, fid)
+	}
+
+	flow, err := services.ParseFlow(revision.FlowData)
+	if err != nil {
+		log.Printf("Failed to parse revision %d of flow %s: %v", version, fid, err)
+		return types.Flow{}, 0, echo.NewHTTPError(http.StatusUnprocessableEntity, "Flow data is invalid")
+	}
+	return flow, revision.Version, nil
+}
+
+// HandlerDeleteFlowRun deletes a flow run and its node results.
+func HandlerDeleteFlowRun(c echo.Context) error {
+	wid := c.QueryParam("wid")
+	if _, err := requireWorkspaceAccess(c, wid); err != nil {
+		return err
+	}
+
+	deleted, err := database.DeleteFlowRun(wid, c.Param("rid"))
+	if err != nil {
+		log.Printf("Failed to delete flow run: %v", err)
+		return echo.NewHTTPError(http.StatusInternalServerError, "Failed to delete flow run")
+	}
+	if !deleted {
+		return echo.NewHTTPError(http.StatusNotFound, "Run not found")
+	}
+	return c.JSON(http.StatusOK, map[string]string{"message": "Run deleted successfully"})
+}
+
+// parseRunTime converts an RFC 3339 time or a YYYY-MM-DD date to the stored
+// run time format.
+func parseRunTime(value string) (string, error) {
+	if value == "" {
+		return "", nil
+	}
+	t, err := parseTime(value)
+	if err != nil {
+		return "", err
+	}
+	return t.UTC().Format(database.RunTimeFormat), nil
+}
+
+// parseTime parses an RFC 3339 time or a YYYY-MM-DD date.
+func parseTime(value string) (time.Time, error) {
+	t, err := time.Parse(time.RFC3339, value)
+	if err != nil {
+		return time.Parse(time.DateOnly, value)
+	}
+	return t, nil
+}
+
+// parsePage reads the limit and offset query parameters of a run listing.
+// The limit defaults to defaultRunPageSize and is capped at maxRunPageSize.
+func parsePage(c echo.Context) (limit, offset int, err error) {
+	limit = defaultRunPageSize
+	if value := c.QueryParam("limit"); value != "" {
+		n, err := strconv.Atoi(value)
+		if err != nil || n < 1 {
+			return 0, 0, echo.NewHTTPError(http.StatusBadRequest, "Invalid limit")
+		}
+		limit = min(n, maxRunPageSize)
+	}
+	if value := c.QueryParam("offset"); value != "" {
+		n, err := strconv.Atoi(value)
+		if err != nil || n < 0 {
+			return 0, 0, echo.NewHTTPError(http.StatusBadRequest, "Invalid offset")
+		}
+		offset = n
+	}
+	return limit, offset, nil
+}
@@ -8,24 +8,27 @@ import (
 	"github.com/labstack/echo/v4"
 	"log"
 	"net/http"
+	"strconv"
 	"zukify.com/database"
+	"zukify.com/services"
+	"zukify.com/types"
 )
 
 type FlowData struct {
-	Name  string          `json:"name"`
-	WID   string          `json:"wid"`
-	Nodes json.RawMessage `json:"nodes"`
-	Edges json.RawMessage `json:"edges"`
+	FID      types.FlexibleID `json:"fid,omitempty"` // set to update an existing flow
+	Name     string           `json:"name"`
+	WID      string           `json:"wid"`
+	Nodes    json.RawMessage  `json:"nodes"`
+	Edges    json.RawMessage  `json:"edges"`
+	Settings json.RawMessage  `json:"settings,omitempty"`
+	Message  string           `json:"message,omitempty"` // revision message
 }
 
+// SaveFlow validates a flow and stores it, updating the flow with the given
+// fid or inserting a new one. Every save is kept as a revision. Validation
+// errors are returned as 422 with the list of issues; warnings are returned
+// with a successful save.
 func SaveFlow(c echo.Context) error {
-	user := c.Get("user").(jwt.MapClaims)
-	uid, ok := user["uid"].(float64)
-	if !ok {
-		log.Printf("Failed to extract UID from token: %v", user)
-		return echo.NewHTTPError(http.StatusInternalServerError, "Invalid token")
-	}
-
 	var flowData FlowData
 
 	if err := c.Bind(&flowData); err != nil {
@@ -33,36 +36,88 @@ func SaveFlow(c echo.Context) error {
 		return c.JSON(http.StatusBadRequest, map[string]string{"error": err.Error()})
 	}
 
+	wid := flowData.WID
+	uid, err := requireWorkspaceAccess(c, wid)
+	if err != nil {
+		return err
+	}
+
+	// node_data is not edited here; an update keeps the flow's current one
+	fid := string(flowData.FID)
+	var nodeData string
+	if fid != "" {
+		existing, err := database.FetchAllFlow(wid, fid)
+		if err != nil {
+			log.Printf("Failed to fetch flow: %v", err)
+			return c.JSON(http.StatusInternalServerError, map[string]string{"error": "Failed to fetch flow"})
+		}
+		if existing == nil {
+			return c.JSON(http.StatusNotFound, map[string]string{"error": "Flow not found"})
+		}
+		nodeData = existing.NodeData
+	}
+
 	// Convert flow data to JSON
-	flowJSON, err := json.Marshal(map[string]json.RawMessage{
+	stored := map[string]json.RawMessage{
 		"nodes": flowData.Nodes,
 		"edges": flowData.Edges,
-	})
+	}
+	if len(flowData.Settings) > 0 {
+		stored["settings"] = flowData.Settings
+	}
+	flowJSON, err := json.Marshal(stored)
 
 	if err != nil {
 		return c.JSON(http.StatusInternalServerError, map[string]string{"error": "Failed to marshal flow data"})
 	}
 
-	// Construct the table name
-	tableName := fmt.Sprintf("%s_flow", flowData.WID)
-	fmt.Println("Table Name: ", tableName)
-	query := fmt.Sprintf(`
-		INSERT INTO %s (name, flow_data, modified_by)
-		VALUES (?, ?, ?)
-		ON DUPLICATE KEY UPDATE
-		name = VALUES(name),
-		flow_data = VALUES(flow_data),
-		modified_by = VALUES(modified_by)
-	`, tableName)
-	_, err = database.WorkspaceDB.Exec(query, flowData.Name, flowJSON, uid)
-
+	flow, err := services.ParseFlow(string(flowJSON))
 	if err != nil {
-		fmt.Println("Error", err)
+		return c.JSON(http.StatusBadRequest, map[string]string{"error": "Invalid flow data: " + err.Error()})
+	}
+	return storeFlow(c, wid, fid, flowData.Name, flow, string(flowJSON), nodeData, flowData.Message, uid, http.StatusOK)
+}
 
-		return c.JSON(http.StatusInternalServerError, map[string]string{"error": "Failed to save flow data: " + err.Error()})
+// storeFlow validates a flow and saves it as a new revision, inserting the
+// flow when fid is empty. A successful save is answered with createdStatus
+// when the flow was inserted and 200 otherwise.
+func storeFlow(c echo.Context, wid, fid, name string, flow types.Flow, flowJSON, nodeData, message string, uid, createdStatus int) error {
+	issues := services.ValidateFlow(flow, services.FlowValidateOptions{
+		FID:         fid,
+		ResolveAT:   newATResolver(wid),
+		ResolveFlow: newFlowResolver(wid),
+	})
+	if services.HasFlowErrors(issues) {
+		return c.JSON(http.StatusUnprocessableEntity, map[string]interface{}{
+			"error":  "Flow validation failed",
+			"issues": issues,
+		})
 	}
 
-	return c.JSON(http.StatusOK, map[string]string{"message": "Flow data saved successfully"})
+	version := 1
+	status := http.StatusOK
+	if fid == "" {
+		status = createdStatus
+		id, err := database.InsertFlow(wid, name, flowJSON, nodeData, message, uid)
+		if err != nil {
+			log.Printf("Failed to save flow: %v", err)
+			return c.JSON(http.StatusInternalServerError, map[string]string{"error": "Failed to save flow data: " + err.Error()})
+		}
+		fid = strconv.Itoa(id)
+	} else {
+		var err error
+		if version, err = database.UpdateFlow(wid, fid, name, flowJSON, nodeData, message, uid); err != nil {
+			log.Printf("Failed to update flow: %v", err)
+			return c.JSON(http.StatusInternalServerError, map[string]string{"error": "Failed to save flow data: " + err.Error()})
+		}
+	}
+
+	return c.JSON(status, map[string]interface{}{
+		"message": "Flow data saved successfully",
+		"fid":     fid,
+		"version": version,
+		"issues":  issues,
+	})
 }
 
 func LoadSpecificFlow(c echo.Context) error {
@@ -99,7 +154,6 @@ func LoadSpecificFlow(c echo.Context) error {
 	return c.JSON(http.StatusOK, flowData)
 }
 
-
 func HandlerFetchAllFlow(c echo.Context) error {
 	user := c.Get("user").(jwt.MapClaims)
 	uid, ok := user["uid"].(float64)
@@ -169,4 +223,4 @@ func HandlerFetchPathFlow(c echo.Context) error {
 	}
 
 	return c.JSON(http.StatusOK, pathFlowData)
-}
\ No newline at end of file
+}
@@ -0,0 +1,173 @@
+package handlers
+
+import (
+	"encoding/json"
+	"log"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/labstack/echo/v4"
+	"zukify.com/database"
+	"zukify.com/services"
+	"zukify.com/types"
+)
+
+// HandlerCompareATRuns compares two runs of the same AT, given by the "from"
+// and "to" query parameters. The runs may have used different environments.
+// Optional "ignore_headers" and "ignore_paths" list, comma-separated, the
+// headers and response body JSON pointers to leave out of the comparison.
+func HandlerCompareATRuns(c echo.Context) error {
+	wid := c.QueryParam("wid")
+	if _, err := requireWorkspaceAccess(c, wid); err != nil {
+		return err
+	}
+
+	before, err := loadATRun(wid, c.QueryParam("from"))
+	if err != nil {
+		return err
+	}
+	after, err := loadATRun(wid, c.QueryParam("to"))
+	if err != nil {
+		return err
+	}
+	if before.ATID != after.ATID {
+		return echo.NewHTTPError(http.StatusBadRequest, "Runs belong to different ATs")
+	}
+
+	diff := types.ATRunDiff{
+		From:          before.RID,
+		To:            after.RID,
+		ATID:          after.ATID,
+		Env:           services.DiffEnv(runEnv(before.EnvIn), runEnv(after.EnvIn)),
+		EnvOut:        services.DiffEnv(runEnv(before.EnvOut), runEnv(after.EnvOut)),
+		ExecutionDiff: services.DiffExecutions(atRunExecution(before), atRunExecution(after), diffOptions(c)),
+	}
+	return c.JSON(http.StatusOK, diff)
+}
+
+// HandlerCompareFlowRuns compares two finished runs of the same flow, given
+// by the "from" and "to" query parameters, node by node. It takes the same
+// ignore options as HandlerCompareATRuns.
+func HandlerCompareFlowRuns(c echo.Context) error {
+	wid := c.QueryParam("wid")
+	if _, err := requireWorkspaceAccess(c, wid); err != nil {
+		return err
+	}
+
+	before, err := loadFlowRunReport(wid, c.QueryParam("from"))
+	if err != nil {
+		return err
+	}
+	after, err := loadFlowRunReport(wid, c.QueryParam("to"))
+	if err != nil {
+		return err
+	}
+	if before.FID != after.FID {
+		return echo.NewHTTPError(http.StatusBadRequest, "Runs belong to different flows")
+	}
+
+	diff := services.DiffFlowRuns(before, after, diffOptions(c))
+	diff.From = before.RunID
+	diff.To = after.RunID
+	diff.FID, _ = strconv.Atoi(after.FID)
+	return c.JSON(http.StatusOK, diff)
+}
+
+func diffOptions(c echo.Context) services.DiffOptions {
+	split := func(value string) []string {
+		var items []string
+		for _, item := range strings.Split(value, ",") {
+			if item = strings.TrimSpace(item); item != "" {
+				items = append(items, item)
+			}
+		}
+		return items
+	}
+	return services.DiffOptions{
+		IgnoreHeaders: split(c.QueryParam("ignore_headers")),
+		IgnorePaths:   split(c.QueryParam("ignore_paths")),
+	}
+}
+
+// loadATRun fetches an AT run for comparison.
+func loadATRun(wid, rid string) (*database.ATRunData, error) {
+	if rid == "" {
+		return nil, echo.NewHTTPError(http.StatusBadRequest, "Both from and to run IDs are required")
+	}
+	run, err := database.FetchATRun(wid, rid)
+	if err != nil {
+		log.Printf("Failed to fetch AT run: %v", err)
+		return nil, echo.NewHTTPError(http.StatusInternalServerError, "Failed to fetch AT run")
+	}
+	if run == nil {
+		return nil, echo.NewHTTPError(http.StatusNotFound, "Run "+rid+" not found")
+	}
+	return run, nil
+}
+
+// runEnv decodes an env recorded with an AT run.
+func runEnv(data json.RawMessage) map[string]string {
+	env := map[string]string{}
+	json.Unmarshal(data, &env)
+	return env
+}
+
+// atRunExecution rebuilds the execution recorded by an AT run. The blob
+// reference of a truncated body is its SHA-256.
+func atRunExecution(run *database.ATRunData) services.Execution {
+	exec := services.Execution{
+		Status:     run.Status,
+		DurationMs: run.DurationMs,
+		Response: &types.EndpointResponse{
+			StatusCode: run.StatusCode,
+			Body:       run.ResponseBody,
+			Size:       run.ResponseSize,
+			Truncated:  run.ResponseRef != "",
+			BodyRef:    run.ResponseRef,
+			SHA256:     run.ResponseRef,
+			DurationMs: run.DurationMs,
+		},
+	}
+	json.Unmarshal(run.Request, &exec.Request)
+	json.Unmarshal(run.ResponseHeaders, &exec.Response.Headers)
+	json.Unmarshal(run.Results, &exec.Results)
+	return exec
+}
+
+// loadFlowRunReport fetches a finished flow run for comparison.
+func loadFlowRunReport(wid, rid string) (types.FlowRunReport, error) {
+	if rid == "" {
+		return types.FlowRunReport{}, echo.NewHTTPError(http.StatusBadRequest, "Both from and to run IDs are required")
+	}
+	run, nodes, err := database.FetchFlowRun(wid, rid)
+	if err != nil {
+		log.Printf("Failed to fetch flow run: %v", err)
+		return types.FlowRunReport{}, echo.NewHTTPError(http.StatusInternalServerError, "Failed to fetch flow run")
+	}
+	if run == nil {
+		return types.FlowRunReport{}, echo.NewHTTPError(http.StatusNotFound, "Run "+rid+" not found")
+	}
+	if run.Status == types.StatusRunning {
+		return types.FlowRunReport{}, echo.NewHTTPError(http.StatusConflict, "Run "+rid+" has not finished")
+	}
+
+	report := types.FlowRunReport{
+		RunID:      run.RID,
+		FID:        strconv.Itoa(run.FID),
+		Revision:   run.FlowVersion,
+		Status:     run.Status,
+		Error:      run.Error,
+		Nodes:      make([]types.FlowNodeResult, 0, len(nodes)),
+		DurationMs: run.DurationMs,
+	}
+	json.Unmarshal(run.FinalEnv, &report.Env)
+	for _, node := range nodes {
+		var result types.FlowNodeResult
+		if err := json.Unmarshal(node.Data, &result); err != nil {
+			result = types.FlowNodeResult{NodeID: node.NodeID, ATID: node.ATID, Status: node.Status, Error: node.Error}
+		}
+		report.Nodes = append(report.Nodes, result)
+	}
+	return report, nil
+}
@@ -0,0 +1,37 @@
+package handlers
+
+import (
+	"context"
+	"errors"
+	"net/http"
+
+	"github.com/labstack/echo/v4"
+	"zukify.com/services"
+)
+
+// HandlerCancelRun aborts an in-flight AT, flow or dataset run of the
+// workspace by its run ID.
+func HandlerCancelRun(c echo.Context) error {
+	wid := c.QueryParam("wid")
+	if _, err := requireWorkspaceAccess(c, wid); err != nil {
+		return err
+	}
+
+	if !services.CancelRun(c.Param("rid"), wid) {
+		return echo.NewHTTPError(http.StatusNotFound, "Run not found or already finished")
+	}
+
+	return c.JSON(http.StatusOK, map[string]string{
+		"message": "Run cancelled",
+	})
+}
+
+// startRun starts a run like services.StartRun, answering 409 when the run
+// ID chosen by the client belongs to a run that is still in flight.
+func startRun(parent context.Context, runID, wid string) (context.Context, string, func(), error) {
+	ctx, runID, done, err := services.StartRun(parent, runID, wid)
+	if errors.Is(err, services.ErrRunExists) {
+		return nil, "", nil, echo.NewHTTPError(http.StatusConflict, "Run ID is already in use")
+	}
+	return ctx, runID, done, err
+}
@@ -0,0 +1,132 @@
+package handlers
+
+import (
+	"encoding/json"
+	"log"
+	"net/http"
+
+	"github.com/labstack/echo/v4"
+	"zukify.com/database"
+	"zukify.com/services"
+	"zukify.com/types"
+)
+
+// HandlerCreateSession starts a new cookie session. Pass the returned
+// session_id to /api/runATFromSaved or a flow run to share cookies between
+// them.
+func HandlerCreateSession(c echo.Context) error {
+	var req struct {
+		WID string `json:"wid"`
+	}
+	if err := c.Bind(&req); err != nil {
+		return echo.NewHTTPError(http.StatusBadRequest, "Invalid request body")
+	}
+
+	if _, err := requireWorkspaceAccess(c, req.WID); err != nil {
+		return err
+	}
+
+	session := services.NewSession(req.WID)
+	return c.JSON(http.StatusCreated, map[string]string{
+		"session_id": session.ID,
+	})
+}
+
+func HandlerGetSessionCookies(c echo.Context) error {
+	wid := c.QueryParam("wid")
+	if _, err := requireWorkspaceAccess(c, wid); err != nil {
+		return err
+	}
+
+	session, err := loadSession(wid, c.Param("sid"))
+	if err != nil {
+		return err
+	}
+
+	return c.JSON(http.StatusOK, map[string]interface{}{
+		"session_id": session.ID,
+		"cookies":    session.List(),
+	})
+}
+
+func HandlerClearSessionCookies(c echo.Context) error {
+	wid := c.QueryParam("wid")
+	if _, err := requireWorkspaceAccess(c, wid); err != nil {
+		return err
+	}
+
+	sid := c.Param("sid")
+	if session, ok := services.GetSession(sid); ok {
+		if session.WID != wid {
+			return echo.NewHTTPError(http.StatusNotFound, "Session not found")
+		}
+		session.Clear()
+	}
+
+	if err := database.DeleteSession(wid, sid); err != nil {
+		log.Printf("Failed to delete persisted session: %v", err)
+		return echo.NewHTTPError(http.StatusInternalServerError, "Failed to clear session")
+	}
+
+	return c.JSON(http.StatusOK, map[string]string{
+		"message": "Session cleared successfully",
+	})
+}
+
+// HandlerPersistSession stores the session's cookies in the workspace so the
+// session survives a restart and can be reused by later runs.
+func HandlerPersistSession(c echo.Context) error {
+	wid := c.QueryParam("wid")
+	uid, err := requireWorkspaceAccess(c, wid)
+	if err != nil {
+		return err
+	}
+
+	session, ok := services.GetSession(c.Param("sid"))
+	if !ok || session.WID != wid {
+		return echo.NewHTTPError(http.StatusNotFound, "Session not found")
+	}
+
+	cookies, err := json.Marshal(session.List())
+	if err != nil {
+		return echo.NewHTTPError(http.StatusInternalServerError, "Failed to marshal cookies")
+	}
+
+	if err := database.SaveSession(wid, session.ID, string(cookies), uid); err != nil {
+		log.Printf("Failed to persist session: %v", err)
+		return echo.NewHTTPError(http.StatusInternalServerError, "Failed to persist session")
+	}
+
+	return c.JSON(http.StatusOK, map[string]string{
+		"message": "Session persisted successfully",
+	})
+}
+
+// loadSession returns the in-memory session, falling back to the copy
+// persisted in the workspace. A session of another workspace is reported as
+// not found.
+func loadSession(wid, sid string) (*services.Session, error) {
+	if session, ok := services.GetSession(sid); ok {
+		if session.WID != wid {
+			return nil, echo.NewHTTPError(http.StatusNotFound, "Session not found")
+		}
+		return session, nil
+	}
+
+	data, err := database.FetchSession(wid, sid)
+	if err != nil {
+		log.Printf("Failed to fetch session: %v", err)
+		return nil, echo.NewHTTPError(http.StatusInternalServerError, "Failed to fetch session")
+	}
+	if data == nil {
+		return nil, echo.NewHTTPError(http.StatusNotFound, "Session not found")
+	}
+
+	var cookies []types.SessionCookie
+	if err := json.Unmarshal([]byte(data.Cookies), &cookies); err != nil {
+		log.Printf("Failed to unmarshal session cookies: %v", err)
+		return nil, echo.NewHTTPError(http.StatusInternalServerError, "Failed to load session")
+	}
+
+	return services.RestoreSession(sid, wid, cookies), nil
+}
@@ -0,0 +1,120 @@
+package handlers
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/labstack/echo/v4"
+	"zukify.com/database"
+	"zukify.com/services"
+	"zukify.com/types"
+)
+
+const (
+	defaultTrendsWindow = 30 * 24 * time.Hour
+	defaultSlowestCount = 10
+	// maxTrendRuns caps the runs aggregated by one request
+	maxTrendRuns = 50000
+)
+
+// maxTrendsWindow is the longest time range each bucket can be asked for.
+var maxTrendsWindow = map[string]time.Duration{
+	types.BucketHour: 31 * 24 * time.Hour,
+	types.BucketDay:  366 * 24 * time.Hour,
+	types.BucketWeek: 3 * 366 * 24 * time.Hour,
+}
+
+// HandlerQualityTrends aggregates the AT run history of a workspace: pass
+// rate, latency percentiles and failures per time bucket, latency per
+// endpoint, the slowest endpoints and failures by test case type. Optional
+// query parameters: bucket ("hour", "day" (default) or "week"), at_id, tag,
+// from and to (RFC 3339 or YYYY-MM-DD; the last 30 days by default, and at
+// most 31 days of hours, a year of days or three years of weeks) and
+// slowest, the number of slowest endpoints to list (default 10). Only the
+// most recent maxTrendRuns runs of the range are aggregated.
+func HandlerQualityTrends(c echo.Context) error {
+	wid := c.QueryParam("wid")
+	if _, err := requireWorkspaceAccess(c, wid); err != nil {
+		return err
+	}
+
+	bucket := c.QueryParam("bucket")
+	if bucket == "" {
+		bucket = types.BucketDay
+	}
+	maxWindow, ok := maxTrendsWindow[bucket]
+	if !ok {
+		return echo.NewHTTPError(http.StatusBadRequest, "Bucket must be hour, day or week")
+	}
+	to := time.Now()
+	if value := c.QueryParam("to"); value != "" {
+		var err error
+		if to, err = parseTime(value); err != nil {
+			return echo.NewHTTPError(http.StatusBadRequest, "Invalid to date")
+		}
+	}
+	from := to.Add(-defaultTrendsWindow)
+	if value := c.QueryParam("from"); value != "" {
+		var err error
+		if from, err = parseTime(value); err != nil {
+			return echo.NewHTTPError(http.StatusBadRequest, "Invalid from date")
+		}
+	}
+	if !from.Before(to) {
+		return echo.NewHTTPError(http.StatusBadRequest, "from must be before to")
+	}
+	if to.Sub(from) > maxWindow {
+		return echo.NewHTTPError(http.StatusBadRequest, fmt.Sprintf("The time range of %s buckets is limited to %d days", bucket, int(maxWindow.Hours()/24)))
+	}
+	slowest := defaultSlowestCount
+	if value := c.QueryParam("slowest"); value != "" {
+		var err error
+		if slowest, err = strconv.Atoi(value); err != nil || slowest < 0 {
+			return echo.NewHTTPError(http.StatusBadRequest, "Invalid slowest")
+		}
+	}
+
+	// Fetch one run more than aggregated to tell whether the range has more
+	samples, err := database.FetchATRunSamples(wid, c.QueryParam("at_id"), c.QueryParam("tag"),
+		from.UTC().Format(database.RunTimeFormat), to.UTC().Format(database.RunTimeFormat), maxTrendRuns+1)
+	if err != nil {
+		log.Printf("Failed to fetch AT runs: %v", err)
+		return echo.NewHTTPError(http.StatusInternalServerError, "Failed to fetch AT runs")
+	}
+	truncated := len(samples) > maxTrendRuns
+	if truncated {
+		samples = samples[:maxTrendRuns]
+	}
+
+	runs := make([]services.TrendRun, 0, len(samples))
+	for _, sample := range samples {
+		started, err := time.Parse(database.RunTimeFormat, sample.StartedAt)
+		if err != nil {
+			log.Printf("Failed to parse start time %q of an AT run: %v", sample.StartedAt, err)
+			continue
+		}
+		run := services.TrendRun{
+			ATID:       sample.ATID,
+			Path:       sample.Path,
+			Method:     sample.Method,
+			URL:        sample.URL,
+			Status:     sample.Status,
+			StatusCode: sample.StatusCode,
+			DurationMs: sample.DurationMs,
+			StartedAt:  started,
+		}
+		json.Unmarshal(sample.Results, &run.Results)
+		runs = append(runs, run)
+	}
+
+	trends, err := services.QualityTrends(runs, bucket, slowest)
+	if err != nil {
+		return echo.NewHTTPError(http.StatusBadRequest, err.Error())
+	}
+	trends.Truncated = truncated
+	return c.JSON(http.StatusOK, trends)
+}
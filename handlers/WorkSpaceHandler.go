@@ -12,6 +12,7 @@ import (
 	"github.com/golang-jwt/jwt"
 	"github.com/labstack/echo/v4"
 	"zukify.com/database"
+	"zukify.com/services"
 )
 
 func HandlerCreateWorkspace(c echo.Context) error {
@@ -67,6 +68,12 @@ func HandlerCreateWorkspace(c echo.Context) error {
 		return echo.NewHTTPError(http.StatusInternalServerError, "Failed to create workspace")
 	}
 
+	// Create the remaining workspace tables (sessions, ...)
+	if err := database.CreateWorkspaceTables(tablePrefix); err != nil {
+		log.Printf("Failed to create workspace tables: %v", err)
+		return echo.NewHTTPError(http.StatusInternalServerError, "Failed to create workspace")
+	}
+
 	// Add workspace to user
 	err = database.AddWorkspaceToUser(int(uid), tablePrefix, req.WorkspaceName)
 	if err != nil {
@@ -221,16 +228,12 @@ func HandlerSaveFlow(c echo.Context) error {
 		return echo.NewHTTPError(http.StatusForbidden, "You don't have access to this workspace")
 	}
 
-	// Save Flow data
-	err = database.SaveFlowData(tablePrefix, &req.FlowData, int(uid))
+	// Validate and save the flow like /api/save-flow, as a new flow
+	flow, err := services.ParseFlow(req.FlowData.FlowData)
 	if err != nil {
-		log.Printf("Failed to save Flow data: %v", err)
-		return echo.NewHTTPError(http.StatusInternalServerError, "Failed to save Flow data")
+		return echo.NewHTTPError(http.StatusBadRequest, err.Error())
 	}
-
-	return c.JSON(http.StatusCreated, map[string]string{
-		"message": "Flow data saved successfully",
-	})
+	return storeFlow(c, tablePrefix, "", req.FlowData.Name, flow, req.FlowData.FlowData, req.FlowData.NodeData, "", int(uid), http.StatusCreated)
 }
 
 func HandlerFetchPathAT(c echo.Context) error {
@@ -0,0 +1,76 @@
+package services
+
+import (
+	"mime"
+	"strings"
+	"unicode/utf8"
+
+	"golang.org/x/net/html/charset"
+	"golang.org/x/text/encoding"
+)
+
+// charsetInfo describes how a text response body is encoded.
+type charsetInfo struct {
+	Declared string            // charset from the Content-Type header, if any
+	Detected string            // charset found from the BOM, HTML meta tags or the bytes themselves
+	Encoding encoding.Encoding // decoder to UTF-8, nil when no transcoding is needed
+}
+
+// detectCharset works out the charset of a text body. The header, BOM and
+// <meta> tags are consulted in the order browsers use; bodies whose charset
+// cannot be determined are left untouched.
+func detectCharset(contentType string, data []byte) charsetInfo {
+	var info charsetInfo
+	if _, params, err := mime.ParseMediaType(contentType); err == nil {
+		if cs, ok := params["charset"]; ok {
+			if _, name := charset.Lookup(cs); name != "" {
+				info.Declared = name
+			} else {
+				info.Declared = strings.ToLower(cs)
+			}
+		}
+	}
+
+	// Detection from the content alone: BOM and <meta> are certain.
+	if _, name, certain := charset.DetermineEncoding(data, ""); certain {
+		info.Detected = name
+	} else if utf8.Valid(data) && !isASCII(data) {
+		info.Detected = "utf-8"
+	} else if isASCII(data) {
+		// ASCII is valid in every charset we care about, so trust the header.
+		info.Detected = info.Declared
+		if info.Detected == "" {
+			info.Detected = "utf-8"
+		}
+	} else if info.Declared != "" && info.Declared != "utf-8" {
+		// Not UTF-8 and nothing in the content says otherwise; the bytes
+		// cannot contradict a declared legacy charset.
+		info.Detected = info.Declared
+	}
+
+	// Encoding used for transcoding: BOM, then header, then <meta>.
+	enc, name, certain := charset.DetermineEncoding(data, contentType)
+	if name != "utf-8" && certain {
+		info.Encoding = enc
+	}
+	return info
+}
+
+// charsetMatches compares two charset labels by their canonical names.
+func charsetMatches(a, b string) bool {
+	_, na := charset.Lookup(a)
+	_, nb := charset.Lookup(b)
+	if na == "" || nb == "" {
+		return strings.EqualFold(a, b)
+	}
+	return na == nb
+}
+
+func isASCII(data []byte) bool {
+	for _, b := range data {
+		if b >= utf8.RuneSelf {
+			return false
+		}
+	}
+	return true
+}
@@ -0,0 +1,291 @@
+package services
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"unicode"
+)
+
+// conditionContext is what a flow condition is evaluated against: the
+// outcome of the node the edge leaves from and the environment at that point.
+type conditionContext struct {
+	Status       int    // HTTP status code of the node's response
+	AllImpPassed bool   // whether every important test case passed
+	NodeStatus   string // passed, failed, ...
+	Env          map[string]string
+}
+
+// EvaluateCondition evaluates a condition expression such as
+//
+//	all_imp_passed == true
+//	status == 404 || status >= 500
+//	<<user_id>> != '' && env.role == 'admin'
+//
+// Operands are the identifiers status, all_imp_passed and node_status, env
+// values written as env.name or <<name>>, null, true, false, numbers and
+// quoted strings; any other bare word is an error. Supported operators are
+// == != < <= > >= contains, && || ! and parentheses. Comparisons are
+// numeric when both sides are numbers and string comparisons otherwise. A
+// bare operand is true unless it is empty, "false" or "0".
+func EvaluateCondition(expr string, ctx conditionContext) (bool, error) {
+	tokens, err := tokenizeCondition(expr)
+	if err != nil {
+		return false, err
+	}
+	p := &conditionParser{tokens: tokens, ctx: ctx}
+	v, err := p.parseOr()
+	if err != nil {
+		return false, err
+	}
+	if p.pos < len(p.tokens) {
+		return false, fmt.Errorf("unexpected %q in condition", p.tokens[p.pos].text)
+	}
+	return truthy(v), nil
+}
+
+type conditionToken struct {
+	kind string // "op", "str", "word"
+	text string
+}
+
+func tokenizeCondition(expr string) ([]conditionToken, error) {
+	var tokens []conditionToken
+	for i := 0; i < len(expr); {
+		c := expr[i]
+		switch {
+		case c == ' ' || c == '\t' || c == '\n' || c == '\r':
+			i++
+		case strings.HasPrefix(expr[i:], "<<"):
+			end := strings.Index(expr[i:], ">>")
+			if end < 0 {
+				return nil, fmt.Errorf("unterminated variable in condition")
+			}
+			tokens = append(tokens, conditionToken{"word", "env." + expr[i+2:i+end]})
+			i += end + 2
+		case c == '\'' || c == '"':
+			end := strings.IndexByte(expr[i+1:], c)
+			if end < 0 {
+				return nil, fmt.Errorf("unterminated string in condition")
+			}
+			tokens = append(tokens, conditionToken{"str", expr[i+1 : i+1+end]})
+			i += end + 2
+		case strings.ContainsRune("=!<>&|", rune(c)):
+			op := string(c)
+			if i+1 < len(expr) && strings.ContainsRune("=&|", rune(expr[i+1])) {
+				op = expr[i : i+2]
+			}
+			switch op {
+			case "==", "!=", "<", "<=", ">", ">=", "&&", "||", "!":
+			default:
+				return nil, fmt.Errorf("unknown operator %q in condition", op)
+			}
+			tokens = append(tokens, conditionToken{"op", op})
+			i += len(op)
+		case c == '(' || c == ')':
+			tokens = append(tokens, conditionToken{"op", string(c)})
+			i++
+		default:
+			start := i
+			for i < len(expr) && (unicode.IsLetter(rune(expr[i])) || unicode.IsDigit(rune(expr[i])) || strings.ContainsRune("._-", rune(expr[i]))) {
+				i++
+			}
+			if start == i {
+				return nil, fmt.Errorf("unexpected character %q in condition", c)
+			}
+			tokens = append(tokens, conditionToken{"word", expr[start:i]})
+		}
+	}
+	return tokens, nil
+}
+
+type conditionParser struct {
+	tokens []conditionToken
+	pos    int
+	ctx    conditionContext
+}
+
+func (p *conditionParser) peek() (conditionToken, bool) {
+	if p.pos >= len(p.tokens) {
+		return conditionToken{}, false
+	}
+	return p.tokens[p.pos], true
+}
+
+func (p *conditionParser) accept(kind, text string) bool {
+	if t, ok := p.peek(); ok && t.kind == kind && t.text == text {
+		p.pos++
+		return true
+	}
+	return false
+}
+
+func (p *conditionParser) parseOr() (string, error) {
+	left, err := p.parseAnd()
+	if err != nil {
+		return "", err
+	}
+	for p.accept("op", "||") {
+		right, err := p.parseAnd()
+		if err != nil {
+			return "", err
+		}
+		left = boolString(truthy(left) || truthy(right))
+	}
+	return left, nil
+}
+
+func (p *conditionParser) parseAnd() (string, error) {
+	left, err := p.parseUnary()
+	if err != nil {
+		return "", err
+	}
+	for p.accept("op", "&&") {
+		right, err := p.parseUnary()
+		if err != nil {
+			return "", err
+		}
+		left = boolString(truthy(left) && truthy(right))
+	}
+	return left, nil
+}
+
+func (p *conditionParser) parseUnary() (string, error) {
+	if p.accept("op", "!") {
+		v, err := p.parseUnary()
+		if err != nil {
+			return "", err
+		}
+		return boolString(!truthy(v)), nil
+	}
+	return p.parseComparison()
+}
+
+func (p *conditionParser) parseComparison() (string, error) {
+	left, err := p.parseOperand()
+	if err != nil {
+		return "", err
+	}
+	t, ok := p.peek()
+	if !ok {
+		return left, nil
+	}
+	var op string
+	switch {
+	case t.kind == "op" && isComparison(t.text):
+		op = t.text
+	case t.kind == "word" && t.text == "contains":
+		op = t.text
+	default:
+		return left, nil
+	}
+	p.pos++
+	right, err := p.parseOperand()
+	if err != nil {
+		return "", err
+	}
+	return boolString(compareValues(left, op, right)), nil
+}
+
+func (p *conditionParser) parseOperand() (string, error) {
+	t, ok := p.peek()
+	if !ok {
+		return "", fmt.Errorf("unexpected end of condition")
+	}
+	p.pos++
+	switch {
+	case t.kind == "op" && t.text == "(":
+		v, err := p.parseOr()
+		if err != nil {
+			return "", err
+		}
+		if !p.accept("op", ")") {
+			return "", fmt.Errorf("missing ) in condition")
+		}
+		return v, nil
+	case t.kind == "str":
+		return t.text, nil
+	case t.kind == "word":
+		return p.resolve(t.text)
+	}
+	return "", fmt.Errorf("unexpected %q in condition", t.text)
+}
+
+func (p *conditionParser) resolve(word string) (string, error) {
+	switch {
+	case word == "status":
+		return strconv.Itoa(p.ctx.Status), nil
+	case word == "all_imp_passed":
+		return boolString(p.ctx.AllImpPassed), nil
+	case word == "node_status":
+		return p.ctx.NodeStatus, nil
+	case word == "null":
+		return "", nil
+	case word == "true" || word == "false":
+		return word, nil
+	case strings.HasPrefix(word, "env."):
+		return p.ctx.Env[strings.TrimPrefix(word, "env.")], nil
+	}
+	if _, err := strconv.ParseFloat(word, 64); err == nil {
+		return word, nil
+	}
+	return "", fmt.Errorf("unknown identifier %q in condition; quote string literals", word)
+}
+
+func isComparison(op string) bool {
+	switch op {
+	case "==", "!=", "<", "<=", ">", ">=":
+		return true
+	}
+	return false
+}
+
+func compareValues(left, op, right string) bool {
+	if op == "contains" {
+		return strings.Contains(left, right)
+	}
+	l, lerr := strconv.ParseFloat(left, 64)
+	r, rerr := strconv.ParseFloat(right, 64)
+	if lerr == nil && rerr == nil {
+		switch op {
+		case "==":
+			return l == r
+		case "!=":
+			return l != r
+		case "<":
+			return l < r
+		case "<=":
+			return l <= r
+		case ">":
+			return l > r
+		case ">=":
+			return l >= r
+		}
+	}
+	switch op {
+	case "==":
+		return left == right
+	case "!=":
+		return left != right
+	case "<":
+		return left < right
+	case "<=":
+		return left <= right
+	case ">":
+		return left > right
+	case ">=":
+		return left >= right
+	}
+	return false
+}
+
+func truthy(v string) bool {
+	return v != "" && v != "false" && v != "0"
+}
+
+func boolString(b bool) string {
+	if b {
+		return "true"
+	}
+	return "false"
+}
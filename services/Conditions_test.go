@@ -0,0 +1,79 @@
+package services
+
+import (
+	"testing"
+
+	"zukify.com/types"
+)
+
+func TestEvaluateCondition(t *testing.T) {
+	ctx := conditionContext{
+		Status:       404,
+		AllImpPassed: false,
+		NodeStatus:   types.StatusFailed,
+		Env:          map[string]string{"role": "admin", "count": "10", "version": "9", "empty": ""},
+	}
+	tests := []struct {
+		expr    string
+		want    bool
+		wantErr bool
+	}{
+		// Precedence: ! binds tighter than &&, which binds tighter than ||
+		{expr: "true || false && false", want: true},
+		{expr: "(true || false) && false", want: false},
+		{expr: "!false && false", want: false},
+		{expr: "!(false && false)", want: true},
+		{expr: "status == 200 || status == 404 && node_status == 'failed'", want: true},
+
+		// Identifiers and env values
+		{expr: "status == 404", want: true},
+		{expr: "all_imp_passed == false", want: true},
+		{expr: "all_imp_passed", want: false},
+		{expr: "env.role == 'admin' && <<role>> == \"admin\"", want: true},
+		{expr: "env.empty == null", want: true},
+		{expr: "env.missing", want: false},
+		{expr: "<<role>> contains 'dm'", want: true},
+
+		// Numbers compare numerically, anything else as strings
+		{expr: "env.count > env.version", want: true},
+		{expr: "'10' > '9'", want: true},
+		{expr: "env.role > env.count", want: true},
+		{expr: "'abc' < 'abd'", want: true},
+		{expr: "1.50 == 1.5", want: true},
+		{expr: "'1.50' == '1.5'", want: true},
+		{expr: "status >= 500", want: false},
+		{expr: "node_status != 'passed'", want: true},
+
+		// Unknown identifiers
+		{expr: "node_status == failed", wantErr: true},
+		{expr: "statu == 404", wantErr: true},
+
+		// Empty and malformed expressions
+		{expr: "", wantErr: true},
+		{expr: "   ", wantErr: true},
+		{expr: "status ==", wantErr: true},
+		{expr: "(status == 404", wantErr: true},
+		{expr: "status == 404)", wantErr: true},
+		{expr: "env.role == 'admin", wantErr: true},
+		{expr: "<<role == 'admin'", wantErr: true},
+		{expr: "status = 404", wantErr: true},
+		{expr: "status & true", wantErr: true},
+		{expr: "&& true", wantErr: true},
+	}
+	for _, tt := range tests {
+		got, err := EvaluateCondition(tt.expr, ctx)
+		if tt.wantErr {
+			if err == nil {
+				t.Errorf("EvaluateCondition(%q) = %v, want an error", tt.expr, got)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("EvaluateCondition(%q) error: %v", tt.expr, err)
+			continue
+		}
+		if got != tt.want {
+			t.Errorf("EvaluateCondition(%q) = %v, want %v", tt.expr, got, tt.want)
+		}
+	}
+}
@@ -0,0 +1,259 @@
+package services
+
+import (
+	"bytes"
+	"context"
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"io"
+	"strings"
+	"time"
+
+	"zukify.com/types"
+)
+
+// Dataset formats accepted on upload.
+const (
+	DatasetFormatCSV  = "csv"
+	DatasetFormatJSON = "json"
+)
+
+// ParseDataset turns an uploaded CSV file (with a header row) or JSON array
+// of objects into rows of column name to value. JSON values that are not
+// strings are kept as JSON.
+func ParseDataset(format string, content []byte) ([]map[string]string, error) {
+	switch strings.ToLower(format) {
+	case DatasetFormatCSV:
+		return parseCSVDataset(content)
+	case DatasetFormatJSON:
+		return parseJSONDataset(content)
+	}
+	return nil, fmt.Errorf("unsupported dataset format: %s", format)
+}
+
+func parseCSVDataset(content []byte) ([]map[string]string, error) {
+	r := csv.NewReader(bytes.NewReader(bytes.TrimPrefix(content, []byte("\xef\xbb\xbf"))))
+	header, err := r.Read()
+	if err == io.EOF {
+		return []map[string]string{}, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to read CSV header: %v", err)
+	}
+	for i := range header {
+		header[i] = strings.TrimSpace(header[i])
+	}
+
+	rows := []map[string]string{}
+	for line := 2; ; line++ {
+		record, err := r.Read()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return nil, fmt.Errorf("failed to read CSV line %d: %v", line, err)
+		}
+		row := make(map[string]string, len(header))
+		for i, col := range header {
+			row[col] = record[i]
+		}
+		rows = append(rows, row)
+	}
+	return rows, nil
+}
+
+func parseJSONDataset(content []byte) ([]map[string]string, error) {
+	var values []map[string]interface{}
+	if err := json.Unmarshal(content, &values); err != nil {
+		return nil, fmt.Errorf("dataset must be a JSON array of objects: %v", err)
+	}
+
+	rows := make([]map[string]string, 0, len(values))
+	for _, value := range values {
+		row := make(map[string]string, len(value))
+		for k, v := range value {
+			if s, ok := v.(string); ok {
+				row[k] = s
+			} else {
+				b, _ := json.Marshal(v)
+				row[k] = string(b)
+			}
+		}
+		rows = append(rows, row)
+	}
+	return rows, nil
+}
+
+// DatasetRowHook is called before each row of a dataset run, with the ID
+// the row runs under, the env it starts from and its cookie session, so the
+// caller can record the row as a run of its own. It returns the sink for the
+// row's events, which may be nil, and a function called with the row's
+// outcome.
+type DatasetRowHook func(runID string, env map[string]string, session *Session) (EventSink, func(DatasetRowOutcome))
+
+// DatasetRowOutcome is how one dataset row ran. AT rows set Response, NewEnv
+// and Endpoint; flow rows set Flow.
+type DatasetRowOutcome struct {
+	StartedAt time.Time
+	Response  types.TestResponse
+	NewEnv    map[string]string
+	Endpoint  types.EndpointResponse
+	Flow      *types.FlowRunReport
+}
+
+// datasetRowRunID is the run ID of row index of the dataset run runID.
+func datasetRowRunID(runID string, index int) string {
+	return fmt.Sprintf("%s.%d", runID, index+1)
+}
+
+// startRow calls hook, if any, for a row about to run.
+func (hook DatasetRowHook) startRow(runID string, env map[string]string, session *Session) (EventSink, func(DatasetRowOutcome)) {
+	if hook == nil {
+		return nil, func(DatasetRowOutcome) {}
+	}
+	sink, finish := hook(runID, env, session)
+	if finish == nil {
+		finish = func(DatasetRowOutcome) {}
+	}
+	return sink, finish
+}
+
+// RunATDataset runs an AT once per dataset row, with the row's columns added
+// to the AT's variables. Each row gets its own cookie session and runs as
+// "<runID>.<n>", n counting rows from 1.
+func RunATDataset(ctx context.Context, runID string, req types.ComplexATRequest, rows []map[string]string, opts ExecOptions, hook DatasetRowHook) types.DatasetRunReport {
+	report := newDatasetReport(len(rows))
+	for i, row := range rows {
+		if ctx.Err() != nil {
+			report.addRow(types.DatasetRowResult{Index: i, Row: row, Status: types.StatusCancelled})
+			continue
+		}
+
+		rowReq := req
+		rowReq.EndpointData.Variables = make(map[string]string, len(req.EndpointData.Variables)+len(row))
+		for k, v := range req.EndpointData.Variables {
+			rowReq.EndpointData.Variables[k] = v
+		}
+		for k, v := range row {
+			rowReq.EndpointData.Variables[k] = v
+		}
+		rowReq.Env = copyEnv(req.Env)
+
+		rowRunID := datasetRowRunID(runID, i)
+		rowOpts := opts
+		rowOpts.Session = newSession(newID(), "")
+		var finish func(DatasetRowOutcome)
+		rowOpts.Events, finish = hook.startRow(rowRunID, copyEnv(rowReq.Env), rowOpts.Session)
+		started := time.Now()
+		res, newEnv, endpointResponse := TestEndpointContext(ctx, rowReq, rowOpts)
+		finish(DatasetRowOutcome{StartedAt: started, Response: res, NewEnv: newEnv, Endpoint: endpointResponse})
+
+		result := types.DatasetRowResult{
+			Index:      i,
+			RunID:      rowRunID,
+			Row:        row,
+			Status:     RunStatus(res),
+			StatusCode: endpointResponse.StatusCode,
+			Results:    res.Results,
+		}
+		for _, tc := range res.Results {
+			if !tc.Passed {
+				result.Failures = append(result.Failures, failureReason(tc))
+			}
+		}
+		report.addRow(result)
+	}
+	return report.finish()
+}
+
+// RunFlowDataset runs a flow once per dataset row, with the row's columns
+// added to the flow's starting env. Each row gets its own cookie session and
+// runs as "<opts.RunID>.<n>", n counting rows from 1.
+func RunFlowDataset(ctx context.Context, flow types.Flow, env map[string]string, rows []map[string]string, opts FlowRunOptions, hook DatasetRowHook) types.DatasetRunReport {
+	report := newDatasetReport(len(rows))
+	for i, row := range rows {
+		if ctx.Err() != nil {
+			report.addRow(types.DatasetRowResult{Index: i, Row: row, Status: types.StatusCancelled})
+			continue
+		}
+
+		rowEnv := copyEnv(env)
+		for k, v := range row {
+			rowEnv[k] = v
+		}
+
+		rowOpts := opts
+		rowOpts.RunID = datasetRowRunID(opts.RunID, i)
+		rowOpts.Exec.Session = newSession(newID(), "")
+		var finish func(DatasetRowOutcome)
+		rowOpts.Exec.Events, finish = hook.startRow(rowOpts.RunID, copyEnv(rowEnv), rowOpts.Exec.Session)
+		started := time.Now()
+		flowReport := RunFlow(ctx, flow, rowEnv, rowOpts)
+		finish(DatasetRowOutcome{StartedAt: started, Flow: &flowReport})
+
+		result := types.DatasetRowResult{Index: i, RunID: rowOpts.RunID, Row: row, Status: flowReport.Status, Flow: &flowReport}
+		if flowReport.Error != "" {
+			result.Failures = append(result.Failures, flowReport.Error)
+		}
+		for _, node := range flowReport.Nodes {
+			if node.Status != types.StatusFailed && node.Status != types.StatusError {
+				continue
+			}
+			reason := fmt.Sprintf("node %s %s", node.NodeID, node.Status)
+			if node.Error != "" {
+				reason += ": " + node.Error
+			}
+			for _, tc := range node.Results {
+				if !tc.Passed {
+					reason += "; " + failureReason(tc)
+				}
+			}
+			result.Failures = append(result.Failures, reason)
+		}
+		report.addRow(result)
+	}
+	return report.finish()
+}
+
+func failureReason(tc types.TestResult) string {
+	if tc.Quarantined {
+		return tc.Case + " failed (quarantined)"
+	}
+	if tc.Imp {
+		return tc.Case + " failed (important)"
+	}
+	return tc.Case + " failed"
+}
+
+type datasetReport struct {
+	types.DatasetRunReport
+}
+
+func newDatasetReport(n int) *datasetReport {
+	return &datasetReport{types.DatasetRunReport{Total: n, Rows: make([]types.DatasetRowResult, 0, n)}}
+}
+
+func (r *datasetReport) addRow(row types.DatasetRowResult) {
+	switch row.Status {
+	case types.StatusPassed:
+		r.Passed++
+	case types.StatusCancelled:
+		r.Cancelled++
+	default:
+		r.Failed++
+	}
+	r.Rows = append(r.Rows, row)
+}
+
+func (r *datasetReport) finish() types.DatasetRunReport {
+	switch {
+	case r.Cancelled > 0:
+		r.Status = types.StatusCancelled
+	case r.Failed > 0:
+		r.Status = types.StatusFailed
+	default:
+		r.Status = types.StatusPassed
+	}
+	return r.DatasetRunReport
+}
@@ -0,0 +1,119 @@
+package services
+
+import (
+	"bufio"
+	"compress/flate"
+	"compress/gzip"
+	"compress/zlib"
+	"fmt"
+	"io"
+	"strings"
+
+	"github.com/andybalholm/brotli"
+	"github.com/klauspost/compress/zstd"
+)
+
+// countingReader counts the bytes read through it.
+type countingReader struct {
+	r io.Reader
+	n int64
+}
+
+func (c *countingReader) Read(p []byte) (int, error) {
+	n, err := c.r.Read(p)
+	c.n += int64(n)
+	return n, err
+}
+
+// parseContentEncoding splits a Content-Encoding header into its codings, in
+// the order they were applied. "identity" is dropped.
+func parseContentEncoding(header string) []string {
+	var codings []string
+	for _, part := range strings.Split(header, ",") {
+		coding := strings.ToLower(strings.TrimSpace(part))
+		if coding != "" && coding != "identity" {
+			codings = append(codings, coding)
+		}
+	}
+	return codings
+}
+
+// decodeBody wraps r so that it yields the decoded body. Codings are undone
+// in reverse order of application. An empty body, as sent in answer to HEAD
+// requests and with 204 and 304 responses, is returned as is whatever the
+// Content-Encoding says.
+func decodeBody(r io.Reader, codings []string) (io.ReadCloser, error) {
+	br := bufio.NewReader(r)
+	if _, err := br.Peek(1); err == io.EOF {
+		return io.NopCloser(br), nil
+	}
+	r = br
+
+	closers := []io.Closer{}
+	for i := len(codings) - 1; i >= 0; i-- {
+		var err error
+		var dec io.ReadCloser
+		switch codings[i] {
+		case "gzip", "x-gzip":
+			dec, err = gzip.NewReader(r)
+		case "deflate":
+			dec, err = newDeflateReader(r)
+		case "br":
+			dec = io.NopCloser(brotli.NewReader(r))
+		case "zstd":
+			var zr *zstd.Decoder
+			zr, err = zstd.NewReader(r)
+			if err == nil {
+				dec = zr.IOReadCloser()
+			}
+		default:
+			err = fmt.Errorf("unsupported content encoding: %s", codings[i])
+		}
+		if err != nil {
+			for _, c := range closers {
+				c.Close()
+			}
+			return nil, err
+		}
+		closers = append(closers, dec)
+		r = dec
+	}
+	return &multiCloser{Reader: r, closers: closers}, nil
+}
+
+// newDeflateReader handles both the zlib-wrapped stream the HTTP spec calls
+// "deflate" and the raw deflate stream some servers send instead.
+func newDeflateReader(r io.Reader) (io.ReadCloser, error) {
+	br := bufio.NewReader(r)
+	header, err := br.Peek(2)
+	if err == nil && header[0]&0x0f == 8 && (uint16(header[0])<<8|uint16(header[1]))%31 == 0 {
+		return zlib.NewReader(br)
+	}
+	return flate.NewReader(br), nil
+}
+
+type multiCloser struct {
+	io.Reader
+	closers []io.Closer
+}
+
+func (m *multiCloser) Close() error {
+	var first error
+	for i := len(m.closers) - 1; i >= 0; i-- {
+		if err := m.closers[i].Close(); err != nil && first == nil {
+			first = err
+		}
+	}
+	return first
+}
+
+// encodingMatches reports whether the response was compressed with the
+// given coding.
+func encodingMatches(header, expected string) bool {
+	for _, coding := range parseContentEncoding(header) {
+		if coding == strings.ToLower(strings.TrimSpace(expected)) {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,111 @@
+package services
+
+import (
+	"sync"
+	"time"
+
+	"zukify.com/types"
+)
+
+// EventSink receives the events of a run as they happen.
+type EventSink func(types.RunEvent)
+
+// eventRetention is how long the events of a finished run stay available
+// to late subscribers.
+const eventRetention = 10 * time.Minute
+
+var (
+	eventsMu sync.Mutex
+	events   = make(map[string]*RunEvents)
+)
+
+// RunEvents records every event of a run so subscribers can replay it from
+// the start, and wakes subscribers waiting for new events.
+type RunEvents struct {
+	RunID string
+	WID   string
+	// Summary drops the requests, responses, envs and reports from the
+	// recorded events, for runs whose caller gets the full report anyway.
+	Summary bool
+
+	mu      sync.Mutex
+	events  []types.RunEvent
+	done    bool
+	changed chan struct{} // closed and replaced on every change
+}
+
+// NewRunEvents creates the event log of a run and registers it under the
+// run's ID. A summary log keeps only the small fields of each event; see
+// RunEvents.Summary.
+func NewRunEvents(runID, wid string, summary bool) *RunEvents {
+	e := &RunEvents{RunID: runID, WID: wid, Summary: summary, changed: make(chan struct{})}
+	eventsMu.Lock()
+	events[runID] = e
+	eventsMu.Unlock()
+	return e
+}
+
+// GetRunEvents returns the event log of a running or recently finished run.
+func GetRunEvents(runID string) (*RunEvents, bool) {
+	eventsMu.Lock()
+	defer eventsMu.Unlock()
+	e, ok := events[runID]
+	return e, ok
+}
+
+// Publish numbers, timestamps and records an event. It is safe for
+// concurrent use and a no-op once the log is closed.
+func (e *RunEvents) Publish(ev types.RunEvent) {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	if e.done {
+		return
+	}
+	ev.Seq = len(e.events) + 1
+	ev.RunID = e.RunID
+	ev.Time = time.Now()
+	if e.Summary {
+		ev.Request = nil
+		ev.Response = nil
+		ev.Env = nil
+		ev.Report = nil
+	}
+	e.events = append(e.events, ev)
+	e.notify()
+}
+
+// Close marks the run as finished. The log is dropped after eventRetention.
+func (e *RunEvents) Close() {
+	e.mu.Lock()
+	e.done = true
+	e.notify()
+	e.mu.Unlock()
+
+	time.AfterFunc(eventRetention, func() {
+		eventsMu.Lock()
+		if events[e.RunID] == e {
+			delete(events, e.RunID)
+		}
+		eventsMu.Unlock()
+	})
+}
+
+// Since returns the events after seq, whether the run has finished, and a
+// channel that is closed when more events arrive.
+func (e *RunEvents) Since(seq int) ([]types.RunEvent, bool, <-chan struct{}) {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	if seq < 0 {
+		seq = 0
+	}
+	var after []types.RunEvent
+	if seq < len(e.events) {
+		after = append(after, e.events[seq:]...)
+	}
+	return after, e.done, e.changed
+}
+
+func (e *RunEvents) notify() {
+	close(e.changed)
+	e.changed = make(chan struct{})
+}
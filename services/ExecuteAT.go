@@ -1,8 +1,9 @@
 package services
 
 import (
+	"context"
+	"crypto/tls"
 	"encoding/json"
-	"io/ioutil"
 	"net/http"
 	"strings"
 	"time"
@@ -18,9 +19,50 @@ import (
 	
 )
 
+// ExecOptions carries per-run state shared between the ATs of a single run.
+type ExecOptions struct {
+	// Session, when set, supplies the cookie jar used for the request so that
+	// cookies set by one AT are sent by the next.
+	Session *Session
+	// TLSConfig overrides the client TLS configuration, e.g. to trust the
+	// certificate of an httptest.NewTLSServer.
+	TLSConfig *tls.Config
+	// Events, when set, receives request_sent, response_received, assertion
+	// and env_changed events as the AT executes.
+	Events EventSink
+}
+
+func (o ExecOptions) emit(ev types.RunEvent) {
+	if o.Events != nil {
+		o.Events(ev)
+	}
+}
+
 func TestEndpoint(req types.ComplexATRequest) (types.TestResponse, map[string]string, types.EndpointResponse) {
-	client := &http.Client{}
+	return TestEndpointContext(context.Background(), req, ExecOptions{})
+}
+
+// TestEndpointContext executes the AT, aborting the request as soon as ctx is
+// done. A cancelled execution is reported with Cancelled set instead of as a
+// failed test case.
+func TestEndpointContext(ctx context.Context, req types.ComplexATRequest, opts ExecOptions) (types.TestResponse, map[string]string, types.EndpointResponse) {
 	fmt.Println("Request Data:", req.EndpointData, req.Env)
+	transport, err := newTransport(req.EndpointData.Protocol, opts.TLSConfig)
+	if err != nil {
+		return types.TestResponse{
+			Results: []types.TestResult{{Case: "request_creation", Passed: false, Imp: true}},
+			AllImpPassed: false,
+		}, req.Env, types.EndpointResponse{}
+	}
+	defer closeIdle(transport)
+
+	client := &http.Client{Transport: transport}
+	if req.EndpointData.TimeoutMs > 0 {
+		client.Timeout = time.Duration(req.EndpointData.TimeoutMs) * time.Millisecond
+	}
+	if opts.Session != nil {
+		client.Jar = opts.Session
+	}
 	httpReq, err := prepareRequest(req.EndpointData, req.Env)
 	if err != nil {
 		return types.TestResponse{
@@ -28,32 +70,83 @@ func TestEndpoint(req types.ComplexATRequest) (types.TestResponse, map[string]st
 			AllImpPassed: false,
 		}, req.Env, types.EndpointResponse{}
 	}
+	httpReq = httpReq.WithContext(ctx)
+	if httpReq.Header.Get("Accept-Encoding") == "" {
+		// Match what the default transport would have advertised.
+		httpReq.Header.Set("Accept-Encoding", "gzip")
+	}
+	rendered := renderRequest(httpReq)
+	opts.emit(types.RunEvent{Type: types.EventRequestSent, Request: rendered})
 
 	start := time.Now()
 	resp, err := client.Do(httpReq)
 	if err != nil {
+		if ctx.Err() != nil {
+			return cancelledResponse(rendered), req.Env, types.EndpointResponse{}
+		}
 		return types.TestResponse{
 			Results: []types.TestResult{{Case: "request_execution", Passed: false, Imp: true}},
 			AllImpPassed: false,
+			Request: rendered,
 		}, req.Env, types.EndpointResponse{}
 	}
 	defer resp.Body.Close()
 
-	body, err := ioutil.ReadAll(resp.Body)
+	// Decode the body ourselves, whatever Accept-Encoding the AT sent
+	wire := &countingReader{r: resp.Body}
+	var bodyReader io.Reader = wire
+	contentEncoding := resp.Header.Get("Content-Encoding")
+	if codings := parseContentEncoding(contentEncoding); len(codings) > 0 {
+		decoded, err := decodeBody(wire, codings)
+		if err != nil {
+			return types.TestResponse{
+				Results: []types.TestResult{{Case: "response_decoding", Passed: false, Imp: true}},
+				AllImpPassed: false,
+				Request: rendered,
+			}, req.Env, types.EndpointResponse{}
+		}
+		defer decoded.Close()
+		bodyReader = decoded
+	}
+
+	body, err := captureBody(bodyReader, MaxCapturedBodySize())
 	if err != nil {
+		if ctx.Err() != nil {
+			return cancelledResponse(rendered), req.Env, types.EndpointResponse{}
+		}
 		return types.TestResponse{
 			Results: []types.TestResult{{Case: "response_reading", Passed: false, Imp: true}},
 			AllImpPassed: false,
+			Request: rendered,
 		}, req.Env, types.EndpointResponse{}
 	}
 
 	duration := time.Since(start)
 
+	body.decodeCharset(resp.Header.Get("Content-Type"))
+	bodyString, bodyEncoding := encodeBody(resp.Header.Get("Content-Type"), body.Text)
+	var rawBody []byte
+	if body.Charset.Encoding != nil {
+		rawBody = body.Data
+	}
 	endpointResponse := types.EndpointResponse{
-		StatusCode: resp.StatusCode,
-		Headers:    resp.Header,
-		Body:       string(body),
+		StatusCode:      resp.StatusCode,
+		Protocol:        resp.Proto,
+		Headers:         resp.Header,
+		Body:            bodyString,
+		BodyEncoding:    bodyEncoding,
+		Size:            body.Size,
+		ContentEncoding: contentEncoding,
+		CompressedSize:  wire.n,
+		Truncated:       body.Truncated,
+		SHA256:          body.SHA256,
+		BodyRef:         body.BlobRef,
+		DeclaredCharset: body.Charset.Declared,
+		DetectedCharset: body.Charset.Detected,
+		RawBody:         rawBody,
+		DurationMs:      duration.Milliseconds(),
 	}
+	opts.emit(types.RunEvent{Type: types.EventResponseReceived, Response: &endpointResponse, DurationMs: duration.Milliseconds()})
 
 	// Convert req.Env to map[string]interface{}
 	envInterface := make(map[string]interface{})
@@ -62,26 +155,95 @@ func TestEndpoint(req types.ComplexATRequest) (types.TestResponse, map[string]st
 	}
 
 	results, newEnv := runTestCases(req.EndpointData.TestCases, resp, body, duration, envInterface)
+	for i := range results {
+		opts.emit(types.RunEvent{Type: types.EventAssertion, Assertion: &results[i]})
+	}
 
 	// Convert newEnv back to map[string]string
 	newEnvString := make(map[string]string)
 	for k, v := range newEnv {
 		if strVal, ok := v.(string); ok {
 			newEnvString[k] = strVal
-		} else {
-			// Handle other types if necessary, or skip
+		} else if v != nil {
+			// Keep numbers, arrays and objects as JSON so flows can iterate over them
+			if b, err := json.Marshal(v); err == nil {
+				newEnvString[k] = string(b)
+			}
 		}
 	}
 
+	if changed := changedEnv(req.Env, newEnvString); len(changed) > 0 {
+		opts.emit(types.RunEvent{Type: types.EventEnvChanged, Env: changed})
+	}
+
+	markQuarantined(results, req.EndpointData.Quarantined)
 	allImpPassed := checkAllImpPassed(results)
 
 	return types.TestResponse{
 		Results:      results,
 		AllImpPassed: allImpPassed,
+		Request:      rendered,
 	}, newEnvString, endpointResponse
 }
 
+// renderRequest captures a prepared request as it will be sent. The body is
+// read through GetBody, so the request itself is left untouched.
+func renderRequest(req *http.Request) *types.RenderedRequest {
+	rendered := &types.RenderedRequest{
+		Method:  req.Method,
+		URL:     req.URL.String(),
+		Headers: req.Header.Clone(),
+	}
+	if req.GetBody == nil || req.ContentLength == 0 {
+		return rendered
+	}
+	body, err := req.GetBody()
+	if err != nil {
+		return rendered
+	}
+	defer body.Close()
+
+	limit := MaxCapturedBodySize()
+	data, _ := io.ReadAll(io.LimitReader(body, limit+1))
+	if int64(len(data)) > limit {
+		data = data[:limit]
+		rendered.Truncated = true
+	}
+	rendered.Body, rendered.BodyEncoding = encodeBody(req.Header.Get("Content-Type"), data)
+	return rendered
+}
+
+// changedEnv returns the values of after that are new or differ from before.
+func changedEnv(before, after map[string]string) map[string]string {
+	changed := make(map[string]string)
+	for k, v := range after {
+		if old, ok := before[k]; !ok || old != v {
+			changed[k] = v
+		}
+	}
+	return changed
+}
+
+
+
+// RenderRequest builds the request an AT would send with the given env,
+// without sending it.
+func RenderRequest(at types.ATRequest, env map[string]string) (*types.RenderedRequest, error) {
+	httpReq, err := prepareRequest(at, env)
+	if err != nil {
+		return nil, err
+	}
+	return renderRequest(httpReq), nil
+}
 
+func cancelledResponse(rendered *types.RenderedRequest) types.TestResponse {
+	return types.TestResponse{
+		Results:      []types.TestResult{},
+		AllImpPassed: false,
+		Cancelled:    true,
+		Request:      rendered,
+	}
+}
 
 func prepareRequest(data types.ATRequest, env map[string]string) (*http.Request, error) {
 	endpoint_url := replaceVariables(data.URL, data.Variables, env)
@@ -90,6 +252,8 @@ func prepareRequest(data types.ATRequest, env map[string]string) (*http.Request,
 		headers[k] = replaceVariables(fmt.Sprintf("%v", v), data.Variables, env)
 	}
 
+	data.Body = replaceBodyVariables(data.Body, data.Variables, env).(map[string]interface{})
+
 	var bodyReader io.Reader
 	contentType := headers["Content-Type"]
 
@@ -222,7 +386,7 @@ func extractData(data interface{}, slicePattern string) (interface{}, error) {
     return current, nil
 }
 
-func runTestCases(testCases []types.TestCase, resp *http.Response, body []byte, duration time.Duration, env map[string]interface{}) ([]types.TestResult, map[string]interface{}) {
+func runTestCases(testCases []types.TestCase, resp *http.Response, body *capturedBody, duration time.Duration, env map[string]interface{}) ([]types.TestResult, map[string]interface{}) {
     var results []types.TestResult
     newEnv := make(map[string]interface{}) // Now, values in newEnv can be of any type
 
@@ -248,8 +412,9 @@ func runTestCases(testCases []types.TestCase, resp *http.Response, body []byte,
                 if ok && strings.HasPrefix(strVal, "(response[") {
                     // This means the value contains an expression to extract data
                     var data map[string]interface{}
-                    if err := json.Unmarshal(body, &data); err != nil {
-                        panic(err)
+                    if err := body.DecodeJSON(&data); err != nil {
+                        fmt.Printf("Error decoding response for key %s: %v\n", key, err)
+                        continue
                     }
 
                     extractedValue, err := extractData(data, strVal)
@@ -285,30 +450,65 @@ func replaceVariables(input string, variables map[string]string, env map[string]
 	return input
 }
 
+// replaceBodyVariables applies replaceVariables to every string in a request
+// body, including those nested in objects and arrays.
+func replaceBodyVariables(value interface{}, variables map[string]string, env map[string]string) interface{} {
+	switch v := value.(type) {
+	case string:
+		return replaceVariables(v, variables, env)
+	case map[string]interface{}:
+		if v == nil {
+			return v
+		}
+		replaced := make(map[string]interface{}, len(v))
+		for k, item := range v {
+			replaced[k] = replaceBodyVariables(item, variables, env)
+		}
+		return replaced
+	case []interface{}:
+		replaced := make([]interface{}, len(v))
+		for i, item := range v {
+			replaced[i] = replaceBodyVariables(item, variables, env)
+		}
+		return replaced
+	}
+	return value
+}
+
 
 
-func runTestCase(tc types.TestCase, resp *http.Response, body []byte, duration time.Duration) bool {
+func runTestCase(tc types.TestCase, resp *http.Response, body *capturedBody, duration time.Duration) bool {
 	switch tc.Case {
 	case "check_status_200":
 		return resp.StatusCode == http.StatusOK
 	case "check_response_contains":
-		return strings.Contains(string(body), tc.Data.(string))
+		return body.Contains(tc.Data.(string))
 	case "check_json_field_exists":
 		var jsonResp map[string]interface{}
-		if err := json.Unmarshal(body, &jsonResp); err == nil {
+		if err := body.DecodeJSON(&jsonResp); err == nil {
 			_, exists := jsonResp[tc.Data.(string)]
 			return exists
 		}
 		return false
 	case "check_json_field_value":
 		var jsonResp map[string]interface{}
-		if err := json.Unmarshal(body, &jsonResp); err == nil {
+		if err := body.DecodeJSON(&jsonResp); err == nil {
 			value, exists := jsonResp[tc.Data.(map[string]interface{})["field"].(string)]
 			return exists && value == tc.Data.(map[string]interface{})["value"]
 		}
 		return false
 	case "check_response_time":
 		return duration.Milliseconds() <= int64(tc.Data.(float64))
+	case "check_protocol":
+		return protocolMatches(resp.Proto, tc.Data.(string))
+	case "check_content_encoding":
+		return encodingMatches(resp.Header.Get("Content-Encoding"), tc.Data.(string))
+	case "check_response_compressed":
+		return len(parseContentEncoding(resp.Header.Get("Content-Encoding"))) > 0
+	case "check_charset":
+		return charsetMatches(body.Charset.Detected, tc.Data.(string))
+	case "check_charset_matches_declared":
+		return body.Charset.Declared == "" || charsetMatches(body.Charset.Declared, body.Charset.Detected)
 	case "check_header_exists":
 		_, exists := resp.Header[tc.Data.(string)]
 		return exists
@@ -317,23 +517,23 @@ func runTestCase(tc types.TestCase, resp *http.Response, body []byte, duration t
 	// 	headerValue := resp.Header.Get(headerData["name"])
 	// 	return headerValue == headerData["value"]
 	case "check_response_non_empty":
-		return len(body) > 0
+		return body.Size > 0
 	case "check_content_type":
 		return resp.Header.Get("Content-Type") == tc.Data.(string)
 	case "check_response_body_length":
-		return len(body) == int(tc.Data.(float64))
+		return body.Size == int64(tc.Data.(float64))
 	case "check_response_is_valid_json":
 		var js json.RawMessage
-		return json.Unmarshal(body, &js) == nil
+		return body.DecodeJSON(&js) == nil
 	case "check_xml_field_value":
 		// This is a simplified check. For robust XML parsing, consider using encoding/xml package
 		xmlData := tc.Data.(map[string]string)
-		return strings.Contains(string(body), "<"+xmlData["field"]+">"+xmlData["value"]+"</"+xmlData["field"]+">")
+		return body.Contains("<"+xmlData["field"]+">"+xmlData["value"]+"</"+xmlData["field"]+">")
 	case "check_specific_string_in_html":
-		return strings.Contains(string(body), tc.Data.(string))
+		return body.Contains(tc.Data.(string))
 	case "check_json_array_contains_value":
 		var jsonResp []interface{}
-		if err := json.Unmarshal(body, &jsonResp); err == nil {
+		if err := body.DecodeJSON(&jsonResp); err == nil {
 			for _, item := range jsonResp {
 				if item == tc.Data {
 					return true
@@ -342,7 +542,7 @@ func runTestCase(tc types.TestCase, resp *http.Response, body []byte, duration t
 		}
 		return false
 	case "check_non_empty_response":
-		return len(body) > 0
+		return body.Size > 0
 	// case "check_specific_cookie":
 	// 	cookieData := tc.Data.(map[string]string)
 	// 	for _, cookie := range resp.Cookies() {
@@ -358,7 +558,7 @@ func runTestCase(tc types.TestCase, resp *http.Response, body []byte, duration t
 
 func checkAllImpPassed(results []types.TestResult) bool {
 	for _, result := range results {
-		if result.Imp && !result.Passed {
+		if result.Imp && !result.Passed && !result.Quarantined {
 			return false
 		}
 	}
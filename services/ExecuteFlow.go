@@ -1 +1,607 @@
-package services
\ No newline at end of file
+package services
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"time"
+
+	"zukify.com/types"
+)
+
+// Node types with special meaning to the flow runner. Any other type is an
+// AT node and must reference a saved AT through data.at_id.
+const (
+	NodeTypeStart     = "start"
+	NodeTypeEnd       = "end"
+	NodeTypeCondition = "condition"
+	NodeTypeJoin      = "join"
+	NodeTypeForEach   = "foreach"
+	NodeTypeRepeat    = "repeat"
+	NodeTypeSubFlow   = "subflow"
+)
+
+// Join node modes and conflict policies (data.join_mode, data.conflict_policy).
+const (
+	JoinModeAll = "all" // wait for every incoming branch (default)
+	JoinModeAny = "any" // continue with the first branch to arrive
+
+	ConflictLastWrite  = "last_write"  // later edges override earlier ones (default)
+	ConflictFirstWrite = "first_write" // the first edge to set a value keeps it
+	ConflictError      = "error"       // differing values fail the join
+)
+
+// ATResolver loads a saved AT of the workspace as an executable request.
+type ATResolver func(atID string) (types.ATRequest, error)
+
+// FlowResolver loads a saved flow of the workspace, for sub-flow nodes.
+type FlowResolver func(fid string) (types.Flow, error)
+
+// NodeHook is called before a top-level node runs, with the env the node
+// will start from, and returns the env to run it with. render renders the
+// request an AT node would send with a given env; it is nil for nodes that
+// send no request.
+type NodeHook func(ctx context.Context, node *types.FlowNode, env map[string]string, render RequestRenderer) map[string]string
+
+// RequestRenderer renders the request of a node for an env.
+type RequestRenderer func(env map[string]string) (*types.RenderedRequest, error)
+
+// FlowRunOptions configures a flow run.
+type FlowRunOptions struct {
+	RunID       string
+	FID         string
+	ResolveAT   ATResolver
+	ResolveFlow FlowResolver
+	Exec        ExecOptions
+	// Resume, when set, reuses the results of an earlier run for the
+	// top-level nodes that do not need to run again.
+	Resume *FlowResume
+	// BeforeNode, when set, is called before every top-level node runs
+	// and makes the run serial; see NodeHook.
+	BeforeNode NodeHook
+
+	// callStack holds the IDs of the flows being run, outermost first,
+	// when running a sub-flow.
+	callStack []string
+	// parent is the path of the loop and sub-flow nodes enclosing the
+	// graph being run, reported on its events.
+	parent string
+}
+
+// emit sends a flow event to the run's event sink, if any.
+func (o FlowRunOptions) emit(ev types.RunEvent) {
+	if ev.Parent == "" {
+		ev.Parent = o.parent
+	}
+	o.Exec.emit(ev)
+}
+
+// nested returns the options for a graph run inside the given node.
+func (o FlowRunOptions) nested(nodeID string) FlowRunOptions {
+	if o.parent != "" {
+		nodeID = o.parent + "/" + nodeID
+	}
+	o.parent = nodeID
+	o.Resume = nil
+	o.BeforeNode = nil
+	return o
+}
+
+// RunFlow executes a saved flow. A node runs once the nodes before it have
+// finished, with independent branches running concurrently up to the flow's
+// max_parallelism (1 by default). Each node starts from the environment
+// produced by the predecessors whose edges were followed. An edge is
+// followed when its condition holds or, if it has none, when its source
+// passed. A failing node whose outgoing edges are all
+// unconditional stops the run, leaving the remaining nodes skipped, unless
+// its on_failure policy is "continue"; a failure routed through conditional
+// edges is treated as handled. Setup and teardown nodes run before and after
+// the rest of the flow; see runPhases.
+//
+// Progress is reported to opts.Exec.Events, from run_started to
+// run_finished; see types.RunEvent.
+func RunFlow(ctx context.Context, flow types.Flow, env map[string]string, opts FlowRunOptions) types.FlowRunReport {
+	opts.emit(types.RunEvent{Type: types.EventRunStarted, Env: copyEnv(env)})
+	report, _ := runFlowGraph(ctx, flow, env, opts)
+	opts.emit(types.RunEvent{
+		Type:       types.EventRunFinished,
+		Status:     report.Status,
+		Error:      report.Error,
+		DurationMs: report.DurationMs,
+		Report:     &report,
+	})
+	return report
+}
+
+// runFlowGraph runs a flow or a loop body and also returns the outcome of
+// the last node that ran, which repeat-until conditions are evaluated
+// against.
+func runFlowGraph(ctx context.Context, flow types.Flow, env map[string]string, opts FlowRunOptions) (types.FlowRunReport, conditionContext) {
+	start := time.Now()
+	report := types.FlowRunReport{
+		RunID: opts.RunID,
+		FID:   opts.FID,
+		Nodes: []types.FlowNodeResult{},
+		Env:   copyEnv(env),
+	}
+	if opts.Exec.Session != nil {
+		report.SessionID = opts.Exec.Session.ID
+	}
+
+	var last conditionContext
+	if hasPhases(flow) {
+		last = runPhases(ctx, flow, env, opts, &report)
+	} else {
+		last = runGraph(ctx, flow, env, opts, &report)
+	}
+
+	report.DurationMs = time.Since(start).Milliseconds()
+	return report, last
+}
+
+// runGraph schedules the nodes of a flow graph, recording their results,
+// the run status and the resulting env in report.
+func runGraph(ctx context.Context, flow types.Flow, env map[string]string, opts FlowRunOptions, report *types.FlowRunReport) conditionContext {
+	last := conditionContext{Env: report.Env}
+	g, err := buildFlowGraph(flow)
+	if err == nil {
+		var order []string
+		if order, err = g.topoOrder(); err == nil {
+			parallelism := flow.Settings.MaxParallelism
+			if opts.BeforeNode != nil {
+				parallelism = 1
+			}
+			run := newFlowRun(g, env, opts, report)
+			run.execute(ctx, order, parallelism)
+			for i := len(order) - 1; i >= 0; i-- {
+				if c, ok := run.contexts[order[i]]; ok {
+					last = c
+					break
+				}
+			}
+			last.Env = report.Env
+		}
+	}
+	if err != nil {
+		report.Status = types.StatusError
+		report.Error = err.Error()
+	}
+	return last
+}
+
+// flowRun holds the state of one execution of a flow graph. All fields are
+// owned by the scheduling goroutine in execute; node executions only see
+// copies of what they need.
+type flowRun struct {
+	g      *flowGraph
+	env    map[string]string
+	opts   FlowRunOptions
+	report *types.FlowRunReport
+
+	results   map[string]*types.FlowNodeResult
+	outputs   map[string]map[string]string // env produced by each node
+	contexts  map[string]conditionContext  // outcome of each node, for edge conditions
+	taken     map[*types.FlowEdge]bool     // edges that were followed
+	handled   map[string]bool              // nodes whose failure was routed by conditional edges
+	continued map[string]bool              // failed nodes whose on_failure policy let the run continue
+	stopped   bool
+}
+
+func newFlowRun(g *flowGraph, env map[string]string, opts FlowRunOptions, report *types.FlowRunReport) *flowRun {
+	return &flowRun{
+		g:         g,
+		env:       env,
+		opts:      opts,
+		report:    report,
+		results:   make(map[string]*types.FlowNodeResult),
+		outputs:   make(map[string]map[string]string),
+		contexts:  make(map[string]conditionContext),
+		taken:     make(map[*types.FlowEdge]bool),
+		handled:   make(map[string]bool),
+		continued: make(map[string]bool),
+	}
+}
+
+// nodeOutcome is what a node execution hands back to the scheduler.
+type nodeOutcome struct {
+	result  types.FlowNodeResult
+	context conditionContext
+}
+
+// execute schedules the nodes as their dependencies resolve, running up to
+// the flow's max parallelism at once. Independent branches therefore run
+// concurrently, while the report lists nodes in topological order.
+func (r *flowRun) execute(ctx context.Context, order []string, parallelism int) {
+	if parallelism < 1 {
+		parallelism = 1
+	}
+	rank := make(map[string]int, len(order))
+	pending := make(map[string]int, len(order))
+	for i, id := range order {
+		rank[id] = i
+		pending[id] = len(r.g.in[id])
+	}
+
+	var ready []string
+	for _, id := range order {
+		if pending[id] == 0 {
+			ready = append(ready, id)
+		}
+	}
+
+	started := make(map[string]bool, len(order))
+	done := make(chan nodeOutcome)
+	running := 0
+
+	for {
+		sort.Slice(ready, func(i, j int) bool { return rank[ready[i]] < rank[ready[j]] })
+		for len(ready) > 0 && running < parallelism && !r.stopped && ctx.Err() == nil {
+			id := ready[0]
+			ready = ready[1:]
+			started[id] = true
+			running++
+
+			node := r.g.nodes[id]
+			envIn, err := r.inputEnv(node)
+			if err == nil && r.opts.BeforeNode != nil {
+				envIn = r.opts.BeforeNode(ctx, node, envIn, r.opts.requestRenderer(node))
+			}
+			prev := r.previousContext(id)
+			r.opts.emit(types.RunEvent{Type: types.EventNodeStarted, NodeID: id, ATID: string(node.Data.ATID)})
+			go func() {
+				if err != nil {
+					done <- nodeOutcome{result: types.FlowNodeResult{NodeID: node.ID, ATID: string(node.Data.ATID), Status: types.StatusError, Error: err.Error(), EnvIn: envIn, NewEnv: envIn}}
+					return
+				}
+				if result, ok := r.opts.Resume.reuse(node); ok {
+					done <- nodeOutcome{result: result, context: nodeContext(result)}
+					return
+				}
+				done <- r.runNode(ctx, node, envIn, prev)
+			}()
+		}
+		if running == 0 {
+			break
+		}
+
+		outcome := <-done
+		running--
+		for _, next := range r.finish(outcome, pending, started) {
+			ready = append(ready, next)
+		}
+	}
+
+	// Whatever never started was skipped, or cancelled if the run was.
+	r.report.Status = types.StatusPassed
+	for _, id := range order {
+		result, ok := r.results[id]
+		if !ok {
+			node := r.g.nodes[id]
+			result = &types.FlowNodeResult{NodeID: id, ATID: string(node.Data.ATID), Status: types.StatusSkipped}
+			if ctx.Err() != nil {
+				result.Status = types.StatusCancelled
+			}
+			r.opts.emit(types.RunEvent{Type: types.EventNodeFinished, NodeID: id, ATID: result.ATID, Status: result.Status})
+		}
+		r.report.Nodes = append(r.report.Nodes, *result)
+
+		switch {
+		case result.Status == types.StatusCancelled:
+			r.report.Status = types.StatusCancelled
+		case result.Status == types.StatusSkipped:
+		case result.Status == types.StatusPassed || r.handled[id]:
+			for k, v := range result.NewEnv {
+				r.report.Env[k] = v
+			}
+		default:
+			if r.continued[id] {
+				for k, v := range result.NewEnv {
+					r.report.Env[k] = v
+				}
+			}
+			if r.report.Status == types.StatusPassed {
+				r.report.Status = types.StatusFailed
+			}
+		}
+	}
+}
+
+// runNode executes a node outside the scheduling goroutine.
+func (r *flowRun) runNode(ctx context.Context, node *types.FlowNode, envIn map[string]string, prev conditionContext) nodeOutcome {
+	switch node.Type {
+	case NodeTypeCondition:
+		return runConditionNode(node, envIn, prev)
+	case NodeTypeForEach, NodeTypeRepeat:
+		result := runLoopNode(ctx, node, envIn, r.opts)
+		return nodeOutcome{result: result, context: nodeContext(result)}
+	case NodeTypeSubFlow:
+		result := runSubFlowNode(ctx, node, envIn, r.opts)
+		return nodeOutcome{result: result, context: nodeContext(result)}
+	case NodeTypeJoin:
+		return nodeOutcome{
+			result:  types.FlowNodeResult{NodeID: node.ID, Status: types.StatusPassed, AllImpPassed: true, EnvIn: envIn, NewEnv: envIn},
+			context: conditionContext{AllImpPassed: true, NodeStatus: types.StatusPassed, Env: envIn},
+		}
+	}
+	result := runFlowNode(ctx, node, envIn, r.opts)
+	return nodeOutcome{result: result, context: nodeContext(result)}
+}
+
+// finish records a finished node, resolves its outgoing edges and returns
+// the nodes that became ready to run.
+func (r *flowRun) finish(outcome nodeOutcome, pending map[string]int, started map[string]bool) []string {
+	result := outcome.result
+	id := result.NodeID
+	node := r.g.nodes[id]
+	r.contexts[id] = outcome.context
+	r.outputs[id] = result.NewEnv
+
+	continued := continuesOnFailure(node, result)
+	handled := r.followEdges(node, &result, continued)
+	r.handled[id] = handled
+	r.continued[id] = continued
+	r.results[id] = &result
+	r.opts.emit(types.RunEvent{
+		Type:       types.EventNodeFinished,
+		NodeID:     id,
+		ATID:       result.ATID,
+		Status:     result.Status,
+		Error:      result.Error,
+		DurationMs: result.DurationMs,
+	})
+	if result.Status != types.StatusPassed && !handled && !continued {
+		r.stopped = true
+	}
+
+	return r.resolveEdges(id, pending, started)
+}
+
+// resolveEdges marks the outgoing edges of a finished or skipped node as
+// resolved. Targets whose incoming edges are all resolved become ready if at
+// least one of them was taken (all of them, for "all" joins) and are skipped
+// otherwise, which in turn resolves their own edges. "any" joins become
+// ready as soon as the first taken edge arrives.
+func (r *flowRun) resolveEdges(id string, pending map[string]int, started map[string]bool) []string {
+	var ready []string
+	for _, edge := range r.g.out[id] {
+		target := edge.Target
+		pending[target]--
+		if started[target] {
+			continue
+		}
+
+		node := r.g.nodes[target]
+		takenIn := len(r.takenIncoming(target))
+		if node.Type == NodeTypeJoin && node.Data.JoinMode == JoinModeAny && takenIn > 0 {
+			started[target] = true
+			ready = append(ready, target)
+			continue
+		}
+		if pending[target] > 0 {
+			continue
+		}
+
+		runnable := takenIn > 0
+		if node.Type == NodeTypeJoin && node.Data.JoinMode != JoinModeAny {
+			runnable = takenIn == len(r.g.in[target])
+		}
+		started[target] = true
+		if runnable {
+			ready = append(ready, target)
+		} else {
+			ready = append(ready, r.resolveEdges(target, pending, started)...)
+		}
+	}
+	return ready
+}
+
+// inputEnv builds the env a node starts from: the run's env with the output
+// of each predecessor whose edge was taken layered on top in edge order.
+// Join nodes apply their conflict policy when branches disagree on a value.
+func (r *flowRun) inputEnv(node *types.FlowNode) (map[string]string, error) {
+	envIn := copyEnv(r.env)
+	setBy := make(map[string]string)
+	for _, edge := range r.takenIncoming(node.ID) {
+		for k, v := range r.outputs[edge.Source] {
+			prev, seen := setBy[k]
+			if seen && envIn[k] != v && node.Type == NodeTypeJoin {
+				switch node.Data.ConflictPolicy {
+				case ConflictFirstWrite:
+					continue
+				case ConflictError:
+					return envIn, fmt.Errorf("branches %q and %q disagree on %q", prev, edge.Source, k)
+				}
+			}
+			envIn[k] = v
+			setBy[k] = edge.Source
+		}
+	}
+	return envIn, nil
+}
+
+// previousContext is the outcome a condition node is evaluated against: that
+// of the first predecessor whose edge was taken.
+func (r *flowRun) previousContext(id string) conditionContext {
+	if takenIn := r.takenIncoming(id); len(takenIn) > 0 {
+		return r.contexts[takenIn[0].Source]
+	}
+	return conditionContext{}
+}
+
+// takenIncoming returns the incoming edges of a node that were followed.
+func (r *flowRun) takenIncoming(id string) []*types.FlowEdge {
+	var edges []*types.FlowEdge
+	for _, edge := range r.g.in[id] {
+		if r.taken[edge] {
+			edges = append(edges, edge)
+		}
+	}
+	return edges
+}
+
+// runConditionNode evaluates a condition node against the outcome of the
+// node before it. It passes its input env through unchanged.
+func runConditionNode(node *types.FlowNode, envIn map[string]string, prev conditionContext) nodeOutcome {
+	result := types.FlowNodeResult{NodeID: node.ID, EnvIn: envIn, NewEnv: envIn}
+
+	prev.Env = envIn
+	ok, err := EvaluateCondition(node.Data.Condition, prev)
+	if err != nil {
+		result.Status = types.StatusError
+		result.Error = err.Error()
+		return nodeOutcome{result: result, context: prev}
+	}
+
+	result.Status = types.StatusPassed
+	result.AllImpPassed = true
+	result.Branch = boolString(ok)
+	return nodeOutcome{result: result, context: prev}
+}
+
+// followEdges decides which outgoing edges of a finished node are taken and
+// records them on the result. Unconditional edges are followed when the node
+// passed, or when it failed but continued is set by its on_failure policy.
+// It reports whether the node's outcome was handled by conditional edges, in
+// which case a failure does not stop the run.
+func (r *flowRun) followEdges(node *types.FlowNode, result *types.FlowNodeResult, continued bool) bool {
+	if result.Status == types.StatusCancelled {
+		return false
+	}
+
+	handled := false
+	for _, edge := range r.g.out[node.ID] {
+		// Condition nodes only follow the edges of the branch they took.
+		if node.Type == NodeTypeCondition && edge.SourceHandle != "" && edge.SourceHandle != result.Branch {
+			continue
+		}
+
+		follow := result.Status == types.StatusPassed || continued
+		if edge.Condition != "" {
+			handled = true
+			ctx := r.contexts[node.ID]
+			ctx.Env = result.NewEnv
+			ok, err := EvaluateCondition(edge.Condition, ctx)
+			if err != nil {
+				result.Status = types.StatusError
+				result.Error = fmt.Sprintf("edge %q: %v", edge.ID, err)
+				return false
+			}
+			follow = ok
+		}
+
+		if follow {
+			r.taken[edge] = true
+			result.TakenEdges = append(result.TakenEdges, edge.ID)
+		}
+	}
+	return handled
+}
+
+// nodeContext is the outcome of an AT node as seen by edge conditions.
+func nodeContext(result types.FlowNodeResult) conditionContext {
+	ctx := conditionContext{
+		AllImpPassed: result.AllImpPassed,
+		NodeStatus:   result.Status,
+		Env:          result.NewEnv,
+	}
+	if result.EndpointResponse != nil {
+		ctx.Status = result.EndpointResponse.StatusCode
+	}
+	return ctx
+}
+
+// requestRenderer returns the RequestRenderer of an AT node, or nil for
+// other node types.
+func (o FlowRunOptions) requestRenderer(node *types.FlowNode) RequestRenderer {
+	switch node.Type {
+	case NodeTypeStart, NodeTypeEnd, NodeTypeCondition, NodeTypeJoin, NodeTypeForEach, NodeTypeRepeat, NodeTypeSubFlow:
+		return nil
+	}
+	return func(env map[string]string) (*types.RenderedRequest, error) {
+		if node.Data.ATID == "" {
+			return nil, fmt.Errorf("node %q does not reference an AT", node.ID)
+		}
+		endpoint, err := o.ResolveAT(string(node.Data.ATID))
+		if err != nil {
+			return nil, err
+		}
+		if endpoint, err = applyNodeOverrides(endpoint, node.Data.Overrides); err != nil {
+			return nil, err
+		}
+		return RenderRequest(endpoint, env)
+	}
+}
+
+// runFlowNode executes a single node with the given input environment.
+func runFlowNode(ctx context.Context, node *types.FlowNode, envIn map[string]string, opts FlowRunOptions) types.FlowNodeResult {
+	start := time.Now()
+	result := types.FlowNodeResult{
+		NodeID:    node.ID,
+		ATID:      string(node.Data.ATID),
+		EnvIn:     envIn,
+		NewEnv:    envIn,
+		StartedAt: formatStartTime(start),
+	}
+
+	switch node.Type {
+	case NodeTypeStart, NodeTypeEnd:
+		result.Status = types.StatusPassed
+		result.AllImpPassed = true
+		return result
+	}
+
+	if node.Data.ATID == "" {
+		result.Status = types.StatusError
+		result.Error = fmt.Sprintf("node %q does not reference an AT", node.ID)
+		return result
+	}
+
+	endpoint, err := opts.ResolveAT(string(node.Data.ATID))
+	if err != nil {
+		result.Status = types.StatusError
+		result.Error = err.Error()
+		return result
+	}
+	if endpoint, err = applyNodeOverrides(endpoint, node.Data.Overrides); err != nil {
+		result.Status = types.StatusError
+		result.Error = fmt.Sprintf("node %q overrides: %v", node.ID, err)
+		return result
+	}
+	result.ATHash = ATHash(endpoint)
+
+	// Tag the AT's events with the node they belong to
+	exec := opts.Exec
+	if sink := exec.Events; sink != nil {
+		exec.Events = func(ev types.RunEvent) {
+			ev.NodeID = node.ID
+			ev.ATID = string(node.Data.ATID)
+			ev.Parent = opts.parent
+			sink(ev)
+		}
+	}
+
+	req := types.ComplexATRequest{EndpointData: endpoint, Env: copyEnv(envIn)}
+	res, newEnv, endpointResponse := TestEndpointContext(ctx, req, exec)
+
+	result.Status = RunStatus(res)
+	result.Results = res.Results
+	result.AllImpPassed = res.AllImpPassed
+	result.NewEnv = newEnv
+	result.Request = res.Request
+	result.EndpointResponse = &endpointResponse
+	result.DurationMs = time.Since(start).Milliseconds()
+	return result
+}
+
+// formatStartTime formats the start time of a node for FlowNodeResult.
+func formatStartTime(t time.Time) string {
+	return t.UTC().Format(time.RFC3339Nano)
+}
+
+func copyEnv(env map[string]string) map[string]string {
+	c := make(map[string]string, len(env))
+	for k, v := range env {
+		c[k] = v
+	}
+	return c
+}
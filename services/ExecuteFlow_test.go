@@ -0,0 +1,327 @@
+package services
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"sync"
+	"testing"
+	"time"
+
+	"zukify.com/types"
+)
+
+// testAT is the AT of a test flow node: the path it GETs on the test server
+// and the env it sets when the response is a 200.
+type testAT struct {
+	path   string
+	setEnv map[string]interface{}
+}
+
+// resolveTestATs resolves the ATs of a test flow, keyed by AT ID, to
+// requests against srv.
+func resolveTestATs(srv *httptest.Server, ats map[string]testAT) ATResolver {
+	return func(atID string) (types.ATRequest, error) {
+		at, ok := ats[atID]
+		if !ok {
+			return types.ATRequest{}, fmt.Errorf("AT %s not found", atID)
+		}
+		tc := types.TestCase{Case: "check_status_200", Imp: true}
+		if at.setEnv != nil {
+			tc.SetEnv = at.setEnv
+		}
+		return types.ATRequest{
+			Method:    http.MethodGet,
+			URL:       srv.URL + at.path,
+			Headers:   map[string]string{"Content-Type": "application/json"},
+			Body:      map[string]interface{}{},
+			TestCases: []types.TestCase{tc},
+		}, nil
+	}
+}
+
+func atNode(id string) types.FlowNode {
+	return types.FlowNode{ID: id, Data: types.FlowNodeData{ATID: types.FlexibleID(id)}}
+}
+
+func flowEdge(source, target string) types.FlowEdge {
+	return types.FlowEdge{ID: source + "-" + target, Source: source, Target: target}
+}
+
+// chain returns a flow that runs the given AT nodes one after another.
+func chain(ids ...string) types.Flow {
+	var flow types.Flow
+	for i, id := range ids {
+		flow.Nodes = append(flow.Nodes, atNode(id))
+		if i > 0 {
+			flow.Edges = append(flow.Edges, flowEdge(ids[i-1], id))
+		}
+	}
+	return flow
+}
+
+// diamond returns a flow where AT nodes a and b both lead to the join node j,
+// which leads to the AT node after.
+func diamond(join types.FlowNodeData) types.Flow {
+	return types.Flow{
+		Nodes: []types.FlowNode{atNode("a"), atNode("b"), {ID: "j", Type: NodeTypeJoin, Data: join}, atNode("after")},
+		Edges: []types.FlowEdge{flowEdge("a", "j"), flowEdge("b", "j"), flowEdge("j", "after")},
+	}
+}
+
+func nodeStatuses(report types.FlowRunReport) map[string]string {
+	statuses := make(map[string]string, len(report.Nodes))
+	for _, node := range report.Nodes {
+		statuses[node.NodeID] = node.Status
+	}
+	return statuses
+}
+
+func nodeResult(report types.FlowRunReport, id string) types.FlowNodeResult {
+	for _, node := range report.Nodes {
+		if node.NodeID == id {
+			return node
+		}
+	}
+	return types.FlowNodeResult{}
+}
+
+func TestRunFlowMaxParallelism(t *testing.T) {
+	tests := []struct {
+		name        string
+		parallelism int
+		want        int // most requests in flight at once
+	}{
+		{"unset runs serially", 0, 1},
+		{"one runs serially", 1, 1},
+		{"two", 2, 2},
+		{"more than the nodes", 8, 4},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var mu sync.Mutex
+			var arrived []string
+			inFlight, peak := 0, 0
+			filled := make(chan struct{})
+			var fill sync.Once
+			srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				mu.Lock()
+				arrived = append(arrived, r.URL.Path[1:])
+				inFlight++
+				peak = max(peak, inFlight)
+				if inFlight == tt.want {
+					fill.Do(func() { close(filled) })
+				}
+				mu.Unlock()
+
+				// Hold each request until the expected number are in flight
+				// together, so a run that is too serial is caught as well
+				select {
+				case <-filled:
+				case <-time.After(2 * time.Second):
+				}
+
+				mu.Lock()
+				inFlight--
+				mu.Unlock()
+			}))
+			defer srv.Close()
+
+			ids := []string{"n1", "n2", "n3", "n4"}
+			flow := types.Flow{Settings: types.FlowSettings{MaxParallelism: tt.parallelism}}
+			ats := map[string]testAT{}
+			for _, id := range ids {
+				flow.Nodes = append(flow.Nodes, atNode(id))
+				ats[id] = testAT{path: "/" + id}
+			}
+
+			report := RunFlow(context.Background(), flow, map[string]string{}, FlowRunOptions{ResolveAT: resolveTestATs(srv, ats)})
+			if report.Status != types.StatusPassed {
+				t.Fatalf("status = %s, want passed (nodes %v)", report.Status, nodeStatuses(report))
+			}
+
+			mu.Lock()
+			defer mu.Unlock()
+			if peak != tt.want {
+				t.Errorf("peak in-flight requests = %d, want %d", peak, tt.want)
+			}
+			if tt.want == 1 && !reflect.DeepEqual(arrived, ids) {
+				t.Errorf("serial requests arrived in order %v, want %v", arrived, ids)
+			}
+			var reported []string
+			for _, node := range report.Nodes {
+				reported = append(reported, node.NodeID)
+			}
+			if !reflect.DeepEqual(reported, ids) {
+				t.Errorf("report lists nodes %v, want topological order %v", reported, ids)
+			}
+		})
+	}
+}
+
+func TestRunFlowJoinMode(t *testing.T) {
+	tests := []struct {
+		name      string
+		mode      string
+		wantFromB bool // whether the join saw the slow branch's env
+	}{
+		{"all by default", "", true},
+		{"all", JoinModeAll, true},
+		{"any", JoinModeAny, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			afterRan := make(chan struct{})
+			var once sync.Once
+			mux := http.NewServeMux()
+			mux.HandleFunc("/fast", func(w http.ResponseWriter, r *http.Request) {})
+			mux.HandleFunc("/slow", func(w http.ResponseWriter, r *http.Request) {
+				// An "any" join lets the node after it run before this
+				// branch finishes; an "all" join never does
+				select {
+				case <-afterRan:
+				case <-time.After(200 * time.Millisecond):
+				}
+			})
+			mux.HandleFunc("/after", func(w http.ResponseWriter, r *http.Request) {
+				once.Do(func() { close(afterRan) })
+			})
+			srv := httptest.NewServer(mux)
+			defer srv.Close()
+
+			flow := diamond(types.FlowNodeData{JoinMode: tt.mode})
+			flow.Settings.MaxParallelism = 2
+			resolve := resolveTestATs(srv, map[string]testAT{
+				"a":     {path: "/fast", setEnv: map[string]interface{}{"from_a": "1"}},
+				"b":     {path: "/slow", setEnv: map[string]interface{}{"from_b": "1"}},
+				"after": {path: "/after"},
+			})
+
+			report := RunFlow(context.Background(), flow, map[string]string{}, FlowRunOptions{ResolveAT: resolve})
+			if report.Status != types.StatusPassed {
+				t.Fatalf("status = %s, want passed (nodes %v)", report.Status, nodeStatuses(report))
+			}
+			join := nodeResult(report, "j")
+			if join.EnvIn["from_a"] != "1" {
+				t.Errorf("join env = %v, want from_a from the fast branch", join.EnvIn)
+			}
+			if _, got := join.EnvIn["from_b"]; got != tt.wantFromB {
+				t.Errorf("join env = %v, want from_b present = %v", join.EnvIn, tt.wantFromB)
+			}
+			if nodeResult(report, "after").Status != types.StatusPassed {
+				t.Errorf("after status = %s, want passed", nodeResult(report, "after").Status)
+			}
+		})
+	}
+}
+
+func TestRunFlowJoinConflictPolicy(t *testing.T) {
+	tests := []struct {
+		name       string
+		policy     string
+		a, b       string
+		wantStatus string
+		wantValue  string
+	}{
+		{"last write by default", "", "x", "y", types.StatusPassed, "y"},
+		{"last write", ConflictLastWrite, "x", "y", types.StatusPassed, "y"},
+		{"first write", ConflictFirstWrite, "x", "y", types.StatusPassed, "x"},
+		{"error on differing values", ConflictError, "x", "y", types.StatusError, ""},
+		{"error allows equal values", ConflictError, "x", "x", types.StatusPassed, "x"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			srv := httptest.NewServer(http.HandlerFunc(okHandler))
+			defer srv.Close()
+
+			flow := diamond(types.FlowNodeData{ConflictPolicy: tt.policy})
+			flow.Settings.MaxParallelism = 2
+			resolve := resolveTestATs(srv, map[string]testAT{
+				"a":     {path: "/a", setEnv: map[string]interface{}{"k": tt.a}},
+				"b":     {path: "/b", setEnv: map[string]interface{}{"k": tt.b}},
+				"after": {path: "/after"},
+			})
+
+			report := RunFlow(context.Background(), flow, map[string]string{}, FlowRunOptions{ResolveAT: resolve})
+			join := nodeResult(report, "j")
+			if join.Status != tt.wantStatus {
+				t.Fatalf("join status = %s (%s), want %s", join.Status, join.Error, tt.wantStatus)
+			}
+			if tt.wantStatus != types.StatusPassed {
+				if report.Status != types.StatusFailed {
+					t.Errorf("run status = %s, want failed", report.Status)
+				}
+				if got := nodeResult(report, "after").Status; got != types.StatusSkipped {
+					t.Errorf("after status = %s, want skipped", got)
+				}
+				return
+			}
+			if got := nodeResult(report, "after").EnvIn["k"]; got != tt.wantValue {
+				t.Errorf("k after the join = %q, want %q", got, tt.wantValue)
+			}
+		})
+	}
+}
+
+func TestRunFlowCancellation(t *testing.T) {
+	tests := []struct {
+		name        string
+		parallelism int
+	}{
+		{"serial", 1},
+		{"parallel", 2},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ctx, cancel := context.WithCancel(context.Background())
+			defer cancel()
+
+			var mu sync.Mutex
+			hits := map[string]int{}
+			released := make(chan struct{})
+			srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				mu.Lock()
+				hits[r.URL.Path]++
+				mu.Unlock()
+				if r.URL.Path == "/block" {
+					// Cancel the run while this node's request is in flight
+					cancel()
+					select {
+					case <-released:
+					case <-time.After(2 * time.Second):
+					}
+				}
+			}))
+			defer srv.Close()
+
+			flow := chain("first", "blocked", "last")
+			flow.Settings.MaxParallelism = tt.parallelism
+			resolve := resolveTestATs(srv, map[string]testAT{
+				"first":   {path: "/first"},
+				"blocked": {path: "/block"},
+				"last":    {path: "/last"},
+			})
+
+			report := RunFlow(ctx, flow, map[string]string{}, FlowRunOptions{ResolveAT: resolve})
+			close(released)
+			if report.Status != types.StatusCancelled {
+				t.Errorf("run status = %s, want cancelled", report.Status)
+			}
+			want := map[string]string{
+				"first":   types.StatusPassed,
+				"blocked": types.StatusCancelled,
+				"last":    types.StatusCancelled,
+			}
+			if got := nodeStatuses(report); !reflect.DeepEqual(got, want) {
+				t.Errorf("node statuses = %v, want %v", got, want)
+			}
+			mu.Lock()
+			defer mu.Unlock()
+			if hits["/last"] != 0 {
+				t.Error("a node after the cancellation sent its request")
+			}
+		})
+	}
+}
@@ -0,0 +1,174 @@
+package services
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"sort"
+
+	"zukify.com/types"
+)
+
+// FlakyRun is the outcome of one AT run, as scored by ScoreFlakiness.
+type FlakyRun struct {
+	ATID    int
+	ATHash  string // identifies the version of the AT that ran
+	Status  string
+	Results []types.TestResult
+}
+
+// ATHash fingerprints the AT that a run executed, after node overrides.
+// Runs with the same hash sent the same request and test cases, up to the
+// env. Quarantined cases are left out, since quarantining a case does not
+// change what runs.
+func ATHash(at types.ATRequest) string {
+	at.Quarantined = nil
+	data, _ := json.Marshal(at)
+	sum := sha256.Sum256(data)
+	return hex.EncodeToString(sum[:])
+}
+
+// CaseKeys identifies the test cases of a run across runs: a case is keyed by
+// its name, followed by "#2", "#3"... when the AT repeats the name.
+func CaseKeys(results []types.TestResult) []string {
+	keys := make([]string, len(results))
+	seen := make(map[string]int, len(results))
+	for i, r := range results {
+		seen[r.Case]++
+		keys[i] = r.Case
+		if n := seen[r.Case]; n > 1 {
+			keys[i] = fmt.Sprintf("%s#%d", r.Case, n)
+		}
+	}
+	return keys
+}
+
+// markQuarantined flags the results of quarantined test cases, so that they
+// are still reported but no longer count towards AllImpPassed.
+func markQuarantined(results []types.TestResult, quarantined []string) {
+	if len(quarantined) == 0 {
+		return
+	}
+	skip := make(map[string]bool, len(quarantined))
+	for _, key := range quarantined {
+		skip[key] = true
+	}
+	for i, key := range CaseKeys(results) {
+		results[i].Quarantined = skip[key]
+	}
+}
+
+// flipCounter tracks how often an outcome changes between consecutive runs
+// of the same version of an AT.
+type flipCounter struct {
+	runs, pairs, flips, failures int
+	lastHash                     string
+	lastPassed                   bool
+}
+
+func (f *flipCounter) add(hash string, passed bool) {
+	if f.runs > 0 && hash == f.lastHash {
+		f.pairs++
+		if passed != f.lastPassed {
+			f.flips++
+		}
+	}
+	if !passed {
+		f.failures++
+	}
+	f.runs++
+	f.lastHash, f.lastPassed = hash, passed
+}
+
+func (f *flipCounter) score() float64 {
+	if f.pairs == 0 {
+		return 0
+	}
+	return float64(f.flips) / float64(f.pairs)
+}
+
+// ScoreFlakiness scores how often ATs and their test cases flip between
+// passing and failing while the AT itself is unchanged. runs must be grouped
+// by AT and ordered oldest first within each AT; cancelled runs and runs
+// that did not pass or fail are ignored. Only ATs and cases that flipped and
+// ran at least minRuns times are reported. quarantined maps AT IDs to their
+// quarantined case keys.
+func ScoreFlakiness(runs []FlakyRun, minRuns int, quarantined map[int][]string) types.FlakinessReport {
+	report := types.FlakinessReport{ATs: []types.ATFlakiness{}, Cases: []types.CaseFlakiness{}}
+
+	for start := 0; start < len(runs); {
+		end := start
+		for end < len(runs) && runs[end].ATID == runs[start].ATID {
+			end++
+		}
+		if at, ok := scoreAT(runs[start:end], minRuns, quarantined[runs[start].ATID]); ok {
+			report.ATs = append(report.ATs, at)
+			report.Cases = append(report.Cases, at.Cases...)
+		}
+		start = end
+	}
+
+	sort.SliceStable(report.ATs, func(i, j int) bool {
+		return report.ATs[i].Score > report.ATs[j].Score ||
+			report.ATs[i].Score == report.ATs[j].Score && report.ATs[i].Flips > report.ATs[j].Flips
+	})
+	sortCases(report.Cases)
+	return report
+}
+
+// scoreAT scores the runs of a single AT.
+func scoreAT(runs []FlakyRun, minRuns int, quarantined []string) (types.ATFlakiness, bool) {
+	isQuarantined := make(map[string]bool, len(quarantined))
+	for _, key := range quarantined {
+		isQuarantined[key] = true
+	}
+
+	var status flipCounter
+	cases := make(map[string]*flipCounter)
+	var order []string
+	for _, run := range runs {
+		if run.Status != types.StatusPassed && run.Status != types.StatusFailed {
+			continue
+		}
+		status.add(run.ATHash, run.Status == types.StatusPassed)
+		for i, key := range CaseKeys(run.Results) {
+			counter, ok := cases[key]
+			if !ok {
+				counter = &flipCounter{}
+				cases[key] = counter
+				order = append(order, key)
+			}
+			counter.add(run.ATHash, run.Results[i].Passed)
+		}
+	}
+
+	at := types.ATFlakiness{ATID: runs[0].ATID, Runs: status.runs, Flips: status.flips, Score: status.score(), Cases: []types.CaseFlakiness{}}
+	for _, key := range order {
+		counter := cases[key]
+		if counter.flips == 0 || counter.runs < minRuns {
+			continue
+		}
+		at.Cases = append(at.Cases, types.CaseFlakiness{
+			ATID:        at.ATID,
+			Case:        key,
+			Runs:        counter.runs,
+			Flips:       counter.flips,
+			Failures:    counter.failures,
+			Score:       counter.score(),
+			Quarantined: isQuarantined[key],
+		})
+	}
+	sortCases(at.Cases)
+
+	// A quarantined case can flip without flipping the AT
+	flaky := at.Flips > 0 || len(at.Cases) > 0
+	return at, flaky && at.Runs >= minRuns
+}
+
+func sortCases(cases []types.CaseFlakiness) {
+	sort.SliceStable(cases, func(i, j int) bool {
+		return cases[i].Score > cases[j].Score ||
+			cases[i].Score == cases[j].Score && cases[i].Flips > cases[j].Flips
+	})
+}
@@ -0,0 +1,215 @@
+package services
+
+import (
+	"context"
+	"errors"
+	"os"
+	"sort"
+	"sync"
+	"time"
+
+	"zukify.com/types"
+)
+
+// ErrNotPaused is returned by debug commands that need a paused run.
+var ErrNotPaused = errors.New("run is not paused")
+
+const defaultDebugIdleTimeout = 30 * time.Minute
+
+var (
+	debugMu  sync.Mutex
+	debugged = make(map[string]*DebugSession)
+)
+
+// DebugIdleTimeout is how long a debug run may stay paused without any
+// debug command before it is cancelled. It can be overridden with the
+// DEBUG_IDLE_TIMEOUT environment variable, as a Go duration such as "5m".
+func DebugIdleTimeout() time.Duration {
+	if v, err := time.ParseDuration(os.Getenv("DEBUG_IDLE_TIMEOUT")); err == nil && v > 0 {
+		return v
+	}
+	return defaultDebugIdleTimeout
+}
+
+// DebugSession steps through a flow run. The run pauses before every
+// top-level node with a breakpoint, and before the next node after a step,
+// until it is continued, stepped or aborted. While paused, the env the node
+// will start from can be edited. Once the run is cancelled, whether aborted
+// or left idle for DebugIdleTimeout, it no longer pauses, so teardown runs
+// straight through.
+type DebugSession struct {
+	RunID string
+	WID   string
+
+	run         context.Context
+	activity    chan struct{}
+	events      EventSink
+	mu          sync.Mutex
+	breakpoints map[string]bool
+	stepping    bool
+	paused      *types.DebugPause
+	render      RequestRenderer
+	resume      chan bool // receives whether to pause before the next node
+}
+
+// NewDebugSession creates the debug session of the run with context run and
+// registers it under the run's ID. With step set, the run pauses before its
+// first node. Pauses are reported to events as node_paused events.
+func NewDebugSession(run context.Context, runID, wid string, breakpoints []string, step bool, events EventSink) *DebugSession {
+	d := &DebugSession{RunID: runID, WID: wid, run: run, activity: make(chan struct{}, 1), events: events, stepping: step}
+	d.SetBreakpoints(breakpoints)
+	debugMu.Lock()
+	debugged[runID] = d
+	debugMu.Unlock()
+	return d
+}
+
+// GetDebugSession returns the debug session of a running flow.
+func GetDebugSession(runID string) (*DebugSession, bool) {
+	debugMu.Lock()
+	defer debugMu.Unlock()
+	d, ok := debugged[runID]
+	return d, ok
+}
+
+// Close unregisters the session once its run has finished.
+func (d *DebugSession) Close() {
+	debugMu.Lock()
+	if debugged[d.RunID] == d {
+		delete(debugged, d.RunID)
+	}
+	debugMu.Unlock()
+}
+
+// BeforeNode is the run's NodeHook. It blocks while the run is paused
+// before node and returns the env, as edited while paused.
+func (d *DebugSession) BeforeNode(ctx context.Context, node *types.FlowNode, env map[string]string, render RequestRenderer) map[string]string {
+	d.mu.Lock()
+	if d.run.Err() != nil || !d.stepping && !d.breakpoints[node.ID] {
+		d.mu.Unlock()
+		return env
+	}
+	d.paused = &types.DebugPause{NodeID: node.ID, ATID: string(node.Data.ATID), Env: copyEnv(env)}
+	d.render = render
+	d.renderPaused()
+	resume := make(chan bool, 1)
+	d.resume = resume
+	pause := *d.paused
+	d.mu.Unlock()
+
+	if d.events != nil {
+		d.events(types.RunEvent{Type: types.EventNodePaused, NodeID: pause.NodeID, ATID: pause.ATID, Env: copyEnv(pause.Env), Request: pause.Request})
+	}
+
+	idle := time.NewTimer(DebugIdleTimeout())
+	defer idle.Stop()
+	var step bool
+wait:
+	for {
+		select {
+		case step = <-resume:
+			break wait
+		case <-d.activity:
+			idle.Reset(DebugIdleTimeout())
+		case <-idle.C:
+			CancelRun(d.RunID, d.WID)
+			break wait
+		case <-ctx.Done():
+			break wait
+		case <-d.run.Done():
+			break wait
+		}
+	}
+
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	env = d.paused.Env
+	d.paused = nil
+	d.render = nil
+	d.resume = nil
+	d.stepping = step
+	return env
+}
+
+// touch resets the idle timeout of a paused run.
+func (d *DebugSession) touch() {
+	select {
+	case d.activity <- struct{}{}:
+	default:
+	}
+}
+
+// renderPaused renders the request of the paused node for its current env.
+func (d *DebugSession) renderPaused() {
+	d.paused.Request, d.paused.RequestError = nil, ""
+	if d.render == nil {
+		return
+	}
+	request, err := d.render(d.paused.Env)
+	if err != nil {
+		d.paused.RequestError = err.Error()
+		return
+	}
+	d.paused.Request = request
+}
+
+// State returns where the run is and, when paused, the paused node.
+func (d *DebugSession) State() types.DebugState {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	state := types.DebugState{RunID: d.RunID, Status: types.DebugRunning, Breakpoints: []string{}}
+	for id := range d.breakpoints {
+		state.Breakpoints = append(state.Breakpoints, id)
+	}
+	sort.Strings(state.Breakpoints)
+	if d.paused != nil {
+		pause := *d.paused
+		pause.Env = copyEnv(pause.Env)
+		state.Status = types.DebugPaused
+		state.Paused = &pause
+	}
+	return state
+}
+
+// SetBreakpoints replaces the nodes the run pauses before.
+func (d *DebugSession) SetBreakpoints(nodeIDs []string) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	d.touch()
+	d.breakpoints = make(map[string]bool, len(nodeIDs))
+	for _, id := range nodeIDs {
+		d.breakpoints[id] = true
+	}
+}
+
+// SetEnv changes env values of the paused node, removing those in unset,
+// and renders its request again.
+func (d *DebugSession) SetEnv(values map[string]string, unset []string) error {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	if d.paused == nil {
+		return ErrNotPaused
+	}
+	d.touch()
+	for k, v := range values {
+		d.paused.Env[k] = v
+	}
+	for _, k := range unset {
+		delete(d.paused.Env, k)
+	}
+	d.renderPaused()
+	return nil
+}
+
+// Continue resumes a paused run. With step set, the run pauses again before
+// the next node; otherwise it runs until the next breakpoint.
+func (d *DebugSession) Continue(step bool) error {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	if d.paused == nil || d.resume == nil {
+		return ErrNotPaused
+	}
+	d.resume <- step
+	d.resume = nil
+	return nil
+}
@@ -0,0 +1,326 @@
+package services
+
+import (
+	"fmt"
+	"strings"
+
+	"zukify.com/types"
+)
+
+// Diagram formats supported by RenderFlowDiagram.
+const (
+	DiagramMermaid = "mermaid"
+	DiagramDOT     = "dot"
+)
+
+// Fill and stroke colours of nodes by run status.
+var diagramColors = map[string][2]string{
+	types.StatusPassed:    {"#d3f9d8", "#2b8a3e"},
+	types.StatusFailed:    {"#ffe3e3", "#c92a2a"},
+	types.StatusError:     {"#ffc9c9", "#a61e4d"},
+	types.StatusSkipped:   {"#f1f3f5", "#868e96"},
+	types.StatusCancelled: {"#fff3bf", "#e67700"},
+}
+
+// diagram is a flow laid out as boxes, arrows and groups, independent of
+// the output format.
+type diagram struct {
+	nodes  []diagramNode
+	edges  []diagramEdge
+	groups []diagramGroup
+}
+
+type diagramNode struct {
+	id     string
+	lines  []string
+	shape  string // a node type, or "" for AT nodes
+	status string
+	group  string
+}
+
+type diagramEdge struct {
+	from, to string
+	label    string
+	dashed   bool
+}
+
+type diagramGroup struct {
+	id, label, parent string
+}
+
+// RenderFlowDiagram renders a flow as Mermaid or DOT text. Loop bodies are
+// drawn as groups next to their loop node, and setup and teardown nodes are
+// grouped by phase. statuses, keyed like DiagramStatuses, colours nodes with
+// the outcome of a run; it may be nil.
+func RenderFlowDiagram(flow types.Flow, format string, statuses map[string]string) (string, error) {
+	d := &diagram{}
+	d.add(flow, "", "", statuses)
+	switch format {
+	case DiagramMermaid:
+		return d.mermaid(), nil
+	case DiagramDOT:
+		return d.dot(), nil
+	}
+	return "", fmt.Errorf("unknown diagram format %q", format)
+}
+
+// DiagramStatuses maps the nodes of a run to their status. Nodes inside loop
+// bodies are keyed by their path, like the parent of their events, and take
+// the status of the loop's last iteration.
+func DiagramStatuses(results []types.FlowNodeResult) map[string]string {
+	statuses := make(map[string]string)
+	var walk func(results []types.FlowNodeResult, prefix string)
+	walk = func(results []types.FlowNodeResult, prefix string) {
+		for _, result := range results {
+			statuses[prefix+result.NodeID] = result.Status
+			if n := len(result.Iterations); n > 0 {
+				walk(result.Iterations[n-1].Nodes, prefix+result.NodeID+"/")
+			}
+		}
+	}
+	walk(results, "")
+	return statuses
+}
+
+// add lays out a flow or loop body inside group and returns the diagram IDs
+// of its nodes.
+func (d *diagram) add(flow types.Flow, path, group string, statuses map[string]string) map[string]string {
+	ids := make(map[string]string, len(flow.Nodes))
+	phases := make(map[string]string)
+	for _, node := range flow.Nodes {
+		if _, ok := ids[node.ID]; ok {
+			continue
+		}
+		id := fmt.Sprintf("n%d", len(d.nodes))
+		ids[node.ID] = id
+
+		nodeGroup := group
+		if phase := node.Data.Phase; phase != PhaseMain {
+			if phases[phase] == "" {
+				phases[phase] = fmt.Sprintf("%s_%s", orDefault(group, "flow"), phase)
+				d.groups = append(d.groups, diagramGroup{id: phases[phase], label: strings.ToUpper(phase[:1]) + phase[1:], parent: group})
+			}
+			nodeGroup = phases[phase]
+		}
+
+		d.nodes = append(d.nodes, diagramNode{
+			id:     id,
+			lines:  diagramLabel(node),
+			shape:  node.Type,
+			status: statuses[path+node.ID],
+			group:  nodeGroup,
+		})
+
+		if body := node.Data.Body; body != nil && (node.Type == NodeTypeForEach || node.Type == NodeTypeRepeat) {
+			bodyGroup := "g" + id
+			d.groups = append(d.groups, diagramGroup{id: bodyGroup, label: "Body of " + orDefault(node.Data.Label, node.ID), parent: nodeGroup})
+			inner := d.add(*body, path+node.ID+"/", bodyGroup, statuses)
+			hasIncoming := make(map[string]bool)
+			for _, edge := range body.Edges {
+				hasIncoming[edge.Target] = true
+			}
+			for _, child := range body.Nodes {
+				if !hasIncoming[child.ID] {
+					d.edges = append(d.edges, diagramEdge{from: id, to: inner[child.ID], label: "body", dashed: true})
+				}
+			}
+		}
+	}
+
+	for _, edge := range flow.Edges {
+		from, okFrom := ids[edge.Source]
+		to, okTo := ids[edge.Target]
+		if !okFrom || !okTo {
+			continue
+		}
+		var label []string
+		if edge.SourceHandle != "" {
+			label = append(label, edge.SourceHandle)
+		}
+		if edge.Condition != "" {
+			label = append(label, edge.Condition)
+		}
+		d.edges = append(d.edges, diagramEdge{from: from, to: to, label: strings.Join(label, ": ")})
+	}
+	return ids
+}
+
+// diagramLabel describes a node in one or more lines.
+func diagramLabel(node types.FlowNode) []string {
+	data := node.Data
+	name := orDefault(data.Label, node.ID)
+	switch node.Type {
+	case NodeTypeStart:
+		return []string{orDefault(data.Label, "Start")}
+	case NodeTypeEnd:
+		return []string{orDefault(data.Label, "End")}
+	case NodeTypeCondition:
+		if data.Label != "" {
+			return []string{data.Label, data.Condition}
+		}
+		return []string{data.Condition}
+	case NodeTypeJoin:
+		return []string{name, "join " + orDefault(data.JoinMode, JoinModeAll)}
+	case NodeTypeForEach:
+		return []string{name, "foreach " + data.Items}
+	case NodeTypeRepeat:
+		return []string{name, "repeat until " + data.Until}
+	case NodeTypeSubFlow:
+		return []string{name, "flow " + string(data.FlowID)}
+	}
+	lines := []string{name}
+	if data.ATID != "" {
+		lines = append(lines, "AT "+string(data.ATID))
+	}
+	if data.OnFailure == OnFailureContinue {
+		lines = append(lines, "continue on failure")
+	}
+	return lines
+}
+
+func (d *diagram) mermaid() string {
+	var b strings.Builder
+	b.WriteString("flowchart TD\n")
+
+	byGroup := make(map[string][]diagramNode)
+	for _, node := range d.nodes {
+		byGroup[node.group] = append(byGroup[node.group], node)
+	}
+	var writeGroup func(group, indent string)
+	writeGroup = func(group, indent string) {
+		for _, node := range byGroup[group] {
+			open, close := mermaidShape(node.shape)
+			fmt.Fprintf(&b, "%s%s%s\"%s\"%s\n", indent, node.id, open, mermaidText(strings.Join(node.lines, "\n")), close)
+		}
+		for _, g := range d.groups {
+			if g.parent == group {
+				fmt.Fprintf(&b, "%ssubgraph %s [\"%s\"]\n", indent, g.id, mermaidText(g.label))
+				writeGroup(g.id, indent+"  ")
+				fmt.Fprintf(&b, "%send\n", indent)
+			}
+		}
+	}
+	writeGroup("", "  ")
+
+	for _, edge := range d.edges {
+		arrow := "-->"
+		if edge.dashed {
+			arrow = "-.->"
+		}
+		if edge.label != "" {
+			fmt.Fprintf(&b, "  %s %s|\"%s\"| %s\n", edge.from, arrow, mermaidText(edge.label), edge.to)
+		} else {
+			fmt.Fprintf(&b, "  %s %s %s\n", edge.from, arrow, edge.to)
+		}
+	}
+
+	used := make(map[string]bool)
+	for _, node := range d.nodes {
+		if _, ok := diagramColors[node.status]; ok {
+			fmt.Fprintf(&b, "  class %s %s\n", node.id, node.status)
+			used[node.status] = true
+		}
+	}
+	for _, status := range []string{types.StatusPassed, types.StatusFailed, types.StatusError, types.StatusSkipped, types.StatusCancelled} {
+		if used[status] {
+			colors := diagramColors[status]
+			fmt.Fprintf(&b, "  classDef %s fill:%s,stroke:%s\n", status, colors[0], colors[1])
+		}
+	}
+	return b.String()
+}
+
+func mermaidShape(nodeType string) (string, string) {
+	switch nodeType {
+	case NodeTypeStart, NodeTypeEnd:
+		return "([", "])"
+	case NodeTypeCondition:
+		return "{", "}"
+	case NodeTypeJoin:
+		return "((", "))"
+	case NodeTypeForEach, NodeTypeRepeat:
+		return "[/", "/]"
+	case NodeTypeSubFlow:
+		return "[[", "]]"
+	}
+	return "[", "]"
+}
+
+// mermaidText escapes text for a quoted Mermaid label.
+func mermaidText(s string) string {
+	return strings.NewReplacer(`"`, "#quot;", "<", "#lt;", ">", "#gt;", "\n", "<br/>").Replace(s)
+}
+
+func (d *diagram) dot() string {
+	var b strings.Builder
+	b.WriteString("digraph flow {\n")
+	b.WriteString("  rankdir=TB;\n")
+	b.WriteString("  node [shape=box, style=\"rounded,filled\", fillcolor=\"#ffffff\", fontname=\"Helvetica\"];\n")
+	b.WriteString("  edge [fontname=\"Helvetica\", fontsize=10];\n")
+
+	byGroup := make(map[string][]diagramNode)
+	for _, node := range d.nodes {
+		byGroup[node.group] = append(byGroup[node.group], node)
+	}
+	var writeGroup func(group, indent string)
+	writeGroup = func(group, indent string) {
+		for _, node := range byGroup[group] {
+			attrs := []string{"label=" + dotText(strings.Join(node.lines, "\n"))}
+			if shape := dotShape(node.shape); shape != "" {
+				attrs = append(attrs, "shape="+shape)
+			}
+			if colors, ok := diagramColors[node.status]; ok {
+				attrs = append(attrs, "fillcolor="+dotText(colors[0]), "color="+dotText(colors[1]))
+			}
+			fmt.Fprintf(&b, "%s%s [%s];\n", indent, node.id, strings.Join(attrs, ", "))
+		}
+		for _, g := range d.groups {
+			if g.parent == group {
+				fmt.Fprintf(&b, "%ssubgraph cluster_%s {\n", indent, g.id)
+				fmt.Fprintf(&b, "%s  label=%s;\n", indent, dotText(g.label))
+				fmt.Fprintf(&b, "%s  style=dashed;\n", indent)
+				writeGroup(g.id, indent+"  ")
+				fmt.Fprintf(&b, "%s}\n", indent)
+			}
+		}
+	}
+	writeGroup("", "  ")
+
+	for _, edge := range d.edges {
+		var attrs []string
+		if edge.label != "" {
+			attrs = append(attrs, "label="+dotText(edge.label))
+		}
+		if edge.dashed {
+			attrs = append(attrs, "style=dashed")
+		}
+		if len(attrs) > 0 {
+			fmt.Fprintf(&b, "  %s -> %s [%s];\n", edge.from, edge.to, strings.Join(attrs, ", "))
+		} else {
+			fmt.Fprintf(&b, "  %s -> %s;\n", edge.from, edge.to)
+		}
+	}
+	b.WriteString("}\n")
+	return b.String()
+}
+
+func dotShape(nodeType string) string {
+	switch nodeType {
+	case NodeTypeStart, NodeTypeEnd:
+		return "ellipse"
+	case NodeTypeCondition:
+		return "diamond"
+	case NodeTypeJoin:
+		return "circle"
+	case NodeTypeForEach, NodeTypeRepeat:
+		return "parallelogram"
+	case NodeTypeSubFlow:
+		return "box3d"
+	}
+	return ""
+}
+
+// dotText quotes text as a DOT string.
+func dotText(s string) string {
+	return `"` + strings.NewReplacer(`\`, `\\`, `"`, `\"`, "\n", `\n`).Replace(s) + `"`
+}
@@ -0,0 +1,113 @@
+package services
+
+import (
+	"encoding/json"
+	"reflect"
+	"sort"
+
+	"zukify.com/types"
+)
+
+// DiffFlows compares two versions of a flow, matching nodes and edges by
+// ID. Changed nodes and edges list the JSON fields that differ.
+func DiffFlows(before, after types.Flow) types.FlowDiff {
+	diff := types.FlowDiff{
+		AddedNodes:   []types.FlowNode{},
+		RemovedNodes: []types.FlowNode{},
+		ChangedNodes: []types.NodeChange{},
+		AddedEdges:   []types.FlowEdge{},
+		RemovedEdges: []types.FlowEdge{},
+		ChangedEdges: []types.EdgeChange{},
+	}
+
+	oldNodes := make(map[string]types.FlowNode, len(before.Nodes))
+	for _, node := range before.Nodes {
+		oldNodes[node.ID] = node
+	}
+	newNodes := make(map[string]bool, len(after.Nodes))
+	for _, node := range after.Nodes {
+		newNodes[node.ID] = true
+		old, ok := oldNodes[node.ID]
+		if !ok {
+			diff.AddedNodes = append(diff.AddedNodes, node)
+			continue
+		}
+		if fields := changedFields(old, node); len(fields) > 0 {
+			diff.ChangedNodes = append(diff.ChangedNodes, types.NodeChange{ID: node.ID, Fields: fields, Before: old, After: node})
+		}
+	}
+	for _, node := range before.Nodes {
+		if !newNodes[node.ID] {
+			diff.RemovedNodes = append(diff.RemovedNodes, node)
+		}
+	}
+
+	oldEdges := make(map[string]types.FlowEdge, len(before.Edges))
+	for _, edge := range before.Edges {
+		oldEdges[edge.ID] = edge
+	}
+	newEdges := make(map[string]bool, len(after.Edges))
+	for _, edge := range after.Edges {
+		newEdges[edge.ID] = true
+		old, ok := oldEdges[edge.ID]
+		if !ok {
+			diff.AddedEdges = append(diff.AddedEdges, edge)
+			continue
+		}
+		if fields := changedFields(old, edge); len(fields) > 0 {
+			diff.ChangedEdges = append(diff.ChangedEdges, types.EdgeChange{ID: edge.ID, Fields: fields, Before: old, After: edge})
+		}
+	}
+	for _, edge := range before.Edges {
+		if !newEdges[edge.ID] {
+			diff.RemovedEdges = append(diff.RemovedEdges, edge)
+		}
+	}
+
+	diff.SettingsChanged = len(changedFields(before.Settings, after.Settings)) > 0
+	return diff
+}
+
+// changedFields compares two values through their JSON form and returns the
+// dotted paths of the fields that differ, descending into "data".
+func changedFields(before, after interface{}) []string {
+	var a, b map[string]interface{}
+	ab, _ := json.Marshal(before)
+	bb, _ := json.Marshal(after)
+	json.Unmarshal(ab, &a)
+	json.Unmarshal(bb, &b)
+
+	var fields []string
+	for _, key := range unionKeys(a, b) {
+		if reflect.DeepEqual(a[key], b[key]) {
+			continue
+		}
+		da, okA := a[key].(map[string]interface{})
+		db, okB := b[key].(map[string]interface{})
+		if key == "data" && (okA || a[key] == nil) && (okB || b[key] == nil) {
+			for _, sub := range unionKeys(da, db) {
+				if !reflect.DeepEqual(da[sub], db[sub]) {
+					fields = append(fields, "data."+sub)
+				}
+			}
+			continue
+		}
+		fields = append(fields, key)
+	}
+	return fields
+}
+
+func unionKeys(a, b map[string]interface{}) []string {
+	seen := make(map[string]bool, len(a)+len(b))
+	var keys []string
+	for _, m := range []map[string]interface{}{a, b} {
+		for k := range m {
+			if !seen[k] {
+				seen[k] = true
+				keys = append(keys, k)
+			}
+		}
+	}
+	sort.Strings(keys)
+	return keys
+}
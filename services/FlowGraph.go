@@ -0,0 +1,85 @@
+package services
+
+import (
+	"encoding/json"
+	"fmt"
+
+	"zukify.com/types"
+)
+
+// flowGraph indexes a flow's nodes and edges for traversal.
+type flowGraph struct {
+	nodes map[string]*types.FlowNode
+	order []string // node IDs in declaration order
+	out   map[string][]*types.FlowEdge
+	in    map[string][]*types.FlowEdge
+}
+
+// ParseFlow decodes the flow_data column of a saved flow.
+func ParseFlow(data string) (types.Flow, error) {
+	var flow types.Flow
+	if err := json.Unmarshal([]byte(data), &flow); err != nil {
+		return flow, fmt.Errorf("failed to parse flow data: %v", err)
+	}
+	return flow, nil
+}
+
+// buildFlowGraph indexes the flow, rejecting duplicate node IDs and edges
+// whose endpoints do not exist.
+func buildFlowGraph(flow types.Flow) (*flowGraph, error) {
+	g := &flowGraph{
+		nodes: make(map[string]*types.FlowNode),
+		out:   make(map[string][]*types.FlowEdge),
+		in:    make(map[string][]*types.FlowEdge),
+	}
+	for i := range flow.Nodes {
+		node := &flow.Nodes[i]
+		if _, dup := g.nodes[node.ID]; dup {
+			return nil, fmt.Errorf("duplicate node id %q", node.ID)
+		}
+		g.nodes[node.ID] = node
+		g.order = append(g.order, node.ID)
+	}
+	for i := range flow.Edges {
+		edge := &flow.Edges[i]
+		if _, ok := g.nodes[edge.Source]; !ok {
+			return nil, fmt.Errorf("edge %q starts at unknown node %q", edge.ID, edge.Source)
+		}
+		if _, ok := g.nodes[edge.Target]; !ok {
+			return nil, fmt.Errorf("edge %q ends at unknown node %q", edge.ID, edge.Target)
+		}
+		g.out[edge.Source] = append(g.out[edge.Source], edge)
+		g.in[edge.Target] = append(g.in[edge.Target], edge)
+	}
+	return g, nil
+}
+
+// topoOrder returns the node IDs in topological order (Kahn's algorithm),
+// breaking ties by declaration order so runs are deterministic.
+func (g *flowGraph) topoOrder() ([]string, error) {
+	indegree := make(map[string]int, len(g.order))
+	for _, id := range g.order {
+		indegree[id] = len(g.in[id])
+	}
+
+	var order []string
+	done := make(map[string]bool, len(g.order))
+	for len(order) < len(g.order) {
+		progressed := false
+		for _, id := range g.order {
+			if done[id] || indegree[id] > 0 {
+				continue
+			}
+			done[id] = true
+			order = append(order, id)
+			progressed = true
+			for _, edge := range g.out[id] {
+				indegree[edge.Target]--
+			}
+		}
+		if !progressed {
+			return nil, fmt.Errorf("flow contains a cycle")
+		}
+	}
+	return order, nil
+}
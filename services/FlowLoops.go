@@ -0,0 +1,173 @@
+package services
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+
+	"zukify.com/types"
+)
+
+// defaultMaxIterations bounds repeat loops that do not set max_iterations.
+const defaultMaxIterations = 100
+
+// runLoopNode runs the body of a "foreach" or "repeat" node once per
+// iteration. The env produced by an iteration is carried into the next one
+// and out of the loop, except for the item and index variables, which are
+// scoped to the body.
+func runLoopNode(ctx context.Context, node *types.FlowNode, envIn map[string]string, opts FlowRunOptions) types.FlowNodeResult {
+	start := time.Now()
+	data := node.Data
+	result := types.FlowNodeResult{NodeID: node.ID, EnvIn: envIn, NewEnv: envIn, Iterations: []types.FlowIteration{}, StartedAt: formatStartTime(start)}
+
+	if data.Body == nil {
+		result.Status = types.StatusError
+		result.Error = fmt.Sprintf("loop node %q has no body", node.ID)
+		return result
+	}
+
+	var items []string
+	if node.Type == NodeTypeForEach {
+		var err error
+		if items, err = loopItems(data.Items, envIn); err != nil {
+			result.Status = types.StatusError
+			result.Error = err.Error()
+			return result
+		}
+	}
+
+	maxIterations := data.MaxIterations
+	if maxIterations <= 0 {
+		maxIterations = defaultMaxIterations
+		if node.Type == NodeTypeForEach {
+			maxIterations = len(items)
+		}
+	}
+	itemVar := data.ItemVar
+	if itemVar == "" {
+		itemVar = "item"
+	}
+	indexVar := data.IndexVar
+	if indexVar == "" {
+		indexVar = "index"
+	}
+
+	loopCtx := ctx
+	if data.TimeoutMs > 0 {
+		var cancel context.CancelFunc
+		loopCtx, cancel = context.WithTimeout(ctx, time.Duration(data.TimeoutMs)*time.Millisecond)
+		defer cancel()
+	}
+
+	env := copyEnv(envIn)
+	result.Status = types.StatusPassed
+	for i := 0; node.Type != NodeTypeForEach || i < len(items); i++ {
+		if i >= maxIterations {
+			if node.Type == NodeTypeRepeat {
+				result.Status = types.StatusFailed
+				result.Error = fmt.Sprintf("condition not met after %d iterations", maxIterations)
+			}
+			break
+		}
+		if loopCtx.Err() != nil {
+			setLoopInterrupted(ctx, &result)
+			break
+		}
+
+		iterEnv := copyEnv(env)
+		iterEnv[indexVar] = strconv.Itoa(i)
+		iteration := types.FlowIteration{Index: i}
+		if node.Type == NodeTypeForEach {
+			iterEnv[itemVar] = items[i]
+			iteration.Item = items[i]
+		}
+
+		report, last := runFlowGraph(loopCtx, *data.Body, iterEnv, opts.nested(node.ID))
+		iteration.Status = report.Status
+		iteration.Error = report.Error
+		iteration.Nodes = report.Nodes
+		iteration.Env = report.Env
+		result.Iterations = append(result.Iterations, iteration)
+
+		for k, v := range report.Env {
+			if k != itemVar && k != indexVar {
+				env[k] = v
+			}
+		}
+
+		if report.Status == types.StatusCancelled {
+			setLoopInterrupted(ctx, &result)
+			break
+		}
+		if report.Status != types.StatusPassed {
+			result.Status = types.StatusFailed
+			if !data.ContinueOnError {
+				break
+			}
+		}
+
+		if node.Type == NodeTypeRepeat {
+			done, err := EvaluateCondition(data.Until, last)
+			if err != nil {
+				result.Status = types.StatusError
+				result.Error = err.Error()
+				break
+			}
+			if done {
+				break
+			}
+		}
+	}
+
+	result.NewEnv = env
+	result.AllImpPassed = result.Status == types.StatusPassed
+	result.DurationMs = time.Since(start).Milliseconds()
+	return result
+}
+
+// setLoopInterrupted reports a loop stopped by its own timeout as failed and
+// one stopped by the run being cancelled as cancelled.
+func setLoopInterrupted(ctx context.Context, result *types.FlowNodeResult) {
+	if ctx.Err() != nil {
+		result.Status = types.StatusCancelled
+		return
+	}
+	result.Status = types.StatusFailed
+	result.Error = "loop timed out"
+}
+
+// loopItems resolves the array a foreach node iterates over. Items that are
+// not strings are passed to the body as JSON.
+func loopItems(spec string, env map[string]string) ([]string, error) {
+	raw := strings.TrimSpace(spec)
+	switch {
+	case strings.HasPrefix(raw, "["):
+	case strings.Contains(raw, "<<"):
+		raw = replaceVariables(raw, nil, env)
+	default:
+		v, ok := env[raw]
+		if !ok {
+			return nil, fmt.Errorf("variable %q is not set", raw)
+		}
+		raw = v
+	}
+
+	var values []interface{}
+	if err := json.Unmarshal([]byte(raw), &values); err != nil {
+		return nil, fmt.Errorf("items %q is not a JSON array", spec)
+	}
+
+	items := make([]string, 0, len(values))
+	for _, v := range values {
+		if s, ok := v.(string); ok {
+			items = append(items, s)
+			continue
+		}
+		b, _ := json.Marshal(v)
+		items = append(items, string(b))
+	}
+	return items, nil
+}
@@ -0,0 +1,163 @@
+package services
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"time"
+
+	"zukify.com/types"
+)
+
+const defaultTeardownTimeout = 5 * time.Minute
+
+// TeardownTimeout bounds the teardown phase of a flow run, which runs even
+// after the run was cancelled. It can be overridden with the
+// TEARDOWN_TIMEOUT environment variable, as a Go duration such as "30s".
+func TeardownTimeout() time.Duration {
+	if v, err := time.ParseDuration(os.Getenv("TEARDOWN_TIMEOUT")); err == nil && v > 0 {
+		return v
+	}
+	return defaultTeardownTimeout
+}
+
+// Flow phases (data.phase). Nodes without a phase belong to the main phase.
+const (
+	PhaseSetup    = "setup"
+	PhaseMain     = ""
+	PhaseTeardown = "teardown"
+)
+
+// Node failure policies (data.on_failure).
+const (
+	OnFailureStop     = "stop"     // a failing node stops the run (default)
+	OnFailureContinue = "continue" // the run goes on past a failing node
+)
+
+// continuesOnFailure reports whether a failed node lets the run continue
+// along its unconditional edges. A node fails when one of its important
+// test cases fails; the run is still reported as failed.
+func continuesOnFailure(node *types.FlowNode, result types.FlowNodeResult) bool {
+	if node.Data.OnFailure != OnFailureContinue {
+		return false
+	}
+	return result.Status == types.StatusFailed || result.Status == types.StatusError
+}
+
+func hasPhases(flow types.Flow) bool {
+	for _, node := range flow.Nodes {
+		if node.Data.Phase != PhaseMain {
+			return true
+		}
+	}
+	return false
+}
+
+// phaseFlow returns the nodes of one phase and the edges between them.
+// Edges crossing phases are dropped, since the phases run one after the
+// other.
+func phaseFlow(flow types.Flow, phase string) types.Flow {
+	sub := types.Flow{Settings: flow.Settings}
+	in := make(map[string]bool)
+	for _, node := range flow.Nodes {
+		if node.Data.Phase == phase {
+			sub.Nodes = append(sub.Nodes, node)
+			in[node.ID] = true
+		}
+	}
+	for _, edge := range flow.Edges {
+		if in[edge.Source] && in[edge.Target] {
+			sub.Edges = append(sub.Edges, edge)
+		}
+	}
+	return sub
+}
+
+// runPhases runs the setup, main and teardown phases of a flow in turn. The
+// main phase only runs if setup passed. Teardown always runs, even after a
+// failure or cancellation, starting from the env produced by every node that
+// ran before it, so it can clean up whatever was created. It is given
+// TeardownTimeout to do so.
+func runPhases(ctx context.Context, flow types.Flow, env map[string]string, opts FlowRunOptions, report *types.FlowRunReport) conditionContext {
+	setup := phaseReport(env)
+	last := runGraph(ctx, phaseFlow(flow, PhaseSetup), env, opts, &setup)
+
+	main := phaseReport(setup.Env)
+	if setup.Status == types.StatusPassed {
+		last = runGraph(ctx, phaseFlow(flow, PhaseMain), setup.Env, opts, &main)
+	} else {
+		skipPhase(ctx, phaseFlow(flow, PhaseMain), opts, &main)
+	}
+
+	produced := copyEnv(env)
+	for _, result := range append(setup.Nodes, main.Nodes...) {
+		for k, v := range result.NewEnv {
+			produced[k] = v
+		}
+	}
+	teardown := phaseReport(produced)
+	timeout := TeardownTimeout()
+	teardownCtx, cancel := context.WithTimeout(context.WithoutCancel(ctx), timeout)
+	runGraph(teardownCtx, phaseFlow(flow, PhaseTeardown), produced, opts, &teardown)
+	if teardownCtx.Err() == context.DeadlineExceeded && teardown.Error == "" {
+		teardown.Error = fmt.Sprintf("teardown timed out after %s", timeout)
+	}
+	cancel()
+
+	for _, phase := range []*types.FlowRunReport{&setup, &main, &teardown} {
+		report.Nodes = append(report.Nodes, phase.Nodes...)
+	}
+	for i := range report.Nodes {
+		for _, node := range flow.Nodes {
+			if node.ID == report.Nodes[i].NodeID {
+				report.Nodes[i].Phase = node.Data.Phase
+				break
+			}
+		}
+	}
+
+	report.Status, report.Error = setup.Status, setup.Error
+	if report.Status == types.StatusPassed {
+		report.Status, report.Error = main.Status, main.Error
+	}
+	if teardown.Status != types.StatusPassed {
+		if report.Status == types.StatusPassed {
+			report.Status = teardown.Status
+		}
+		if report.Error == "" {
+			report.Error = teardown.Error
+		}
+	}
+
+	// The final env is that of the main phase plus what teardown set.
+	report.Env = main.Env
+	for _, result := range teardown.Nodes {
+		if result.Status != types.StatusPassed {
+			continue
+		}
+		for k, v := range changedEnv(produced, result.NewEnv) {
+			report.Env[k] = v
+		}
+	}
+	last.Env = report.Env
+	return last
+}
+
+func phaseReport(env map[string]string) types.FlowRunReport {
+	return types.FlowRunReport{Nodes: []types.FlowNodeResult{}, Env: copyEnv(env)}
+}
+
+// skipPhase reports every node of a phase that did not run because an
+// earlier phase failed.
+func skipPhase(ctx context.Context, flow types.Flow, opts FlowRunOptions, report *types.FlowRunReport) {
+	status := types.StatusSkipped
+	if ctx.Err() != nil {
+		status = types.StatusCancelled
+	}
+	for _, node := range flow.Nodes {
+		result := types.FlowNodeResult{NodeID: node.ID, ATID: string(node.Data.ATID), Status: status}
+		report.Nodes = append(report.Nodes, result)
+		opts.emit(types.RunEvent{Type: types.EventNodeFinished, NodeID: node.ID, ATID: result.ATID, Status: status})
+	}
+	report.Status = types.StatusSkipped
+}
@@ -0,0 +1,87 @@
+package services
+
+import (
+	"errors"
+	"fmt"
+
+	"zukify.com/types"
+)
+
+// ErrNothingToResume is returned when a run has no failed nodes to rerun.
+var ErrNothingToResume = errors.New("the run has no failed nodes to rerun")
+
+// FlowResume makes a run reuse the node results of an earlier run of the
+// same flow instead of executing those nodes again. A reused node hands on
+// the env it produced in the earlier run, so the nodes that do run start
+// from the same env snapshot as before.
+type FlowResume struct {
+	previous map[string]types.FlowNodeResult
+	rerun    map[string]bool
+}
+
+// NewFlowResume plans the resumption of an earlier run from its top-level
+// node results. Every node that did not pass reruns, along with everything
+// downstream of it. When from is set, that node and everything downstream
+// of it rerun as well. Setup and teardown nodes always run again.
+func NewFlowResume(flow types.Flow, previous []types.FlowNodeResult, from string) (*FlowResume, error) {
+	resume := &FlowResume{
+		previous: make(map[string]types.FlowNodeResult, len(previous)),
+		rerun:    make(map[string]bool),
+	}
+	for _, result := range previous {
+		resume.previous[result.NodeID] = result
+	}
+
+	out := make(map[string][]string)
+	for _, edge := range flow.Edges {
+		out[edge.Source] = append(out[edge.Source], edge.Target)
+	}
+	var queue []string
+	found := from == ""
+	for _, node := range flow.Nodes {
+		if node.ID == from {
+			found = true
+			queue = append(queue, node.ID)
+		}
+		if prev, ok := resume.previous[node.ID]; node.Data.Phase == PhaseMain && (!ok || prev.Status != types.StatusPassed) {
+			queue = append(queue, node.ID)
+		}
+	}
+	if !found {
+		return nil, fmt.Errorf("node %q is not part of the flow", from)
+	}
+	if len(queue) == 0 {
+		return nil, ErrNothingToResume
+	}
+
+	for len(queue) > 0 {
+		id := queue[0]
+		queue = queue[1:]
+		if resume.rerun[id] {
+			continue
+		}
+		resume.rerun[id] = true
+		queue = append(queue, out[id]...)
+	}
+	return resume, nil
+}
+
+// reuse returns the earlier result of a node that does not need to run
+// again. Condition and join nodes only pass on their input, so they are
+// always evaluated again.
+func (r *FlowResume) reuse(node *types.FlowNode) (types.FlowNodeResult, bool) {
+	if r == nil || r.rerun[node.ID] || node.Data.Phase != PhaseMain {
+		return types.FlowNodeResult{}, false
+	}
+	switch node.Type {
+	case NodeTypeCondition, NodeTypeJoin:
+		return types.FlowNodeResult{}, false
+	}
+	result, ok := r.previous[node.ID]
+	if !ok || result.Status != types.StatusPassed {
+		return types.FlowNodeResult{}, false
+	}
+	result.TakenEdges = nil
+	result.Reused = true
+	return result, true
+}
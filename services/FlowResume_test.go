@@ -0,0 +1,74 @@
+package services
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+
+	"zukify.com/types"
+)
+
+func TestResumeRestoresSessionCookies(t *testing.T) {
+	var logins atomic.Int32
+	var up atomic.Bool
+	mux := http.NewServeMux()
+	mux.HandleFunc("/login", func(w http.ResponseWriter, r *http.Request) {
+		logins.Add(1)
+		http.SetCookie(w, &http.Cookie{Name: "token", Value: "secret", Path: "/"})
+	})
+	mux.HandleFunc("/profile", func(w http.ResponseWriter, r *http.Request) {
+		if cookie, err := r.Cookie("token"); err != nil || cookie.Value != "secret" {
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+		if !up.Load() {
+			w.WriteHeader(http.StatusServiceUnavailable)
+		}
+	})
+	srv := httptest.NewServer(mux)
+	defer srv.Close()
+
+	flow := chain("login", "profile")
+	resolve := resolveTestATs(srv, map[string]testAT{"login": {path: "/login"}, "profile": {path: "/profile"}})
+
+	session := NewSession("test")
+	first := RunFlow(context.Background(), flow, map[string]string{}, FlowRunOptions{
+		ResolveAT: resolve,
+		Exec:      ExecOptions{Session: session},
+	})
+	if got := nodeStatuses(first); got["login"] != types.StatusPassed || got["profile"] != types.StatusFailed {
+		t.Fatalf("first run statuses = %v, want login passed and profile failed", got)
+	}
+
+	// The run's own session is deleted when it finishes; only the snapshot
+	// taken then is left to resume from
+	snapshot := session.List()
+	DeleteSession(session.ID)
+	restored := RestoreSession(session.ID, "test", snapshot)
+	defer DeleteSession(restored.ID)
+
+	resume, err := NewFlowResume(flow, first.Nodes, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	up.Store(true)
+	second := RunFlow(context.Background(), flow, map[string]string{}, FlowRunOptions{
+		ResolveAT: resolve,
+		Exec:      ExecOptions{Session: restored},
+		Resume:    resume,
+	})
+
+	if second.Status != types.StatusPassed {
+		t.Errorf("resumed run status = %s, want passed (nodes %v)", second.Status, nodeStatuses(second))
+	}
+	if n := logins.Load(); n != 1 {
+		t.Errorf("login ran %d times, want it reused from the first run", n)
+	}
+	for _, node := range second.Nodes {
+		if node.NodeID == "login" && !node.Reused {
+			t.Error("login was not reused")
+		}
+	}
+}
@@ -0,0 +1,506 @@
+package services
+
+import (
+	"errors"
+	"fmt"
+	"regexp"
+	"strings"
+
+	"zukify.com/types"
+)
+
+// Codes of the issues reported by ValidateFlow.
+const (
+	IssueDuplicateNode    = "duplicate_node"
+	IssueDanglingEdge     = "dangling_edge"
+	IssueCycle            = "cycle"
+	IssueMissingATRef     = "missing_at_ref"
+	IssueMissingAT        = "missing_at"
+	IssueMissingFlowRef   = "missing_flow_ref"
+	IssueMissingFlow      = "missing_flow"
+	IssueSubFlowRecursion = "subflow_recursion"
+	IssueInvalidNode      = "invalid_node"
+	IssueUnreachableNode  = "unreachable_node"
+	IssueUnknownVariable  = "unknown_variable"
+	IssueCrossPhaseEdge   = "cross_phase_edge"
+	IssueInvalidCondition = "invalid_condition"
+)
+
+// FlowValidateOptions configures ValidateFlow. FID is the ID the flow is
+// saved under, empty for a new flow.
+type FlowValidateOptions struct {
+	FID         string
+	ResolveAT   ATResolver
+	ResolveFlow FlowResolver
+}
+
+// HasFlowErrors reports whether any of the issues blocks saving.
+func HasFlowErrors(issues []types.FlowIssue) bool {
+	for _, issue := range issues {
+		if issue.Severity == types.SeverityError {
+			return true
+		}
+	}
+	return false
+}
+
+// ValidateFlow checks a flow before it is saved. Cycles outside loop nodes,
+// edges to missing nodes, references to missing ATs or flows and recursive
+// sub-flows are errors. Unreachable nodes, edges between phases and
+// variables that no upstream node produces are warnings, since the run env
+// may still provide them.
+func ValidateFlow(flow types.Flow, opts FlowValidateOptions) []types.FlowIssue {
+	v := &flowValidator{opts: opts, ats: make(map[string]atLookup), issues: []types.FlowIssue{}}
+	v.validate(flow, nil)
+	return v.issues
+}
+
+type flowValidator struct {
+	opts   FlowValidateOptions
+	ats    map[string]atLookup
+	issues []types.FlowIssue
+}
+
+type atLookup struct {
+	at  *types.ATRequest
+	err error
+}
+
+func (v *flowValidator) add(severity, code, nodeID, edgeID, format string, args ...interface{}) {
+	v.issues = append(v.issues, types.FlowIssue{
+		Code:     code,
+		Message:  fmt.Sprintf(format, args...),
+		NodeID:   nodeID,
+		EdgeID:   edgeID,
+		Severity: severity,
+	})
+}
+
+// validate checks one graph, either the flow itself or a loop body, given
+// the variables available from the enclosing flow. It returns the
+// variables the graph's nodes produce.
+func (v *flowValidator) validate(flow types.Flow, available map[string]bool) map[string]bool {
+	nodes := make(map[string]*types.FlowNode, len(flow.Nodes))
+	for i := range flow.Nodes {
+		node := &flow.Nodes[i]
+		if _, ok := nodes[node.ID]; ok {
+			v.add(types.SeverityError, IssueDuplicateNode, node.ID, "", "node ID %q is used more than once", node.ID)
+			continue
+		}
+		nodes[node.ID] = node
+	}
+
+	out := make(map[string][]*types.FlowEdge)
+	in := make(map[string][]*types.FlowEdge)
+	for i := range flow.Edges {
+		edge := &flow.Edges[i]
+		_, hasSource := nodes[edge.Source]
+		_, hasTarget := nodes[edge.Target]
+		switch {
+		case !hasSource:
+			v.add(types.SeverityError, IssueDanglingEdge, "", edge.ID, "edge %q starts at missing node %q", edge.ID, edge.Source)
+		case !hasTarget:
+			v.add(types.SeverityError, IssueDanglingEdge, "", edge.ID, "edge %q ends at missing node %q", edge.ID, edge.Target)
+		case nodes[edge.Source].Data.Phase != nodes[edge.Target].Data.Phase:
+			v.add(types.SeverityWarning, IssueCrossPhaseEdge, "", edge.ID, "edge %q connects different phases and is ignored; phases run in order", edge.ID)
+		default:
+			out[edge.Source] = append(out[edge.Source], edge)
+			in[edge.Target] = append(in[edge.Target], edge)
+		}
+	}
+
+	v.checkCycles(flow, out)
+	v.checkReachable(flow, nodes, out, in)
+
+	// Later phases see whatever the earlier ones produce
+	earlier := map[string]map[string]bool{PhaseSetup: {}, PhaseMain: {}, PhaseTeardown: {}}
+	for i := range flow.Nodes {
+		node := &flow.Nodes[i]
+		for k := range v.produces(node) {
+			switch node.Data.Phase {
+			case PhaseSetup:
+				earlier[PhaseMain][k] = true
+				earlier[PhaseTeardown][k] = true
+			case PhaseMain:
+				earlier[PhaseTeardown][k] = true
+			}
+		}
+	}
+
+	all := make(map[string]bool)
+	for i := range flow.Nodes {
+		node := &flow.Nodes[i]
+		if nodes[node.ID] != node {
+			continue
+		}
+		v.checkPolicy(node)
+		upstream := copyVars(available)
+		for k := range earlier[node.Data.Phase] {
+			upstream[k] = true
+		}
+		for _, id := range ancestors(node.ID, in) {
+			for k := range v.produces(nodes[id]) {
+				upstream[k] = true
+			}
+		}
+		for _, edge := range in[node.ID] {
+			v.checkCondition(node.ID, edge.ID, edge.Condition)
+			v.checkVariables(node.ID, edge.ID, conditionVariables(edge.Condition), upstream)
+		}
+		for k := range v.checkNode(node, upstream) {
+			all[k] = true
+		}
+	}
+	return all
+}
+
+// checkPolicy reports an unknown phase or failure policy.
+func (v *flowValidator) checkPolicy(node *types.FlowNode) {
+	switch node.Data.Phase {
+	case PhaseSetup, PhaseMain, PhaseTeardown:
+	default:
+		v.add(types.SeverityError, IssueInvalidNode, node.ID, "", "node %q has unknown phase %q", node.ID, node.Data.Phase)
+	}
+	switch node.Data.OnFailure {
+	case "", OnFailureStop, OnFailureContinue:
+	default:
+		v.add(types.SeverityError, IssueInvalidNode, node.ID, "", "node %q has unknown on_failure policy %q", node.ID, node.Data.OnFailure)
+	}
+}
+
+// checkNode validates a single node given the variables produced upstream
+// and returns the variables the node produces.
+func (v *flowValidator) checkNode(node *types.FlowNode, upstream map[string]bool) map[string]bool {
+	data := node.Data
+	switch node.Type {
+	case NodeTypeStart, NodeTypeEnd, NodeTypeJoin:
+		return nil
+
+	case NodeTypeCondition:
+		if strings.TrimSpace(data.Condition) == "" {
+			v.add(types.SeverityError, IssueInvalidNode, node.ID, "", "condition node %q has no condition", node.ID)
+		} else {
+			v.checkCondition(node.ID, "", data.Condition)
+		}
+		v.checkVariables(node.ID, "", conditionVariables(data.Condition), upstream)
+		return nil
+
+	case NodeTypeForEach, NodeTypeRepeat:
+		if data.Body == nil {
+			v.add(types.SeverityError, IssueInvalidNode, node.ID, "", "loop node %q has no body", node.ID)
+			return nil
+		}
+		if node.Type == NodeTypeForEach && strings.TrimSpace(data.Items) == "" {
+			v.add(types.SeverityError, IssueInvalidNode, node.ID, "", "foreach node %q has no items", node.ID)
+		}
+		if node.Type == NodeTypeRepeat && strings.TrimSpace(data.Until) == "" {
+			v.add(types.SeverityError, IssueInvalidNode, node.ID, "", "repeat node %q has no until condition", node.ID)
+		}
+		v.checkCondition(node.ID, "", data.Until)
+		bodyVars := copyVars(upstream)
+		bodyVars[orDefault(data.ItemVar, "item")] = true
+		bodyVars[orDefault(data.IndexVar, "index")] = true
+		return v.validate(*data.Body, bodyVars)
+
+	case NodeTypeSubFlow:
+		v.checkSubFlow(node)
+		var used []string
+		for _, value := range data.Inputs {
+			used = append(used, templateVariables(value)...)
+		}
+		v.checkVariables(node.ID, "", used, upstream)
+		return v.produces(node)
+	}
+
+	at := v.resolveAT(node)
+	if at == nil {
+		return nil
+	}
+	patched, err := applyNodeOverrides(*at, data.Overrides)
+	if err != nil {
+		v.add(types.SeverityError, IssueInvalidNode, node.ID, "", "node %q overrides: %v", node.ID, err)
+		return nil
+	}
+	at = &patched
+	required := templateVariables(at.URL)
+	for _, value := range at.Headers {
+		required = append(required, templateVariables(value)...)
+	}
+	required = append(required, bodyVariables(at.Body)...)
+
+	own := copyVars(upstream)
+	for k := range at.Variables {
+		own[k] = true
+	}
+	v.checkVariables(node.ID, "", required, own)
+	return v.produces(node)
+}
+
+// produces returns the variables a node adds to the env.
+func (v *flowValidator) produces(node *types.FlowNode) map[string]bool {
+	vars := make(map[string]bool)
+	switch node.Type {
+	case NodeTypeStart, NodeTypeEnd, NodeTypeJoin, NodeTypeCondition:
+	case NodeTypeForEach, NodeTypeRepeat:
+		if node.Data.Body != nil {
+			for i := range node.Data.Body.Nodes {
+				for k := range v.produces(&node.Data.Body.Nodes[i]) {
+					vars[k] = true
+				}
+			}
+		}
+	case NodeTypeSubFlow:
+		for k := range node.Data.Outputs {
+			vars[k] = true
+		}
+	default:
+		if at, err := v.lookupAT(string(node.Data.ATID)); err == nil && at != nil {
+			testCases := at.TestCases
+			if o := node.Data.Overrides; o != nil && o.TestCases != nil {
+				testCases = o.TestCases
+			}
+			for _, tc := range testCases {
+				if setEnv, ok := tc.SetEnv.(map[string]interface{}); ok {
+					for k := range setEnv {
+						vars[k] = true
+					}
+				}
+			}
+		}
+	}
+	return vars
+}
+
+// resolveAT loads the AT referenced by a node, reporting it if missing.
+func (v *flowValidator) resolveAT(node *types.FlowNode) *types.ATRequest {
+	if node.Data.ATID == "" {
+		v.add(types.SeverityError, IssueMissingATRef, node.ID, "", "node %q does not reference an AT", node.ID)
+		return nil
+	}
+	at, err := v.lookupAT(string(node.Data.ATID))
+	if err != nil {
+		v.add(types.SeverityError, IssueMissingAT, node.ID, "", "node %q: %v", node.ID, err)
+	}
+	return at
+}
+
+// lookupAT resolves an AT once per validation. It returns nil without an
+// error when ATs cannot be resolved at all.
+func (v *flowValidator) lookupAT(atID string) (*types.ATRequest, error) {
+	if atID == "" || v.opts.ResolveAT == nil {
+		return nil, nil
+	}
+	if lookup, ok := v.ats[atID]; ok {
+		return lookup.at, lookup.err
+	}
+	at, err := v.opts.ResolveAT(atID)
+	lookup := atLookup{err: err}
+	if err == nil {
+		lookup.at = &at
+	}
+	v.ats[atID] = lookup
+	return lookup.at, lookup.err
+}
+
+// checkSubFlow reports a sub-flow node whose flow is missing or would make
+// the flow being saved call itself.
+func (v *flowValidator) checkSubFlow(node *types.FlowNode) {
+	fid := string(node.Data.FlowID)
+	if fid == "" {
+		v.add(types.SeverityError, IssueMissingFlowRef, node.ID, "", "sub-flow node %q does not reference a flow", node.ID)
+		return
+	}
+	if v.opts.ResolveFlow == nil {
+		return
+	}
+	if fid != v.opts.FID {
+		if _, err := v.opts.ResolveFlow(fid); err != nil {
+			v.add(types.SeverityError, IssueMissingFlow, node.ID, "", "node %q: %v", node.ID, err)
+			return
+		}
+	}
+
+	err := CheckSubFlowRecursion(v.opts.FID, types.Flow{Nodes: []types.FlowNode{*node}}, v.opts.ResolveFlow)
+	switch {
+	case errors.Is(err, ErrSubFlowRecursion):
+		v.add(types.SeverityError, IssueSubFlowRecursion, node.ID, "", "node %q: %v", node.ID, err)
+	case err != nil:
+		v.add(types.SeverityError, IssueMissingFlow, node.ID, "", "node %q: %v", node.ID, err)
+	}
+}
+
+// checkCycles reports every edge that closes a cycle. Loops must be
+// expressed with loop nodes instead.
+func (v *flowValidator) checkCycles(flow types.Flow, out map[string][]*types.FlowEdge) {
+	const (
+		unvisited = iota
+		visiting
+		visited
+	)
+	state := make(map[string]int)
+	var path []string
+
+	var visit func(id string)
+	visit = func(id string) {
+		state[id] = visiting
+		path = append(path, id)
+		for _, edge := range out[id] {
+			switch state[edge.Target] {
+			case visiting:
+				start := 0
+				for i, p := range path {
+					if p == edge.Target {
+						start = i
+					}
+				}
+				cycle := append(append([]string{}, path[start:]...), edge.Target)
+				v.add(types.SeverityError, IssueCycle, "", edge.ID, "edge %q closes a cycle: %s; use a loop node to repeat steps", edge.ID, strings.Join(cycle, " -> "))
+			case unvisited:
+				visit(edge.Target)
+			}
+		}
+		path = path[:len(path)-1]
+		state[id] = visited
+	}
+
+	for _, node := range flow.Nodes {
+		if state[node.ID] == unvisited {
+			visit(node.ID)
+		}
+	}
+}
+
+// checkReachable warns about nodes that cannot be reached from the flow's
+// start nodes, or from its entry nodes when it has no start node. Setup and
+// teardown nodes are reached from the entry nodes of their phase.
+func (v *flowValidator) checkReachable(flow types.Flow, nodes map[string]*types.FlowNode, out, in map[string][]*types.FlowEdge) {
+	var roots []string
+	for _, node := range flow.Nodes {
+		if node.Type == NodeTypeStart {
+			roots = append(roots, node.ID)
+		}
+	}
+	hasStart := len(roots) > 0
+	for _, node := range flow.Nodes {
+		if len(in[node.ID]) == 0 && (!hasStart || node.Data.Phase != PhaseMain) {
+			roots = append(roots, node.ID)
+		}
+	}
+
+	reached := make(map[string]bool)
+	for len(roots) > 0 {
+		id := roots[0]
+		roots = roots[1:]
+		if reached[id] {
+			continue
+		}
+		reached[id] = true
+		for _, edge := range out[id] {
+			roots = append(roots, edge.Target)
+		}
+	}
+
+	for _, node := range flow.Nodes {
+		if !reached[node.ID] && nodes[node.ID] != nil {
+			v.add(types.SeverityWarning, IssueUnreachableNode, node.ID, "", "node %q is not reachable from the start of the flow", node.ID)
+		}
+	}
+}
+
+// checkCondition reports a condition that does not parse. Empty conditions
+// are left to the callers.
+func (v *flowValidator) checkCondition(nodeID, edgeID, expr string) {
+	if strings.TrimSpace(expr) == "" {
+		return
+	}
+	if _, err := EvaluateCondition(expr, conditionContext{}); err != nil {
+		v.add(types.SeverityError, IssueInvalidCondition, nodeID, edgeID, "%v", err)
+	}
+}
+
+// checkVariables warns about used variables that are not available.
+func (v *flowValidator) checkVariables(nodeID, edgeID string, used []string, available map[string]bool) {
+	seen := make(map[string]bool)
+	for _, name := range used {
+		if available[name] || seen[name] {
+			continue
+		}
+		seen[name] = true
+		v.add(types.SeverityWarning, IssueUnknownVariable, nodeID, edgeID, "variable %q is not produced by any upstream node", name)
+	}
+}
+
+// ancestors returns the IDs of every node with a path to id.
+func ancestors(id string, in map[string][]*types.FlowEdge) []string {
+	seen := map[string]bool{id: true}
+	queue := []string{id}
+	var result []string
+	for len(queue) > 0 {
+		cur := queue[0]
+		queue = queue[1:]
+		for _, edge := range in[cur] {
+			if !seen[edge.Source] {
+				seen[edge.Source] = true
+				result = append(result, edge.Source)
+				queue = append(queue, edge.Source)
+			}
+		}
+	}
+	return result
+}
+
+var (
+	templateVarPattern  = regexp.MustCompile(`<<([^<>]+)>>`)
+	conditionVarPattern = regexp.MustCompile(`\benv\.([A-Za-z0-9_.\-]+)`)
+)
+
+// templateVariables returns the names of the <<variables>> used in s.
+func templateVariables(s string) []string {
+	var names []string
+	for _, m := range templateVarPattern.FindAllStringSubmatch(s, -1) {
+		names = append(names, m[1])
+	}
+	return names
+}
+
+// conditionVariables returns the env variables a condition expression uses.
+func conditionVariables(expr string) []string {
+	names := templateVariables(expr)
+	for _, m := range conditionVarPattern.FindAllStringSubmatch(expr, -1) {
+		names = append(names, m[1])
+	}
+	return names
+}
+
+// bodyVariables returns the <<variables>> used in the strings of a body.
+func bodyVariables(value interface{}) []string {
+	var names []string
+	switch v := value.(type) {
+	case string:
+		names = templateVariables(v)
+	case map[string]interface{}:
+		for _, item := range v {
+			names = append(names, bodyVariables(item)...)
+		}
+	case []interface{}:
+		for _, item := range v {
+			names = append(names, bodyVariables(item)...)
+		}
+	}
+	return names
+}
+
+func copyVars(vars map[string]bool) map[string]bool {
+	c := make(map[string]bool, len(vars))
+	for k, v := range vars {
+		c[k] = v
+	}
+	return c
+}
+
+func orDefault(s, def string) string {
+	if s == "" {
+		return def
+	}
+	return s
+}
@@ -0,0 +1,200 @@
+package services
+
+import (
+	"encoding/json"
+	"testing"
+
+	"zukify.com/types"
+)
+
+// decodeJSON decodes a JSON test fixture.
+func decodeJSON(t *testing.T, s string) interface{} {
+	t.Helper()
+	var v interface{}
+	if err := json.Unmarshal([]byte(s), &v); err != nil {
+		t.Fatalf("invalid fixture %q: %v", s, err)
+	}
+	return v
+}
+
+func TestApplyJSONPatch(t *testing.T) {
+	tests := []struct {
+		name    string
+		doc     string
+		ops     []types.JSONPatchOp
+		want    string // ignored when wantErr is set
+		wantErr bool
+	}{
+		{
+			name: "add member",
+			doc:  `{"a":1}`,
+			ops:  []types.JSONPatchOp{{Op: "add", Path: "/b", Value: 2}},
+			want: `{"a":1,"b":2}`,
+		},
+		{
+			name: "append to array with -",
+			doc:  `{"items":[1,2]}`,
+			ops:  []types.JSONPatchOp{{Op: "add", Path: "/items/-", Value: 3}},
+			want: `{"items":[1,2,3]}`,
+		},
+		{
+			name: "append to nested array with -",
+			doc:  `{"a":{"items":[]}}`,
+			ops:  []types.JSONPatchOp{{Op: "add", Path: "/a/items/-", Value: "x"}, {Op: "add", Path: "/a/items/-", Value: "y"}},
+			want: `{"a":{"items":["x","y"]}}`,
+		},
+		{
+			name: "insert into array",
+			doc:  `{"items":[1,3]}`,
+			ops:  []types.JSONPatchOp{{Op: "add", Path: "/items/1", Value: 2}},
+			want: `{"items":[1,2,3]}`,
+		},
+		{
+			name:    "insert past the end of an array",
+			doc:     `{"items":[1]}`,
+			ops:     []types.JSONPatchOp{{Op: "add", Path: "/items/2", Value: 2}},
+			wantErr: true,
+		},
+		{
+			name: "~1 escapes a slash",
+			doc:  `{"a/b":1}`,
+			ops:  []types.JSONPatchOp{{Op: "replace", Path: "/a~1b", Value: 2}},
+			want: `{"a/b":2}`,
+		},
+		{
+			name: "~0 escapes a tilde",
+			doc:  `{"a~b":1}`,
+			ops:  []types.JSONPatchOp{{Op: "remove", Path: "/a~0b"}},
+			want: `{}`,
+		},
+		{
+			name: "~01 is a tilde followed by 1",
+			doc:  `{"~1":1,"/":2}`,
+			ops:  []types.JSONPatchOp{{Op: "remove", Path: "/~01"}},
+			want: `{"/":2}`,
+		},
+		{
+			name: "remove array element",
+			doc:  `{"items":[1,2,3]}`,
+			ops:  []types.JSONPatchOp{{Op: "remove", Path: "/items/0"}},
+			want: `{"items":[2,3]}`,
+		},
+		{
+			name:    "remove missing member",
+			doc:     `{"a":1}`,
+			ops:     []types.JSONPatchOp{{Op: "remove", Path: "/b"}},
+			wantErr: true,
+		},
+		{
+			name:    "remove below a missing member",
+			doc:     `{"a":1}`,
+			ops:     []types.JSONPatchOp{{Op: "remove", Path: "/b/c"}},
+			wantErr: true,
+		},
+		{
+			name:    "remove missing array element",
+			doc:     `{"items":[1]}`,
+			ops:     []types.JSONPatchOp{{Op: "remove", Path: "/items/1"}},
+			wantErr: true,
+		},
+		{
+			name:    "replace missing member",
+			doc:     `{"a":1}`,
+			ops:     []types.JSONPatchOp{{Op: "replace", Path: "/b", Value: 2}},
+			wantErr: true,
+		},
+		{
+			name: "move",
+			doc:  `{"a":{"b":1},"c":{}}`,
+			ops:  []types.JSONPatchOp{{Op: "move", From: "/a/b", Path: "/c/d"}},
+			want: `{"a":{},"c":{"d":1}}`,
+		},
+		{
+			name:    "move into own child",
+			doc:     `{"a":{"b":{}}}`,
+			ops:     []types.JSONPatchOp{{Op: "move", From: "/a", Path: "/a/b/c"}},
+			wantErr: true,
+		},
+		{
+			name: "copy is independent of the source",
+			doc:  `{"a":{"x":1}}`,
+			ops:  []types.JSONPatchOp{{Op: "copy", From: "/a", Path: "/b"}, {Op: "replace", Path: "/b/x", Value: 2}},
+			want: `{"a":{"x":1},"b":{"x":2}}`,
+		},
+		{
+			name: "test passes on equal numbers however decoded",
+			doc:  `{"n":1}`,
+			ops:  []types.JSONPatchOp{{Op: "test", Path: "/n", Value: 1}, {Op: "add", Path: "/ok", Value: true}},
+			want: `{"n":1,"ok":true}`,
+		},
+		{
+			name:    "test fails on a different value",
+			doc:     `{"n":1}`,
+			ops:     []types.JSONPatchOp{{Op: "test", Path: "/n", Value: 2}, {Op: "add", Path: "/ok", Value: true}},
+			wantErr: true,
+		},
+		{
+			name:    "test fails on a missing member",
+			doc:     `{}`,
+			ops:     []types.JSONPatchOp{{Op: "test", Path: "/n", Value: nil}},
+			wantErr: true,
+		},
+		{
+			name:    "unknown operation",
+			doc:     `{}`,
+			ops:     []types.JSONPatchOp{{Op: "merge", Path: "/a"}},
+			wantErr: true,
+		},
+		{
+			name:    "pointer without leading slash",
+			doc:     `{"a":1}`,
+			ops:     []types.JSONPatchOp{{Op: "remove", Path: "a"}},
+			wantErr: true,
+		},
+		{
+			name:    "array index with leading zero",
+			doc:     `{"items":[1,2]}`,
+			ops:     []types.JSONPatchOp{{Op: "remove", Path: "/items/01"}},
+			wantErr: true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := applyJSONPatch(decodeJSON(t, tt.doc), tt.ops)
+			if tt.wantErr {
+				if err == nil {
+					t.Fatalf("applyJSONPatch = %v, want an error", got)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("applyJSONPatch error: %v", err)
+			}
+			if want := decodeJSON(t, tt.want); !jsonEqual(got, want) {
+				t.Errorf("applyJSONPatch = %v, want %v", got, want)
+			}
+		})
+	}
+}
+
+func TestMergePatch(t *testing.T) {
+	tests := []struct {
+		name          string
+		target, patch string
+		want          string
+	}{
+		{"adds and replaces members", `{"a":1,"b":2}`, `{"b":3,"c":4}`, `{"a":1,"b":3,"c":4}`},
+		{"null removes a member", `{"a":1,"b":2}`, `{"a":null}`, `{"b":2}`},
+		{"merges nested objects", `{"a":{"x":1,"y":2}}`, `{"a":{"y":null,"z":3}}`, `{"a":{"x":1,"z":3}}`},
+		{"replaces arrays whole", `{"a":[1,2]}`, `{"a":[3]}`, `{"a":[3]}`},
+		{"object replaces a scalar", `{"a":1}`, `{"a":{"b":2}}`, `{"a":{"b":2}}`},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := mergePatch(decodeJSON(t, tt.target), decodeJSON(t, tt.patch))
+			if want := decodeJSON(t, tt.want); !jsonEqual(got, want) {
+				t.Errorf("mergePatch = %v, want %v", got, want)
+			}
+		})
+	}
+}
@@ -0,0 +1,64 @@
+package services
+
+import (
+	"fmt"
+	"strings"
+
+	"zukify.com/types"
+)
+
+// applyNodeOverrides returns the AT of a flow node with the node's overrides
+// applied. The resolved AT itself is left untouched, since resolvers may
+// hand out the same AT to several nodes.
+func applyNodeOverrides(at types.ATRequest, o *types.NodeOverrides) (types.ATRequest, error) {
+	if o == nil {
+		return at, nil
+	}
+
+	if len(o.Headers) > 0 {
+		headers := make(map[string]string, len(at.Headers)+len(o.Headers))
+		for k, v := range at.Headers {
+			headers[k] = v
+		}
+		for name, value := range o.Headers {
+			for k := range headers {
+				if strings.EqualFold(k, name) {
+					delete(headers, k)
+				}
+			}
+			if value != "" {
+				headers[name] = value
+			}
+		}
+		at.Headers = headers
+	}
+
+	if o.BodyMergePatch != nil || len(o.BodyPatch) > 0 {
+		var body interface{} = copyJSONValue(at.Body)
+		if at.Body == nil {
+			body = map[string]interface{}{}
+		}
+		if o.BodyMergePatch != nil {
+			body = mergePatch(body, copyJSONValue(o.BodyMergePatch))
+		}
+		if len(o.BodyPatch) > 0 {
+			var err error
+			if body, err = applyJSONPatch(body, o.BodyPatch); err != nil {
+				return at, fmt.Errorf("body patch: %v", err)
+			}
+		}
+		patched, ok := body.(map[string]interface{})
+		if !ok {
+			return at, fmt.Errorf("body patch must leave the body a JSON object")
+		}
+		at.Body = patched
+	}
+
+	if o.TestCases != nil {
+		at.TestCases = o.TestCases
+	}
+	if o.TimeoutMs > 0 {
+		at.TimeoutMs = o.TimeoutMs
+	}
+	return at, nil
+}
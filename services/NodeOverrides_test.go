@@ -0,0 +1,136 @@
+package services
+
+import (
+	"reflect"
+	"testing"
+
+	"zukify.com/types"
+)
+
+func TestApplyNodeOverrides(t *testing.T) {
+	base := func() types.ATRequest {
+		return types.ATRequest{
+			Method:    "POST",
+			URL:       "http://example.test/users",
+			Headers:   map[string]string{"Content-Type": "application/json", "X-Trace": "on"},
+			Body:      map[string]interface{}{"name": "ann", "tags": []interface{}{"a"}, "meta": map[string]interface{}{"v": 1.0}},
+			TestCases: []types.TestCase{{Case: "check_status_200", Imp: true}},
+		}
+	}
+	tests := []struct {
+		name        string
+		overrides   *types.NodeOverrides
+		wantHeaders map[string]string
+		wantBody    string // JSON; empty keeps the AT's body
+		wantErr     bool
+	}{
+		{
+			name:        "no overrides",
+			wantHeaders: map[string]string{"Content-Type": "application/json", "X-Trace": "on"},
+		},
+		{
+			name:        "headers are replaced case-insensitively and removed when empty",
+			overrides:   &types.NodeOverrides{Headers: map[string]string{"content-type": "text/plain", "X-Trace": "", "X-New": "1"}},
+			wantHeaders: map[string]string{"content-type": "text/plain", "X-New": "1"},
+		},
+		{
+			name:        "merge patch",
+			overrides:   &types.NodeOverrides{BodyMergePatch: map[string]interface{}{"name": "bob", "meta": nil}},
+			wantHeaders: map[string]string{"Content-Type": "application/json", "X-Trace": "on"},
+			wantBody:    `{"name":"bob","tags":["a"]}`,
+		},
+		{
+			name: "json patch appends to an array",
+			overrides: &types.NodeOverrides{BodyPatch: []types.JSONPatchOp{
+				{Op: "add", Path: "/tags/-", Value: "b"},
+			}},
+			wantHeaders: map[string]string{"Content-Type": "application/json", "X-Trace": "on"},
+			wantBody:    `{"name":"ann","tags":["a","b"],"meta":{"v":1}}`,
+		},
+		{
+			name: "merge patch applies before json patch",
+			overrides: &types.NodeOverrides{
+				BodyMergePatch: map[string]interface{}{"extra": map[string]interface{}{"k": "v"}},
+				BodyPatch:      []types.JSONPatchOp{{Op: "test", Path: "/extra/k", Value: "v"}, {Op: "remove", Path: "/extra"}},
+			},
+			wantHeaders: map[string]string{"Content-Type": "application/json", "X-Trace": "on"},
+			wantBody:    `{"name":"ann","tags":["a"],"meta":{"v":1}}`,
+		},
+		{
+			name: "escaped pointers",
+			overrides: &types.NodeOverrides{BodyPatch: []types.JSONPatchOp{
+				{Op: "add", Path: "/a~1b", Value: 1},
+				{Op: "add", Path: "/c~0d", Value: 2},
+			}},
+			wantHeaders: map[string]string{"Content-Type": "application/json", "X-Trace": "on"},
+			wantBody:    `{"name":"ann","tags":["a"],"meta":{"v":1},"a/b":1,"c~d":2}`,
+		},
+		{
+			name:      "failed test op",
+			overrides: &types.NodeOverrides{BodyPatch: []types.JSONPatchOp{{Op: "test", Path: "/name", Value: "bob"}}},
+			wantErr:   true,
+		},
+		{
+			name:      "removing a missing path",
+			overrides: &types.NodeOverrides{BodyPatch: []types.JSONPatchOp{{Op: "remove", Path: "/missing"}}},
+			wantErr:   true,
+		},
+		{
+			name:      "body must stay an object",
+			overrides: &types.NodeOverrides{BodyPatch: []types.JSONPatchOp{{Op: "replace", Path: "", Value: []interface{}{}}}},
+			wantErr:   true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			at := base()
+			got, err := applyNodeOverrides(at, tt.overrides)
+			if !reflect.DeepEqual(at, base()) {
+				t.Errorf("the resolved AT was modified: %+v", at)
+			}
+			if tt.wantErr {
+				if err == nil {
+					t.Fatalf("applyNodeOverrides = %+v, want an error", got)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("applyNodeOverrides error: %v", err)
+			}
+			if !reflect.DeepEqual(got.Headers, tt.wantHeaders) {
+				t.Errorf("headers = %v, want %v", got.Headers, tt.wantHeaders)
+			}
+			want := at.Body
+			if tt.wantBody != "" {
+				want = decodeJSON(t, tt.wantBody).(map[string]interface{})
+			}
+			if !jsonEqual(got.Body, want) {
+				t.Errorf("body = %v, want %v", got.Body, want)
+			}
+		})
+	}
+}
+
+func TestApplyNodeOverridesTestCasesAndTimeout(t *testing.T) {
+	at := types.ATRequest{TestCases: []types.TestCase{{Case: "check_status_200"}}, TimeoutMs: 1000}
+
+	got, err := applyNodeOverrides(at, &types.NodeOverrides{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(got.TestCases, at.TestCases) || got.TimeoutMs != 1000 {
+		t.Errorf("empty overrides changed the AT: %+v", got)
+	}
+
+	cases := []types.TestCase{{Case: "check_response_non_empty", Imp: true}}
+	got, err = applyNodeOverrides(at, &types.NodeOverrides{TestCases: cases, TimeoutMs: 50})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(got.TestCases, cases) {
+		t.Errorf("test cases = %+v, want %+v", got.TestCases, cases)
+	}
+	if got.TimeoutMs != 50 {
+		t.Errorf("timeout = %d, want 50", got.TimeoutMs)
+	}
+}
@@ -0,0 +1,247 @@
+package services
+
+import (
+	"bufio"
+	"bytes"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"io"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"unicode/utf8"
+
+	"golang.org/x/text/transform"
+)
+
+const defaultMaxCapturedBodySize = 1 << 20 // 1 MiB
+
+// MaxCapturedBodySize is the number of response bytes kept in memory and
+// returned in EndpointResponse.Body. Bigger bodies are truncated in the
+// response and spilled in full to the blob store. It can be overridden with
+// the MAX_CAPTURED_BODY_SIZE environment variable.
+func MaxCapturedBodySize() int64 {
+	if v, err := strconv.ParseInt(os.Getenv("MAX_CAPTURED_BODY_SIZE"), 10, 64); err == nil && v > 0 {
+		return v
+	}
+	return defaultMaxCapturedBodySize
+}
+
+// BlobStoreDir is the directory holding spilled response bodies, named by
+// their SHA-256. It can be overridden with the BLOB_STORE_DIR environment
+// variable.
+func BlobStoreDir() string {
+	if dir := os.Getenv("BLOB_STORE_DIR"); dir != "" {
+		return dir
+	}
+	return "blobs"
+}
+
+// BlobPath returns the on-disk location of the blob with the given hash.
+func BlobPath(hash string) (string, bool) {
+	if len(hash) != sha256.Size*2 {
+		return "", false
+	}
+	if _, err := hex.DecodeString(hash); err != nil {
+		return "", false
+	}
+	return filepath.Join(BlobStoreDir(), hash[:2], hash), true
+}
+
+// capturedBody is a response body read up to MaxCapturedBodySize into
+// memory, with the remainder (if any) available from the blob store.
+type capturedBody struct {
+	Data      []byte // raw bytes as received (after content decoding)
+	Size      int64
+	Truncated bool
+	SHA256    string
+	BlobRef   string
+
+	// Text is Data transcoded to UTF-8; it aliases Data when no
+	// transcoding was needed.
+	Text    []byte
+	Charset charsetInfo
+}
+
+// captureBody reads r to the end. The first limit bytes are kept in memory;
+// if the body is longer, the whole body is written to the blob store.
+func captureBody(r io.Reader, limit int64) (*capturedBody, error) {
+	hash := sha256.New()
+	var head bytes.Buffer
+
+	// Read one byte past the limit to tell a body of exactly limit bytes
+	// from a longer one
+	n, err := io.CopyN(&head, io.TeeReader(r, hash), limit+1)
+	if err == io.EOF {
+		sum := hex.EncodeToString(hash.Sum(nil))
+		return &capturedBody{Data: head.Bytes(), Text: head.Bytes(), Size: n, SHA256: sum}, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	// The body is larger than the limit; spill everything to disk.
+	if err := os.MkdirAll(BlobStoreDir(), 0o755); err != nil {
+		return nil, err
+	}
+	tmp, err := os.CreateTemp(BlobStoreDir(), "spill-*")
+	if err != nil {
+		return nil, err
+	}
+	defer os.Remove(tmp.Name())
+	defer tmp.Close()
+
+	if _, err := tmp.Write(head.Bytes()); err != nil {
+		return nil, err
+	}
+	rest, err := io.Copy(tmp, io.TeeReader(r, hash))
+	if err != nil {
+		return nil, err
+	}
+	if err := tmp.Close(); err != nil {
+		return nil, err
+	}
+
+	sum := hex.EncodeToString(hash.Sum(nil))
+	path, _ := BlobPath(sum)
+	if err := os.MkdirAll(filepath.Dir(path), 0o755); err != nil {
+		return nil, err
+	}
+	if _, err := os.Stat(path); os.IsNotExist(err) {
+		if err := os.Rename(tmp.Name(), path); err != nil {
+			return nil, err
+		}
+	}
+
+	data := head.Bytes()[:limit]
+	return &capturedBody{
+		Data:      data,
+		Text:      data,
+		Size:      n + rest,
+		Truncated: true,
+		SHA256:    sum,
+		BlobRef:   sum,
+	}, nil
+}
+
+// decodeCharset detects the body's charset and transcodes Text to UTF-8.
+// Binary bodies are left alone.
+func (b *capturedBody) decodeCharset(contentType string) {
+	if isBinaryContentType(contentType) {
+		return
+	}
+	b.Charset = detectCharset(contentType, b.Data)
+	if b.Charset.Encoding != nil {
+		if text, err := b.Charset.Encoding.NewDecoder().Bytes(b.Data); err == nil {
+			b.Text = text
+		}
+	}
+	b.Text = bytes.TrimPrefix(b.Text, []byte("\xef\xbb\xbf"))
+}
+
+// Open returns a reader over the complete raw body, reading from the blob
+// store when the in-memory copy was truncated.
+func (b *capturedBody) Open() (io.ReadCloser, error) {
+	if b.BlobRef != "" {
+		path, _ := BlobPath(b.BlobRef)
+		return os.Open(path)
+	}
+	return io.NopCloser(bytes.NewReader(b.Data)), nil
+}
+
+// openText is like Open but transcodes the body to UTF-8.
+func (b *capturedBody) openText() (io.ReadCloser, error) {
+	r, err := b.Open()
+	if err != nil || b.Charset.Encoding == nil {
+		return r, err
+	}
+	return struct {
+		io.Reader
+		io.Closer
+	}{transform.NewReader(r, b.Charset.Encoding.NewDecoder()), r}, nil
+}
+
+// Contains reports whether substr occurs anywhere in the complete body,
+// streaming over it instead of loading it into memory.
+func (b *capturedBody) Contains(substr string) bool {
+	if !b.Truncated {
+		return bytes.Contains(b.Text, []byte(substr))
+	}
+	r, err := b.openText()
+	if err != nil {
+		return false
+	}
+	defer r.Close()
+	return streamContains(bufio.NewReader(r), []byte(substr))
+}
+
+// DecodeJSON decodes the complete body into v.
+func (b *capturedBody) DecodeJSON(v interface{}) error {
+	if !b.Truncated {
+		return json.Unmarshal(b.Text, v)
+	}
+	r, err := b.openText()
+	if err != nil {
+		return err
+	}
+	defer r.Close()
+	return json.NewDecoder(r).Decode(v)
+}
+
+// streamContains searches r for needle using a sliding window that keeps only
+// len(needle)-1 bytes of overlap between chunks.
+func streamContains(r io.Reader, needle []byte) bool {
+	if len(needle) == 0 {
+		return true
+	}
+	buf := make([]byte, 0, 64*1024+len(needle))
+	chunk := make([]byte, 64*1024)
+	for {
+		n, err := r.Read(chunk)
+		buf = append(buf, chunk[:n]...)
+		if bytes.Contains(buf, needle) {
+			return true
+		}
+		if keep := len(needle) - 1; len(buf) > keep {
+			buf = append(buf[:0], buf[len(buf)-keep:]...)
+		}
+		if err != nil {
+			return false
+		}
+	}
+}
+
+// isBinaryBody reports whether the body should be returned base64-encoded
+// instead of as a string.
+func isBinaryBody(contentType string, data []byte) bool {
+	if isBinaryContentType(contentType) {
+		return true
+	}
+	// Ignore a multi-byte rune cut in half by truncation.
+	for i := 0; i < utf8.UTFMax && len(data) > 0 && !utf8.Valid(data); i++ {
+		data = data[:len(data)-1]
+	}
+	return !utf8.Valid(data)
+}
+
+func isBinaryContentType(contentType string) bool {
+	ct := strings.ToLower(contentType)
+	for _, prefix := range []string{"image/", "audio/", "video/", "font/", "application/pdf", "application/zip", "application/gzip", "application/octet-stream"} {
+		if strings.HasPrefix(ct, prefix) {
+			return true
+		}
+	}
+	return false
+}
+
+// encodeBody returns the body as it is exposed in EndpointResponse.Body
+// together with its encoding ("text" or "base64").
+func encodeBody(contentType string, data []byte) (string, string) {
+	if isBinaryBody(contentType, data) {
+		return base64.StdEncoding.EncodeToString(data), "base64"
+	}
+	return string(data), "text"
+}
@@ -0,0 +1,396 @@
+package services
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"reflect"
+	"sort"
+	"strings"
+
+	"zukify.com/types"
+)
+
+const (
+	maxDiffBodySize    = 16 << 20 // bodies read from the blob store for a diff
+	maxDiffBodyChanges = 200
+)
+
+// Execution is one execution of an AT as compared by DiffExecutions.
+type Execution struct {
+	Status     string
+	Request    *types.RenderedRequest
+	Response   *types.EndpointResponse
+	Results    []types.TestResult
+	DurationMs int64
+}
+
+// DiffOptions leaves out differences that are expected between runs, such
+// as Date headers or generated IDs, or between environments.
+type DiffOptions struct {
+	IgnoreHeaders []string // header names, case-insensitive
+	IgnorePaths   []string // JSON pointers into the response body, with their children
+}
+
+// DiffExecutions compares two executions of an AT: run status, request
+// method, URL and headers, response status code, headers and body, test case
+// outcomes and timing.
+func DiffExecutions(before, after Execution, opts DiffOptions) types.ExecutionDiff {
+	diff := types.ExecutionDiff{
+		Status:     valueChange(before.Status, after.Status),
+		Assertions: diffAssertions(before.Results, after.Results),
+		Timing:     timingDelta(before.DurationMs, after.DurationMs),
+	}
+
+	var reqA, reqB types.RenderedRequest
+	if before.Request != nil {
+		reqA = *before.Request
+	}
+	if after.Request != nil {
+		reqB = *after.Request
+	}
+	diff.Method = valueChange(reqA.Method, reqB.Method)
+	diff.URL = valueChange(reqA.URL, reqB.URL)
+	diff.RequestHeaders = diffHeaders(reqA.Headers, reqB.Headers, opts.IgnoreHeaders)
+
+	var resA, resB types.EndpointResponse
+	if before.Response != nil {
+		resA = *before.Response
+	}
+	if after.Response != nil {
+		resB = *after.Response
+	}
+	diff.StatusCode = valueChange(resA.StatusCode, resB.StatusCode)
+	diff.Headers = diffHeaders(resA.Headers, resB.Headers, opts.IgnoreHeaders)
+	if body := diffBodies(resA, resB, opts.IgnorePaths); !body.Equal {
+		diff.Body = &body
+	}
+	return diff
+}
+
+// DiffFlowRuns compares two runs of a flow node by node, matching nodes by
+// their path. Nodes are listed in the order the second run reported them,
+// followed by those only the first run had.
+func DiffFlowRuns(before, after types.FlowRunReport, opts DiffOptions) types.FlowRunDiff {
+	diff := types.FlowRunDiff{
+		Status: valueChange(before.Status, after.Status),
+		Timing: timingDelta(before.DurationMs, after.DurationMs),
+		Env:    diffMaps(before.Env, after.Env, nil),
+		Nodes:  []types.NodeRunDiff{},
+	}
+
+	oldPaths, oldNodes := flattenResults(before.Nodes)
+	newPaths, newNodes := flattenResults(after.Nodes)
+	for _, path := range newPaths {
+		result := newNodes[path]
+		old, ok := oldNodes[path]
+		if !ok {
+			diff.Nodes = append(diff.Nodes, onlyIn(path, result, "to"))
+			continue
+		}
+		diff.Nodes = append(diff.Nodes, diffNodeResults(path, old, result, opts))
+	}
+	for _, path := range oldPaths {
+		if _, ok := newNodes[path]; !ok {
+			diff.Nodes = append(diff.Nodes, onlyIn(path, oldNodes[path], "from"))
+		}
+	}
+	return diff
+}
+
+// NodeExecution returns the AT execution of a flow node result.
+func NodeExecution(result types.FlowNodeResult) Execution {
+	return Execution{
+		Status:     result.Status,
+		Request:    result.Request,
+		Response:   result.EndpointResponse,
+		Results:    result.Results,
+		DurationMs: result.DurationMs,
+	}
+}
+
+// onlyIn describes a node that ran in one of the compared runs only.
+func onlyIn(path string, result types.FlowNodeResult, run string) types.NodeRunDiff {
+	node := types.NodeRunDiff{Path: path, ATID: result.ATID, OnlyIn: run, Changed: true}
+	node.Assertions = []types.AssertionChange{}
+	if run == "from" {
+		node.Timing = timingDelta(result.DurationMs, 0)
+	} else {
+		node.Timing = timingDelta(0, result.DurationMs)
+	}
+	return node
+}
+
+func diffNodeResults(path string, before, after types.FlowNodeResult, opts DiffOptions) types.NodeRunDiff {
+	node := types.NodeRunDiff{
+		Path:          path,
+		ATID:          after.ATID,
+		Branch:        valueChange(before.Branch, after.Branch),
+		Error:         valueChange(before.Error, after.Error),
+		ExecutionDiff: DiffExecutions(NodeExecution(before), NodeExecution(after), opts),
+	}
+	e := node.ExecutionDiff
+	node.Changed = node.Branch != nil || node.Error != nil || e.Status != nil || e.Method != nil ||
+		e.URL != nil || e.StatusCode != nil || e.RequestHeaders != nil || e.Headers != nil ||
+		e.Body != nil || len(e.Assertions) > 0
+	return node
+}
+
+// flattenResults lists the results of a run by path. Loop iterations are
+// numbered from 0 and sub-flow nodes are prefixed with their sub-flow node.
+func flattenResults(results []types.FlowNodeResult) ([]string, map[string]types.FlowNodeResult) {
+	var paths []string
+	byPath := make(map[string]types.FlowNodeResult)
+	var walk func(results []types.FlowNodeResult, prefix string)
+	walk = func(results []types.FlowNodeResult, prefix string) {
+		for _, result := range results {
+			path := prefix + result.NodeID
+			if _, ok := byPath[path]; !ok {
+				paths = append(paths, path)
+			}
+			byPath[path] = result
+			for i, iteration := range result.Iterations {
+				walk(iteration.Nodes, fmt.Sprintf("%s[%d]/", path, i))
+			}
+			if result.SubFlow != nil {
+				walk(result.SubFlow.Nodes, path+"/")
+			}
+		}
+	}
+	walk(results, "")
+	return paths, byPath
+}
+
+func valueChange(before, after interface{}) *types.ValueChange {
+	if reflect.DeepEqual(before, after) {
+		return nil
+	}
+	return &types.ValueChange{Before: before, After: after}
+}
+
+func timingDelta(before, after int64) types.TimingDelta {
+	return types.TimingDelta{BeforeMs: before, AfterMs: after, DeltaMs: after - before}
+}
+
+// diffAssertions lists the test cases whose outcome differs, matching cases
+// by their CaseKeys.
+func diffAssertions(before, after []types.TestResult) []types.AssertionChange {
+	type outcome struct {
+		passed bool
+		imp    bool
+	}
+	key := func(results []types.TestResult) ([]string, map[string]outcome) {
+		keys := CaseKeys(results)
+		byKey := make(map[string]outcome, len(results))
+		for i, r := range results {
+			byKey[keys[i]] = outcome{r.Passed, r.Imp}
+		}
+		return keys, byKey
+	}
+	oldKeys, oldCases := key(before)
+	newKeys, newCases := key(after)
+
+	changes := []types.AssertionChange{}
+	for _, k := range newKeys {
+		cur := newCases[k]
+		old, ok := oldCases[k]
+		switch {
+		case !ok:
+			changes = append(changes, types.AssertionChange{Case: k, After: &cur.passed, Imp: cur.imp})
+		case old.passed != cur.passed:
+			changes = append(changes, types.AssertionChange{Case: k, Before: &old.passed, After: &cur.passed, Imp: cur.imp})
+		}
+	}
+	for _, k := range oldKeys {
+		if _, ok := newCases[k]; !ok {
+			old := oldCases[k]
+			changes = append(changes, types.AssertionChange{Case: k, Before: &old.passed, Imp: old.imp})
+		}
+	}
+	return changes
+}
+
+func diffHeaders(before, after http.Header, ignore []string) *types.MapDiff {
+	flatten := func(h http.Header) map[string]string {
+		m := make(map[string]string, len(h))
+		for name, values := range h {
+			m[http.CanonicalHeaderKey(name)] = strings.Join(values, ", ")
+		}
+		return m
+	}
+	skip := make(map[string]bool, len(ignore))
+	for _, name := range ignore {
+		skip[http.CanonicalHeaderKey(name)] = true
+	}
+	return diffMaps(flatten(before), flatten(after), skip)
+}
+
+// DiffEnv compares two envs. It returns nil when they agree.
+func DiffEnv(before, after map[string]string) *types.MapDiff {
+	return diffMaps(before, after, nil)
+}
+
+// diffMaps compares two string maps, leaving out the keys in skip. It returns
+// nil when they agree.
+func diffMaps(before, after map[string]string, skip map[string]bool) *types.MapDiff {
+	diff := &types.MapDiff{
+		Added:   map[string]string{},
+		Removed: map[string]string{},
+		Changed: map[string]types.ValueChange{},
+	}
+	for k, v := range after {
+		if skip[k] {
+			continue
+		}
+		old, ok := before[k]
+		if !ok {
+			diff.Added[k] = v
+		} else if old != v {
+			diff.Changed[k] = types.ValueChange{Before: old, After: v}
+		}
+	}
+	for k, v := range before {
+		if _, ok := after[k]; !ok && !skip[k] {
+			diff.Removed[k] = v
+		}
+	}
+	if len(diff.Added)+len(diff.Removed)+len(diff.Changed) == 0 {
+		return nil
+	}
+	return diff
+}
+
+// diffBodies compares two response bodies. Truncated bodies are read in full
+// from the blob store; bodies too large for that are compared by hash.
+func diffBodies(before, after types.EndpointResponse, ignore []string) types.BodyDiff {
+	a, okA := fullBody(before)
+	b, okB := fullBody(after)
+	binary := before.BodyEncoding == "base64" || after.BodyEncoding == "base64"
+	if !okA || !okB || binary {
+		format := "text"
+		if binary {
+			format = "binary"
+		}
+		diff := types.BodyDiff{Format: format, Equal: before.SHA256 == after.SHA256, Changes: []types.BodyChange{}}
+		if !diff.Equal {
+			diff.Changes = append(diff.Changes, types.BodyChange{Path: "", Kind: types.ChangeChanged, Before: "sha256:" + before.SHA256, After: "sha256:" + after.SHA256})
+		}
+		return diff
+	}
+
+	var docA, docB interface{}
+	if json.Unmarshal([]byte(a), &docA) == nil && json.Unmarshal([]byte(b), &docB) == nil {
+		d := &bodyDiffer{ignore: ignore, diff: types.BodyDiff{Format: "json", Changes: []types.BodyChange{}}}
+		d.compare("", docA, docB)
+		d.diff.Equal = len(d.diff.Changes) == 0 && !d.diff.Truncated
+		return d.diff
+	}
+
+	diff := types.BodyDiff{Format: "text", Equal: a == b, Changes: []types.BodyChange{}}
+	if !diff.Equal {
+		diff.Changes = append(diff.Changes, types.BodyChange{Path: "", Kind: types.ChangeChanged, Before: a, After: b})
+	}
+	return diff
+}
+
+// fullBody returns the whole body of a response, reading it from the blob
+// store when it was truncated. It reports false when the body is unavailable
+// or too large to compare.
+func fullBody(res types.EndpointResponse) (string, bool) {
+	if !res.Truncated {
+		return res.Body, true
+	}
+	path, ok := BlobPath(res.BodyRef)
+	if !ok {
+		return "", false
+	}
+	f, err := os.Open(path)
+	if err != nil {
+		return "", false
+	}
+	defer f.Close()
+	data, err := io.ReadAll(io.LimitReader(f, maxDiffBodySize+1))
+	if err != nil || len(data) > maxDiffBodySize {
+		return "", false
+	}
+	return string(data), true
+}
+
+type bodyDiffer struct {
+	ignore []string
+	diff   types.BodyDiff
+}
+
+func (d *bodyDiffer) add(change types.BodyChange) {
+	if len(d.diff.Changes) >= maxDiffBodyChanges {
+		d.diff.Truncated = true
+		return
+	}
+	d.diff.Changes = append(d.diff.Changes, change)
+}
+
+// compare records the differences between two decoded JSON values at the
+// given JSON pointer. Objects are compared by key and arrays by index.
+func (d *bodyDiffer) compare(path string, a, b interface{}) {
+	for _, ignored := range d.ignore {
+		if path == ignored || strings.HasPrefix(path, ignored+"/") {
+			return
+		}
+	}
+
+	switch va := a.(type) {
+	case map[string]interface{}:
+		if vb, ok := b.(map[string]interface{}); ok {
+			keys := make([]string, 0, len(va)+len(vb))
+			for k := range va {
+				keys = append(keys, k)
+			}
+			for k := range vb {
+				if _, ok := va[k]; !ok {
+					keys = append(keys, k)
+				}
+			}
+			sort.Strings(keys)
+			for _, k := range keys {
+				child := path + "/" + escapePointerToken(k)
+				oldValue, inA := va[k]
+				newValue, inB := vb[k]
+				switch {
+				case !inA:
+					d.add(types.BodyChange{Path: child, Kind: types.ChangeAdded, After: newValue})
+				case !inB:
+					d.add(types.BodyChange{Path: child, Kind: types.ChangeRemoved, Before: oldValue})
+				default:
+					d.compare(child, oldValue, newValue)
+				}
+			}
+			return
+		}
+	case []interface{}:
+		if vb, ok := b.([]interface{}); ok {
+			for i := 0; i < len(va) || i < len(vb); i++ {
+				child := fmt.Sprintf("%s/%d", path, i)
+				switch {
+				case i >= len(va):
+					d.add(types.BodyChange{Path: child, Kind: types.ChangeAdded, After: vb[i]})
+				case i >= len(vb):
+					d.add(types.BodyChange{Path: child, Kind: types.ChangeRemoved, Before: va[i]})
+				default:
+					d.compare(child, va[i], vb[i])
+				}
+			}
+			return
+		}
+	}
+
+	if !reflect.DeepEqual(a, b) {
+		d.add(types.BodyChange{Path: path, Kind: types.ChangeChanged, Before: a, After: b})
+	}
+}
+
+// escapePointerToken escapes an object key for use in a JSON pointer.
+func escapePointerToken(token string) string {
+	return strings.NewReplacer("~", "~0", "/", "~1").Replace(token)
+}
@@ -0,0 +1,80 @@
+package services
+
+import (
+	"context"
+	"errors"
+	"sync"
+
+	"zukify.com/types"
+)
+
+// ErrRunCancelled is the cause attached to a run's context when it is
+// stopped through CancelRun.
+var ErrRunCancelled = errors.New("run cancelled")
+
+// ErrRunExists is returned by StartRun when the requested run ID belongs to
+// a run that is still in flight.
+var ErrRunExists = errors.New("run ID already in use")
+
+// activeRun is an in-flight run and the workspace that owns it.
+type activeRun struct {
+	wid    string
+	cancel context.CancelCauseFunc
+}
+
+var (
+	runsMu sync.Mutex
+	runs   = make(map[string]activeRun)
+)
+
+// StartRun derives a cancellable context for a run of workspace wid and
+// registers it under runID (a fresh ID is generated when runID is empty).
+// It fails with ErrRunExists if runID is already in use. The returned done
+// function must be called when the run finishes.
+func StartRun(parent context.Context, runID, wid string) (context.Context, string, func(), error) {
+	runsMu.Lock()
+	if runID == "" {
+		runID = newID()
+	}
+	if _, ok := runs[runID]; ok {
+		runsMu.Unlock()
+		return nil, "", nil, ErrRunExists
+	}
+	ctx, cancel := context.WithCancelCause(parent)
+	runs[runID] = activeRun{wid: wid, cancel: cancel}
+	runsMu.Unlock()
+
+	done := func() {
+		runsMu.Lock()
+		delete(runs, runID)
+		runsMu.Unlock()
+		cancel(nil)
+	}
+	return ctx, runID, done, nil
+}
+
+// CancelRun stops an in-flight run of workspace wid. It reports false if no
+// run with that ID is active in the workspace.
+func CancelRun(runID, wid string) bool {
+	runsMu.Lock()
+	run, ok := runs[runID]
+	runsMu.Unlock()
+	if !ok || run.wid != wid {
+		return false
+	}
+	run.cancel(ErrRunCancelled)
+	return true
+}
+
+// RunStatus summarises a test response as "passed", "failed" or
+// "cancelled".
+func RunStatus(res types.TestResponse) string {
+	switch {
+	case res.Cancelled:
+		return types.StatusCancelled
+	case res.AllImpPassed:
+		return types.StatusPassed
+	default:
+		return types.StatusFailed
+	}
+}
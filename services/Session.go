@@ -0,0 +1,296 @@
+package services
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"net/http"
+	"net/http/cookiejar"
+	"net/url"
+	"os"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"zukify.com/types"
+)
+
+// Session holds the cookie jar shared by every AT executed within one run.
+// It implements http.CookieJar and keeps a copy of every cookie it accepts,
+// with its attributes and the URL that set it, since the jar only hands
+// back names and values and the contents must be listed and persisted.
+type Session struct {
+	ID  string
+	WID string
+
+	mu       sync.Mutex
+	jar      *cookiejar.Jar
+	cookies  map[string]storedCookie
+	lastUsed time.Time
+}
+
+// storedCookie is a cookie accepted by a session and the URL that set it.
+type storedCookie struct {
+	origin *url.URL
+	cookie http.Cookie
+}
+
+const (
+	defaultSessionTTL    = time.Hour
+	sessionSweepInterval = time.Minute
+)
+
+var (
+	sessionsMu sync.Mutex
+	sessions   = make(map[string]*Session)
+	lastSweep  time.Time
+)
+
+// SessionTTL is how long a registered session may sit unused before it is
+// dropped from the registry. Persisted sessions can still be restored from
+// the workspace afterwards. It can be overridden with the SESSION_TTL
+// environment variable, as a Go duration such as "30m".
+func SessionTTL() time.Duration {
+	if v, err := time.ParseDuration(os.Getenv("SESSION_TTL")); err == nil && v > 0 {
+		return v
+	}
+	return defaultSessionTTL
+}
+
+// NewSession creates an empty session and registers it under a fresh ID.
+func NewSession(wid string) *Session {
+	session := newSession(newID(), wid)
+	register(session)
+	return session
+}
+
+// GetSession returns the registered session with the given ID, if any.
+func GetSession(id string) (*Session, bool) {
+	sessionsMu.Lock()
+	defer sessionsMu.Unlock()
+	evictIdleSessions()
+	session, ok := sessions[id]
+	if ok {
+		session.touch()
+	}
+	return session, ok
+}
+
+// NewAnonymousSession creates an empty session that belongs to no workspace
+// and is never registered, so it lives only as long as the caller keeps it.
+func NewAnonymousSession() *Session {
+	return newSession(newID(), "")
+}
+
+// RestoreSession registers a session rebuilt from a persisted snapshot,
+// replacing any in-memory session with the same ID. Each cookie is set again
+// from the URL it was originally set from, with its attributes, and expired
+// cookies are left out.
+func RestoreSession(id, wid string, cookies []types.SessionCookie) *Session {
+	session := newSession(id, wid)
+	for _, c := range cookies {
+		u, err := url.Parse(c.URL)
+		if err != nil {
+			continue
+		}
+		cookie := &http.Cookie{
+			Name:     c.Name,
+			Value:    c.Value,
+			Domain:   c.Domain,
+			Path:     c.Path,
+			Secure:   c.Secure,
+			HttpOnly: c.HttpOnly,
+			SameSite: parseSameSite(c.SameSite),
+		}
+		if c.Expires != "" {
+			if cookie.Expires, err = time.Parse(time.RFC3339, c.Expires); err != nil || !cookie.Expires.After(time.Now()) {
+				continue
+			}
+		}
+		session.SetCookies(u, []*http.Cookie{cookie})
+	}
+	register(session)
+	return session
+}
+
+// DeleteSession drops the session from the registry.
+func DeleteSession(id string) {
+	sessionsMu.Lock()
+	delete(sessions, id)
+	sessionsMu.Unlock()
+}
+
+func register(session *Session) {
+	sessionsMu.Lock()
+	defer sessionsMu.Unlock()
+	evictIdleSessions()
+	sessions[session.ID] = session
+}
+
+// evictIdleSessions drops the sessions that have not been used for longer
+// than SessionTTL. It runs at most once per sweep interval and must be
+// called with sessionsMu held.
+func evictIdleSessions() {
+	now := time.Now()
+	if now.Sub(lastSweep) < sessionSweepInterval {
+		return
+	}
+	lastSweep = now
+	cutoff := now.Add(-SessionTTL())
+	for id, session := range sessions {
+		session.mu.Lock()
+		idle := session.lastUsed.Before(cutoff)
+		session.mu.Unlock()
+		if idle {
+			delete(sessions, id)
+		}
+	}
+}
+
+func newSession(id, wid string) *Session {
+	jar, _ := cookiejar.New(nil)
+	return &Session{
+		ID:       id,
+		WID:      wid,
+		jar:      jar,
+		cookies:  make(map[string]storedCookie),
+		lastUsed: time.Now(),
+	}
+}
+
+func (s *Session) touch() {
+	s.mu.Lock()
+	s.lastUsed = time.Now()
+	s.mu.Unlock()
+}
+
+// newID returns a random 128-bit hex identifier.
+func newID() string {
+	b := make([]byte, 16)
+	rand.Read(b)
+	return hex.EncodeToString(b)
+}
+
+func (s *Session) SetCookies(u *url.URL, cookies []*http.Cookie) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.lastUsed = time.Now()
+	s.jar.SetCookies(u, cookies)
+
+	origin := &url.URL{Scheme: u.Scheme, Host: u.Host, Path: u.Path}
+	for _, c := range cookies {
+		domain := strings.ToLower(strings.TrimPrefix(c.Domain, "."))
+		if domain == "" {
+			domain = strings.ToLower(u.Hostname())
+		}
+		key := domain + "|" + c.Path + "|" + c.Name
+
+		// Store an absolute expiry so a restored cookie expires on time
+		cookie := *c
+		switch {
+		case cookie.MaxAge < 0:
+			delete(s.cookies, key)
+			continue
+		case cookie.MaxAge > 0:
+			cookie.Expires = s.lastUsed.Add(time.Duration(cookie.MaxAge) * time.Second)
+			cookie.MaxAge = 0
+		}
+		if !cookie.Expires.IsZero() && !cookie.Expires.After(s.lastUsed) {
+			delete(s.cookies, key)
+			continue
+		}
+		s.cookies[key] = storedCookie{origin: origin, cookie: cookie}
+	}
+}
+
+func (s *Session) Cookies(u *url.URL) []*http.Cookie {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.lastUsed = time.Now()
+	return s.jar.Cookies(u)
+}
+
+// List returns the cookies currently held by the jar, with their attributes
+// and the URL they were set from. Cookies the jar rejected or has since
+// expired are left out.
+func (s *Session) List() []types.SessionCookie {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	keys := make([]string, 0, len(s.cookies))
+	for key := range s.cookies {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+
+	result := []types.SessionCookie{}
+	for _, key := range keys {
+		stored := s.cookies[key]
+		c := stored.cookie
+		if !s.holds(stored) {
+			continue
+		}
+		cookie := types.SessionCookie{
+			URL:      stored.origin.String(),
+			Name:     c.Name,
+			Value:    c.Value,
+			Domain:   c.Domain,
+			Path:     c.Path,
+			Secure:   c.Secure,
+			HttpOnly: c.HttpOnly,
+			SameSite: formatSameSite(c.SameSite),
+		}
+		if !c.Expires.IsZero() {
+			cookie.Expires = c.Expires.UTC().Format(time.RFC3339)
+		}
+		result = append(result, cookie)
+	}
+	return result
+}
+
+// holds reports whether the jar still sends the stored cookie to the URL
+// that set it.
+func (s *Session) holds(stored storedCookie) bool {
+	u := *stored.origin
+	if strings.HasPrefix(stored.cookie.Path, "/") {
+		u.Path = stored.cookie.Path
+	}
+	for _, c := range s.jar.Cookies(&u) {
+		if c.Name == stored.cookie.Name && c.Value == stored.cookie.Value {
+			return true
+		}
+	}
+	return false
+}
+
+// Clear empties the jar.
+func (s *Session) Clear() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.jar, _ = cookiejar.New(nil)
+	s.cookies = make(map[string]storedCookie)
+}
+
+func parseSameSite(value string) http.SameSite {
+	switch strings.ToLower(value) {
+	case "lax":
+		return http.SameSiteLaxMode
+	case "strict":
+		return http.SameSiteStrictMode
+	case "none":
+		return http.SameSiteNoneMode
+	}
+	return http.SameSiteDefaultMode
+}
+
+func formatSameSite(mode http.SameSite) string {
+	switch mode {
+	case http.SameSiteLaxMode:
+		return "lax"
+	case http.SameSiteStrictMode:
+		return "strict"
+	case http.SameSiteNoneMode:
+		return "none"
+	}
+	return ""
+}
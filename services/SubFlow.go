@@ -0,0 +1,149 @@
+package services
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+
+	"zukify.com/types"
+)
+
+// ErrSubFlowRecursion is returned by CheckSubFlowRecursion when a flow would
+// reach itself through sub-flow nodes.
+var ErrSubFlowRecursion = errors.New("recursive sub-flow reference")
+
+// maxSubFlowDepth bounds how deeply sub-flows may nest at run time.
+const maxSubFlowDepth = 10
+
+// runSubFlowNode runs the saved flow referenced by a "subflow" node. The
+// sub-flow shares the run's session and run ID but sees only the mapped
+// inputs, and only the mapped outputs flow back into the calling flow.
+func runSubFlowNode(ctx context.Context, node *types.FlowNode, envIn map[string]string, opts FlowRunOptions) types.FlowNodeResult {
+	start := time.Now()
+	fid := string(node.Data.FlowID)
+	result := types.FlowNodeResult{NodeID: node.ID, EnvIn: envIn, NewEnv: envIn, StartedAt: formatStartTime(start)}
+
+	if err := checkSubFlowCall(fid, opts); err != nil {
+		result.Status = types.StatusError
+		result.Error = err.Error()
+		return result
+	}
+	flow, err := opts.ResolveFlow(fid)
+	if err != nil {
+		result.Status = types.StatusError
+		result.Error = err.Error()
+		return result
+	}
+
+	subEnv := make(map[string]string, len(node.Data.Inputs))
+	for name, value := range node.Data.Inputs {
+		subEnv[name] = replaceVariables(value, nil, envIn)
+	}
+
+	subOpts := opts.nested(node.ID)
+	subOpts.FID = fid
+	subOpts.callStack = append(callStack(opts), fid)
+	report, _ := runFlowGraph(ctx, flow, subEnv, subOpts)
+
+	newEnv := copyEnv(envIn)
+	for name, subName := range node.Data.Outputs {
+		if v, ok := report.Env[subName]; ok {
+			newEnv[name] = v
+		}
+	}
+
+	result.Status = report.Status
+	result.Error = report.Error
+	result.AllImpPassed = report.Status == types.StatusPassed
+	result.NewEnv = newEnv
+	result.SubFlow = &report
+	result.DurationMs = time.Since(start).Milliseconds()
+	return result
+}
+
+// checkSubFlowCall guards a sub-flow call against recursion and runaway
+// nesting that slipped past the check at save time.
+func checkSubFlowCall(fid string, opts FlowRunOptions) error {
+	if fid == "" {
+		return fmt.Errorf("sub-flow node does not reference a flow")
+	}
+	if opts.ResolveFlow == nil {
+		return fmt.Errorf("sub-flows are not available in this run")
+	}
+	stack := callStack(opts)
+	for _, id := range stack {
+		if id == fid {
+			return fmt.Errorf("flow %s calls itself: %s", fid, strings.Join(append(stack, fid), " -> "))
+		}
+	}
+	if len(stack) >= maxSubFlowDepth {
+		return fmt.Errorf("sub-flows nested deeper than %d", maxSubFlowDepth)
+	}
+	return nil
+}
+
+// callStack returns the flows being run, including the top-level one.
+func callStack(opts FlowRunOptions) []string {
+	if len(opts.callStack) > 0 {
+		return opts.callStack[:len(opts.callStack):len(opts.callStack)]
+	}
+	if opts.FID != "" {
+		return []string{opts.FID}
+	}
+	return nil
+}
+
+// SubFlowRefs returns the IDs of the flows referenced by the sub-flow nodes
+// of a flow, including those inside loop bodies.
+func SubFlowRefs(flow types.Flow) []string {
+	var refs []string
+	for _, node := range flow.Nodes {
+		if node.Type == NodeTypeSubFlow && node.Data.FlowID != "" {
+			refs = append(refs, string(node.Data.FlowID))
+		}
+		if node.Data.Body != nil {
+			refs = append(refs, SubFlowRefs(*node.Data.Body)...)
+		}
+	}
+	return refs
+}
+
+// CheckSubFlowRecursion reports an error if saving flow under fid would
+// make it reach itself, directly or through other saved flows. An empty fid
+// (a flow not saved yet) can still reach a cycle among existing flows.
+func CheckSubFlowRecursion(fid string, flow types.Flow, resolve FlowResolver) error {
+	visiting := map[string]bool{}
+	done := map[string]bool{}
+
+	var visit func(id string, f types.Flow, path []string) error
+	visit = func(id string, f types.Flow, path []string) error {
+		visiting[id] = true
+		for _, ref := range SubFlowRefs(f) {
+			refPath := append(path[:len(path):len(path)], ref)
+			if visiting[ref] {
+				return fmt.Errorf("%w: %s", ErrSubFlowRecursion, strings.Join(refPath, " -> "))
+			}
+			if done[ref] {
+				continue
+			}
+			sub, err := resolve(ref)
+			if err != nil {
+				return err
+			}
+			if err := visit(ref, sub, refPath); err != nil {
+				return err
+			}
+		}
+		visiting[id] = false
+		done[id] = true
+		return nil
+	}
+
+	root := fid
+	if root == "" {
+		root = "(new flow)"
+	}
+	return visit(fid, flow, []string{root})
+}
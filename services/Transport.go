@@ -0,0 +1,99 @@
+package services
+
+import (
+	"context"
+	"crypto/tls"
+	"fmt"
+	"net"
+	"net/http"
+	"strings"
+
+	"golang.org/x/net/http2"
+)
+
+// Protocols an AT can be pinned to via ATRequest.Protocol.
+const (
+	ProtocolAuto  = "auto"  // HTTP/2 when the server offers it over TLS, HTTP/1.1 otherwise
+	ProtocolHTTP1 = "http1" // always HTTP/1.1
+	ProtocolH2    = "h2"    // HTTP/2 over TLS only
+	ProtocolH2C   = "h2c"   // cleartext HTTP/2 with prior knowledge
+)
+
+// newTransport returns the round tripper for the requested protocol.
+// tlsConfig may be nil to use the system defaults. Transparent decompression
+// is disabled on every transport; TestEndpointContext decodes bodies itself
+// so that it also works when the AT sets its own Accept-Encoding.
+func newTransport(protocol string, tlsConfig *tls.Config) (http.RoundTripper, error) {
+	switch strings.ToLower(protocol) {
+	case "", ProtocolAuto:
+		t := http.DefaultTransport.(*http.Transport).Clone()
+		t.TLSClientConfig = tlsConfig
+		t.DisableCompression = true
+		return t, nil
+
+	case ProtocolHTTP1:
+		t := http.DefaultTransport.(*http.Transport).Clone()
+		t.ForceAttemptHTTP2 = false
+		t.TLSClientConfig = tlsConfig
+		t.DisableCompression = true
+		// A non-nil, empty map disables the HTTP/2 upgrade during the TLS handshake.
+		t.TLSNextProto = map[string]func(string, *tls.Conn) http.RoundTripper{}
+		return t, nil
+
+	case ProtocolH2:
+		return &http2.Transport{TLSClientConfig: tlsConfig, DisableCompression: true}, nil
+
+	case ProtocolH2C:
+		return h2cTransport{&http2.Transport{
+			AllowHTTP:          true,
+			DisableCompression: true,
+			DialTLSContext: func(ctx context.Context, network, addr string, _ *tls.Config) (net.Conn, error) {
+				var d net.Dialer
+				return d.DialContext(ctx, network, addr)
+			},
+		}}, nil
+
+	default:
+		return nil, fmt.Errorf("unsupported protocol: %s", protocol)
+	}
+}
+
+// h2cTransport speaks cleartext HTTP/2. Its dialer never does a TLS
+// handshake, so https:// URLs, including redirects to them, are refused
+// rather than sent unencrypted.
+type h2cTransport struct {
+	*http2.Transport
+}
+
+func (t h2cTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	if req.URL.Scheme != "http" {
+		return nil, fmt.Errorf("protocol h2c needs an http:// URL, got %s://", req.URL.Scheme)
+	}
+	return t.Transport.RoundTrip(req)
+}
+
+// closeIdle releases the connections held by a per-request transport.
+func closeIdle(rt http.RoundTripper) {
+	if c, ok := rt.(interface{ CloseIdleConnections() }); ok {
+		c.CloseIdleConnections()
+	}
+}
+
+// protocolMatches compares a negotiated protocol such as "HTTP/2.0" against
+// an expected value given either as a protocol string or as one of the
+// Protocol constants.
+func protocolMatches(negotiated, expected string) bool {
+	return normalizeProtocol(negotiated) == normalizeProtocol(expected)
+}
+
+func normalizeProtocol(p string) string {
+	switch strings.ToLower(strings.TrimSpace(p)) {
+	case "http/2.0", "http/2", "h2", "h2c", "2":
+		return "HTTP/2.0"
+	case "http/1.1", "http1", "http/1", "1.1":
+		return "HTTP/1.1"
+	case "http/1.0", "1.0":
+		return "HTTP/1.0"
+	}
+	return p
+}
@@ -0,0 +1,118 @@
+package services
+
+import (
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+
+	"golang.org/x/net/http2"
+	"golang.org/x/net/http2/h2c"
+	"zukify.com/types"
+)
+
+func okHandler(w http.ResponseWriter, r *http.Request) {
+	w.WriteHeader(http.StatusOK)
+}
+
+// trusting returns a TLS configuration that trusts the certificate of srv.
+func trusting(srv *httptest.Server) *tls.Config {
+	pool := x509.NewCertPool()
+	pool.AddCert(srv.Certificate())
+	return &tls.Config{RootCAs: pool}
+}
+
+func runProtocol(t *testing.T, url, protocol string, opts ExecOptions) (types.TestResponse, types.EndpointResponse) {
+	t.Helper()
+	req := types.ComplexATRequest{
+		EndpointData: types.ATRequest{
+			Method:   http.MethodGet,
+			URL:      url,
+			Headers:  map[string]string{"Content-Type": "application/json"},
+			Body:     map[string]interface{}{},
+			Protocol: protocol,
+		},
+		Env: map[string]string{},
+	}
+	res, _, endpoint := TestEndpointContext(context.Background(), req, opts)
+	return res, endpoint
+}
+
+func TestProtocols(t *testing.T) {
+	http1 := httptest.NewTLSServer(http.HandlerFunc(okHandler))
+	defer http1.Close()
+
+	h2 := httptest.NewUnstartedServer(http.HandlerFunc(okHandler))
+	h2.EnableHTTP2 = true
+	h2.StartTLS()
+	defer h2.Close()
+
+	cleartext := httptest.NewServer(h2c.NewHandler(http.HandlerFunc(okHandler), &http2.Server{}))
+	defer cleartext.Close()
+
+	tests := []struct {
+		name     string
+		srv      *httptest.Server
+		protocol string
+		want     string
+	}{
+		{"http1 over TLS", http1, ProtocolHTTP1, "HTTP/1.1"},
+		{"http1 against an HTTP/2 server", h2, ProtocolHTTP1, "HTTP/1.1"},
+		{"auto against an HTTP/2 server", h2, ProtocolAuto, "HTTP/2.0"},
+		{"h2 over TLS", h2, ProtocolH2, "HTTP/2.0"},
+		{"h2c", cleartext, ProtocolH2C, "HTTP/2.0"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var opts ExecOptions
+			if tt.srv.TLS != nil {
+				opts.TLSConfig = trusting(tt.srv)
+			}
+			res, endpoint := runProtocol(t, tt.srv.URL, tt.protocol, opts)
+			if len(res.Results) > 0 {
+				t.Fatalf("results = %+v, want none", res.Results)
+			}
+			if endpoint.StatusCode != http.StatusOK {
+				t.Errorf("status code = %d, want 200", endpoint.StatusCode)
+			}
+			if endpoint.Protocol != tt.want {
+				t.Errorf("protocol = %q, want %q", endpoint.Protocol, tt.want)
+			}
+		})
+	}
+}
+
+func TestProtocolsNeedTrustedCertificate(t *testing.T) {
+	srv := httptest.NewUnstartedServer(http.HandlerFunc(okHandler))
+	srv.EnableHTTP2 = true
+	srv.StartTLS()
+	defer srv.Close()
+
+	for _, protocol := range []string{ProtocolHTTP1, ProtocolH2} {
+		res, _ := runProtocol(t, srv.URL, protocol, ExecOptions{})
+		if len(res.Results) != 1 || res.Results[0].Case != "request_execution" {
+			t.Errorf("%s: results = %+v, want a failed request_execution", protocol, res.Results)
+		}
+	}
+}
+
+func TestH2CRefusesHTTPS(t *testing.T) {
+	var called atomic.Bool
+	srv := httptest.NewServer(h2c.NewHandler(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		called.Store(true)
+	}), &http2.Server{}))
+	defer srv.Close()
+
+	// The server speaks h2c, so an https:// request sent over a plain TCP
+	// connection would reach it unencrypted
+	res, _ := runProtocol(t, "https://"+srv.Listener.Addr().String(), ProtocolH2C, ExecOptions{})
+	if len(res.Results) != 1 || res.Results[0].Case != "request_execution" {
+		t.Errorf("results = %+v, want a failed request_execution", res.Results)
+	}
+	if called.Load() {
+		t.Error("h2c sent an https:// request in cleartext")
+	}
+}
@@ -0,0 +1,144 @@
+package services
+
+import (
+	"fmt"
+	"sort"
+	"time"
+
+	"zukify.com/types"
+)
+
+// TrendRun is one AT run, as aggregated by QualityTrends.
+type TrendRun struct {
+	ATID       int
+	Path       string // name of the saved AT
+	Method     string
+	URL        string
+	Status     string
+	StatusCode int // zero when no response was received
+	DurationMs int64
+	StartedAt  time.Time
+	Results    []types.TestResult
+}
+
+// bucketStart truncates t, in UTC, to the start of its hour, day or week.
+func bucketStart(t time.Time, bucket string) (time.Time, error) {
+	t = t.UTC()
+	switch bucket {
+	case types.BucketHour:
+		return t.Truncate(time.Hour), nil
+	case types.BucketDay:
+		return time.Date(t.Year(), t.Month(), t.Day(), 0, 0, 0, 0, time.UTC), nil
+	case types.BucketWeek:
+		day := time.Date(t.Year(), t.Month(), t.Day(), 0, 0, 0, 0, time.UTC)
+		return day.AddDate(0, 0, -(int(day.Weekday())+6)%7), nil
+	}
+	return time.Time{}, fmt.Errorf("unknown bucket %q", bucket)
+}
+
+// QualityTrends aggregates AT runs into pass rate, latency and failures per
+// time bucket, latency per endpoint, the slowest endpoints and failures by
+// test case type. Latency only counts runs that received a response.
+func QualityTrends(runs []TrendRun, bucket string, slowest int) (types.QualityTrends, error) {
+	trends := types.QualityTrends{
+		Bucket:    bucket,
+		Points:    []types.TrendPoint{},
+		Endpoints: []types.EndpointLatency{},
+		Slowest:   []types.EndpointLatency{},
+		Failures:  []types.CaseFailures{},
+	}
+
+	points := make(map[time.Time]*types.TrendPoint)
+	pointLatency := make(map[time.Time][]int64)
+	endpoints := make(map[int]*types.EndpointLatency)
+	endpointLatency := make(map[int][]int64)
+	cases := make(map[string]*types.CaseFailures)
+	for _, run := range runs {
+		start, err := bucketStart(run.StartedAt, bucket)
+		if err != nil {
+			return trends, err
+		}
+		point, ok := points[start]
+		if !ok {
+			point = &types.TrendPoint{Start: start.Format(time.RFC3339), Failures: map[string]int{}}
+			points[start] = point
+		}
+		point.Runs++
+		switch run.Status {
+		case types.StatusPassed:
+			point.Passed++
+		case types.StatusFailed, types.StatusError:
+			point.Failed++
+		}
+
+		if run.StatusCode > 0 {
+			pointLatency[start] = append(pointLatency[start], run.DurationMs)
+			endpoint, ok := endpoints[run.ATID]
+			if !ok {
+				endpoint = &types.EndpointLatency{ATID: run.ATID, Path: run.Path, Method: run.Method, URL: run.URL}
+				endpoints[run.ATID] = endpoint
+			}
+			endpoint.Runs++
+			endpointLatency[run.ATID] = append(endpointLatency[run.ATID], run.DurationMs)
+		}
+
+		for _, result := range run.Results {
+			c, ok := cases[result.Case]
+			if !ok {
+				c = &types.CaseFailures{Case: result.Case}
+				cases[result.Case] = c
+			}
+			c.Runs++
+			if !result.Passed {
+				c.Failures++
+				point.Failures[result.Case]++
+			}
+		}
+	}
+
+	for start, point := range points {
+		if n := point.Passed + point.Failed; n > 0 {
+			point.PassRate = float64(point.Passed) / float64(n)
+		}
+		latency := sortedDurations(pointLatency[start])
+		point.P50Ms, point.P95Ms, point.P99Ms = percentile(latency, 50), percentile(latency, 95), percentile(latency, 99)
+		trends.Points = append(trends.Points, *point)
+	}
+	sort.Slice(trends.Points, func(i, j int) bool { return trends.Points[i].Start < trends.Points[j].Start })
+
+	for atID, endpoint := range endpoints {
+		latency := sortedDurations(endpointLatency[atID])
+		endpoint.P50Ms, endpoint.P95Ms, endpoint.P99Ms = percentile(latency, 50), percentile(latency, 95), percentile(latency, 99)
+		endpoint.MaxMs = latency[len(latency)-1]
+		trends.Endpoints = append(trends.Endpoints, *endpoint)
+	}
+	sort.Slice(trends.Endpoints, func(i, j int) bool { return trends.Endpoints[i].ATID < trends.Endpoints[j].ATID })
+
+	trends.Slowest = append(trends.Slowest, trends.Endpoints...)
+	sort.SliceStable(trends.Slowest, func(i, j int) bool { return trends.Slowest[i].P95Ms > trends.Slowest[j].P95Ms })
+	trends.Slowest = trends.Slowest[:min(len(trends.Slowest), slowest)]
+
+	for _, c := range cases {
+		trends.Failures = append(trends.Failures, *c)
+	}
+	sort.Slice(trends.Failures, func(i, j int) bool {
+		a, b := trends.Failures[i], trends.Failures[j]
+		return a.Failures > b.Failures || a.Failures == b.Failures && a.Case < b.Case
+	})
+	return trends, nil
+}
+
+func sortedDurations(durations []int64) []int64 {
+	sort.Slice(durations, func(i, j int) bool { return durations[i] < durations[j] })
+	return durations
+}
+
+// percentile returns the nearest-rank percentile of sorted durations, or 0
+// when there are none.
+func percentile(sorted []int64, p int) int64 {
+	if len(sorted) == 0 {
+		return 0
+	}
+	rank := (p*len(sorted) + 99) / 100
+	return sorted[max(rank, 1)-1]
+}
@@ -0,0 +1,25 @@
+package types
+
+// Statuses of a debug session.
+const (
+	DebugRunning = "running"
+	DebugPaused  = "paused"
+)
+
+// DebugState is the state of a flow run in debug mode.
+type DebugState struct {
+	RunID       string      `json:"run_id"`
+	Status      string      `json:"status"` // running or paused
+	Breakpoints []string    `json:"breakpoints"`
+	Paused      *DebugPause `json:"paused,omitempty"`
+}
+
+// DebugPause describes the node a debug run is paused before: the env it
+// will start from and, for AT nodes, the request it will send with that env.
+type DebugPause struct {
+	NodeID       string            `json:"node_id"`
+	ATID         string            `json:"at_id,omitempty"`
+	Env          map[string]string `json:"env"`
+	Request      *RenderedRequest  `json:"request,omitempty"`
+	RequestError string            `json:"request_error,omitempty"` // why the request could not be rendered
+}
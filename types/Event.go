@@ -0,0 +1,59 @@
+package types
+
+import (
+	"net/http"
+	"time"
+)
+
+// Event types streamed while a flow runs. For each AT node the order is
+// node_started, request_sent, response_received, one assertion per test
+// case, env_changed (only when the env changed) and node_finished.
+const (
+	EventRunStarted       = "run_started"
+	EventNodeStarted      = "node_started"
+	EventRequestSent      = "request_sent"
+	EventResponseReceived = "response_received"
+	EventAssertion        = "assertion"
+	EventEnvChanged       = "env_changed"
+	EventNodeFinished     = "node_finished"
+	EventRunFinished      = "run_finished"
+
+	// EventNodePaused is sent by debug runs when they pause before a node,
+	// ahead of its node_started event.
+	EventNodePaused = "node_paused"
+)
+
+// RunEvent is one event of a run. Seq numbers the events of a run from 1,
+// so a client can resume after the last one it saw. Only the fields that
+// apply to the event type are set.
+type RunEvent struct {
+	Seq   int       `json:"seq"`
+	RunID string    `json:"run_id"`
+	Type  string    `json:"type"`
+	Time  time.Time `json:"time"`
+
+	NodeID string `json:"node_id,omitempty"`
+	// Parent is the path of loop and sub-flow nodes enclosing NodeID,
+	// joined by "/", for nodes that are not at the top level of the flow.
+	Parent string `json:"parent,omitempty"`
+	ATID   string `json:"at_id,omitempty"`
+
+	Status     string            `json:"status,omitempty"`      // node_finished, run_finished
+	Error      string            `json:"error,omitempty"`       // node_finished, run_finished
+	DurationMs int64             `json:"duration_ms,omitempty"` // response_received, node_finished, run_finished
+	Request    *RenderedRequest  `json:"request,omitempty"`     // request_sent, node_paused
+	Response   *EndpointResponse `json:"response,omitempty"`    // response_received
+	Assertion  *TestResult       `json:"assertion,omitempty"`   // assertion
+	Env        map[string]string `json:"env,omitempty"`         // run_started: initial env; env_changed: changed values; node_paused: node env
+	Report     *FlowRunReport    `json:"report,omitempty"`      // run_finished
+}
+
+// RenderedRequest is an AT request as sent, after variable substitution.
+type RenderedRequest struct {
+	Method       string      `json:"method"`
+	URL          string      `json:"url"`
+	Headers      http.Header `json:"headers"`
+	Body         string      `json:"body,omitempty"`
+	BodyEncoding string      `json:"body_encoding,omitempty"` // "text", or "base64" for binary bodies
+	Truncated    bool        `json:"truncated,omitempty"`
+}
@@ -0,0 +1,31 @@
+package types
+
+// FlakinessReport lists the ATs and test cases whose outcome changes between
+// runs of an unchanged AT, flakiest first.
+type FlakinessReport struct {
+	ATs   []ATFlakiness   `json:"ats"`
+	Cases []CaseFlakiness `json:"cases"`
+}
+
+// ATFlakiness scores how often an AT flips between passing and failing. Score
+// is Flips divided by the number of consecutive run pairs that ran the same
+// version of the AT, from 0 (stable) to 1 (flips on every run).
+type ATFlakiness struct {
+	ATID  int             `json:"at_id"`
+	Runs  int             `json:"runs"`
+	Flips int             `json:"flips"`
+	Score float64         `json:"score"`
+	Cases []CaseFlakiness `json:"cases"`
+}
+
+// CaseFlakiness scores a test case of an AT like ATFlakiness. Case is the
+// case key: the case name, followed by "#2", "#3"... for repeated names.
+type CaseFlakiness struct {
+	ATID        int     `json:"at_id"`
+	Case        string  `json:"case"`
+	Runs        int     `json:"runs"`
+	Flips       int     `json:"flips"`
+	Failures    int     `json:"failures"`
+	Score       float64 `json:"score"`
+	Quarantined bool    `json:"quarantined"`
+}
@@ -0,0 +1,212 @@
+package types
+
+import (
+	"encoding/json"
+	"strings"
+)
+
+// Flow is the graph stored in %s_flow.flow_data.
+type Flow struct {
+	Nodes    []FlowNode   `json:"nodes"`
+	Edges    []FlowEdge   `json:"edges"`
+	Settings FlowSettings `json:"settings"`
+}
+
+type FlowSettings struct {
+	// MaxParallelism caps how many nodes of independent branches run at
+	// once. Zero or one runs the flow serially.
+	MaxParallelism int `json:"max_parallelism,omitempty"`
+}
+
+type FlowNode struct {
+	ID   string       `json:"id"`
+	Type string       `json:"type,omitempty"`
+	Data FlowNodeData `json:"data"`
+}
+
+type FlowNodeData struct {
+	Label string     `json:"label,omitempty"`
+	ATID  FlexibleID `json:"at_id,omitempty"`
+	// Condition is the expression evaluated by "condition" nodes. Their
+	// outgoing edges are labelled "true" or "false" via sourceHandle.
+	Condition string `json:"condition,omitempty"`
+	// JoinMode ("all" or "any") and ConflictPolicy ("last_write",
+	// "first_write" or "error") configure "join" nodes.
+	JoinMode       string `json:"join_mode,omitempty"`
+	ConflictPolicy string `json:"conflict_policy,omitempty"`
+
+	// Loop nodes ("foreach" and "repeat") run Body once per iteration.
+	// Items is the array a foreach iterates over: an env variable name, a
+	// <<variable>> or a JSON array literal. Until is the condition that ends
+	// a repeat loop. ItemVar and IndexVar name the iteration variables,
+	// which are only visible inside the body.
+	Body            *Flow  `json:"body,omitempty"`
+	Items           string `json:"items,omitempty"`
+	ItemVar         string `json:"item_var,omitempty"`
+	IndexVar        string `json:"index_var,omitempty"`
+	Until           string `json:"until,omitempty"`
+	MaxIterations   int    `json:"max_iterations,omitempty"`
+	TimeoutMs       int    `json:"timeout_ms,omitempty"`
+	ContinueOnError bool   `json:"continue_on_error,omitempty"`
+
+	// Sub-flow nodes ("subflow") run the saved flow FlowID. The sub-flow
+	// starts from Inputs only (sub-flow variable to a value that may use
+	// <<variables>> of the calling flow), and only Outputs (calling flow
+	// variable to sub-flow variable) are copied back.
+	FlowID  FlexibleID        `json:"flow_id,omitempty"`
+	Inputs  map[string]string `json:"inputs,omitempty"`
+	Outputs map[string]string `json:"outputs,omitempty"`
+
+	// Phase places the node in the "setup" or "teardown" phase of the flow;
+	// empty is the main phase. OnFailure ("stop" or "continue") decides
+	// whether a node that fails an important test case stops the run.
+	Phase     string `json:"phase,omitempty"`
+	OnFailure string `json:"on_failure,omitempty"`
+
+	// Overrides change the referenced AT for this node only.
+	Overrides *NodeOverrides `json:"overrides,omitempty"`
+}
+
+// NodeOverrides adjust the AT of a flow node for that step without touching
+// the saved AT. Headers are added to or replace the AT's headers; an empty
+// value removes a header. The body is patched with BodyMergePatch (RFC 7396)
+// and then BodyPatch (RFC 6902). TestCases, when set, replace the AT's test
+// cases, and TimeoutMs limits the request.
+type NodeOverrides struct {
+	Headers        map[string]string      `json:"headers,omitempty"`
+	BodyMergePatch map[string]interface{} `json:"body_merge_patch,omitempty"`
+	BodyPatch      []JSONPatchOp          `json:"body_patch,omitempty"`
+	TestCases      []TestCase             `json:"test_cases,omitempty"`
+	TimeoutMs      int                    `json:"timeout_ms,omitempty"`
+}
+
+// JSONPatchOp is one operation of an RFC 6902 JSON Patch.
+type JSONPatchOp struct {
+	Op    string      `json:"op"`
+	Path  string      `json:"path"`
+	From  string      `json:"from,omitempty"`
+	Value interface{} `json:"value,omitempty"`
+}
+
+type FlowEdge struct {
+	ID           string `json:"id"`
+	Source       string `json:"source"`
+	Target       string `json:"target"`
+	SourceHandle string `json:"sourceHandle,omitempty"`
+	// Condition, when set, is an expression over the source node's outcome
+	// and env that must hold for the edge to be followed.
+	Condition string `json:"condition,omitempty"`
+}
+
+// FlexibleID accepts both JSON strings and numbers, since the UI stores AT
+// and flow IDs either way.
+type FlexibleID string
+
+func (f *FlexibleID) UnmarshalJSON(b []byte) error {
+	var s string
+	if err := json.Unmarshal(b, &s); err == nil {
+		*f = FlexibleID(s)
+		return nil
+	}
+	var n json.Number
+	if err := json.Unmarshal(b, &n); err != nil {
+		return err
+	}
+	*f = FlexibleID(strings.TrimSpace(n.String()))
+	return nil
+}
+
+// FlowRunReport is the outcome of a flow run.
+type FlowRunReport struct {
+	RunID       string            `json:"run_id"`
+	FID         string            `json:"fid"`
+	Revision    int               `json:"revision,omitempty"`     // flow version the run executed
+	ResumedFrom string            `json:"resumed_from,omitempty"` // ID of the run this run resumed
+	SessionID   string            `json:"session_id,omitempty"`
+	Status      string            `json:"status"` // passed, failed, cancelled or error
+	Error       string            `json:"error,omitempty"`
+	Nodes       []FlowNodeResult  `json:"nodes"`
+	Env         map[string]string `json:"env"`
+	DurationMs  int64             `json:"duration_ms"`
+}
+
+// FlowNodeResult is the outcome of a single node within a flow run.
+type FlowNodeResult struct {
+	NodeID           string            `json:"node_id"`
+	ATID             string            `json:"at_id,omitempty"`
+	ATHash           string            `json:"at_hash,omitempty"` // fingerprint of the AT as run, after overrides
+	Phase            string            `json:"phase,omitempty"`   // "setup" or "teardown"; empty for the main phase
+	Status           string            `json:"status"`            // passed, failed, skipped, cancelled or error
+	Error            string            `json:"error,omitempty"`
+	Results          []TestResult      `json:"results"`
+	AllImpPassed     bool              `json:"all_imp_passed"`
+	EnvIn            map[string]string `json:"env_in"`
+	NewEnv           map[string]string `json:"new_env"`
+	Request          *RenderedRequest  `json:"request,omitempty"`
+	EndpointResponse *EndpointResponse `json:"endpoint_response,omitempty"`
+	Branch           string            `json:"branch,omitempty"`      // "true" or "false" for condition nodes
+	TakenEdges       []string          `json:"taken_edges,omitempty"` // IDs of the outgoing edges followed
+	Iterations       []FlowIteration   `json:"iterations,omitempty"`  // per-iteration results of loop nodes
+	SubFlow          *FlowRunReport    `json:"sub_flow,omitempty"`    // report of the flow run by a sub-flow node
+	Reused           bool              `json:"reused,omitempty"`      // result copied from the resumed run
+	StartedAt        string            `json:"started_at,omitempty"`  // RFC 3339, UTC; empty for nodes that did not run
+	DurationMs       int64             `json:"duration_ms"`
+}
+
+// FlowIteration is one pass through the body of a loop node.
+type FlowIteration struct {
+	Index  int               `json:"index"`
+	Item   string            `json:"item,omitempty"`
+	Status string            `json:"status"`
+	Error  string            `json:"error,omitempty"`
+	Nodes  []FlowNodeResult  `json:"nodes"`
+	Env    map[string]string `json:"env"`
+}
+
+// Severities of flow validation issues. Errors block saving a flow;
+// warnings are returned alongside a successful save.
+const (
+	SeverityError   = "error"
+	SeverityWarning = "warning"
+)
+
+// FlowIssue is a problem found when validating a flow. NodeID and EdgeID
+// point the editor at the element to highlight.
+type FlowIssue struct {
+	Code     string `json:"code"`
+	Message  string `json:"message"`
+	NodeID   string `json:"node_id,omitempty"`
+	EdgeID   string `json:"edge_id,omitempty"`
+	Severity string `json:"severity"`
+}
+
+// FlowDiff is the structural difference between two versions of a flow.
+// Nodes and edges are matched by ID; layout-only changes are ignored.
+type FlowDiff struct {
+	From            int          `json:"from"`
+	To              int          `json:"to"`
+	AddedNodes      []FlowNode   `json:"added_nodes"`
+	RemovedNodes    []FlowNode   `json:"removed_nodes"`
+	ChangedNodes    []NodeChange `json:"changed_nodes"`
+	AddedEdges      []FlowEdge   `json:"added_edges"`
+	RemovedEdges    []FlowEdge   `json:"removed_edges"`
+	ChangedEdges    []EdgeChange `json:"changed_edges"`
+	SettingsChanged bool         `json:"settings_changed"`
+}
+
+// NodeChange is a node present in both versions with different content.
+// Fields lists what changed, e.g. "type" or "data.at_id".
+type NodeChange struct {
+	ID     string   `json:"id"`
+	Fields []string `json:"fields"`
+	Before FlowNode `json:"before"`
+	After  FlowNode `json:"after"`
+}
+
+// EdgeChange is an edge present in both versions with different content.
+type EdgeChange struct {
+	ID     string   `json:"id"`
+	Fields []string `json:"fields"`
+	Before FlowEdge `json:"before"`
+	After  FlowEdge `json:"after"`
+}
@@ -17,6 +17,9 @@ type ATRequest struct {
 	Body       map[string]interface{}
 	Variables  map[string]string
 	TestCases  []TestCase
+	Protocol   string // "auto" (default), "http1", "h2" or "h2c"
+	TimeoutMs  int    // request timeout; zero means no timeout
+	Quarantined []string // keys of test cases that are reported but do not fail the AT
 }
 
 type TestCase struct {
@@ -27,20 +30,35 @@ type TestCase struct {
 }
 
 type TestResponse struct {
-	Results      []TestResult `json:"results"`
-	AllImpPassed bool         `json:"allImpPassed"`
+	Results      []TestResult     `json:"results"`
+	AllImpPassed bool             `json:"allImpPassed"`
+	Cancelled    bool             `json:"cancelled"`
+	Request      *RenderedRequest `json:"request,omitempty"` // the request as sent, when it could be built
 }
 
 type TestResult struct {
 	Case   string `json:"case"`
 	Passed bool   `json:"passed"`
 	Imp    bool   `json:"imp"`
+	Quarantined bool `json:"quarantined,omitempty"` // known to be flaky; ignored by AllImpPassed
 }
 
 type EndpointResponse struct {
-	StatusCode int
-	Headers    http.Header
-	Body       string
+	StatusCode      int
+	Protocol        string // negotiated protocol, e.g. "HTTP/1.1" or "HTTP/2.0"
+	Headers         http.Header
+	Body            string
+	BodyEncoding    string // "text", or "base64" for binary responses
+	Size            int64  // full decoded body size, even when Body is truncated
+	ContentEncoding string // Content-Encoding the body was sent with
+	CompressedSize  int64  // body size on the wire, before decoding
+	Truncated       bool
+	SHA256          string
+	BodyRef         string // blob store key of the full body, set when truncated
+	DeclaredCharset string // charset from the Content-Type header
+	DetectedCharset string // charset detected from the BOM, <meta> tags or content
+	RawBody         []byte // original bytes, set when Body was transcoded to UTF-8
+	DurationMs      int64  // time from sending the request to reading the whole body
 }
 
 type LoginRequest struct{
@@ -50,6 +68,8 @@ type LoginRequest struct{
 
 
 type ATResponse struct {
+	RunID            string           `json:"run_id"`
+	Status           string           `json:"status"`
 	Results          []TestResult     `json:"results"`
 	AllImpPassed     bool             `json:"all_imp_passed"`
 	NewEnv           map[string]string `json:"new_env"`
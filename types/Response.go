@@ -1 +1,53 @@
-package types
\ No newline at end of file
+package types
+
+// SessionCookie is a cookie held by a session with its attributes and the
+// URL that set it, so it can be restored against the same origin. A cookie
+// without Domain is host-only and one without Expires ends with the session.
+type SessionCookie struct {
+	URL      string `json:"url"`
+	Name     string `json:"name"`
+	Value    string `json:"value"`
+	Domain   string `json:"domain,omitempty"`
+	Path     string `json:"path,omitempty"`
+	Expires  string `json:"expires,omitempty"` // RFC 3339
+	Secure   bool   `json:"secure,omitempty"`
+	HttpOnly bool   `json:"http_only,omitempty"`
+	SameSite string `json:"same_site,omitempty"` // "lax", "strict" or "none"
+}
+
+// Run and node statuses reported by AT and flow runs.
+const (
+	StatusPassed    = "passed"
+	StatusFailed    = "failed"
+	StatusSkipped   = "skipped"
+	StatusCancelled = "cancelled"
+	StatusError     = "error"
+	StatusRunning   = "running" // persisted runs that have not finished yet
+)
+
+// DatasetRunReport aggregates running an AT or flow once per dataset row.
+type DatasetRunReport struct {
+	RunID     string             `json:"run_id"`
+	DID       string             `json:"did"`
+	Target    string             `json:"target"` // "at" or "flow"
+	TargetID  string             `json:"target_id"`
+	Status    string             `json:"status"`
+	Total     int                `json:"total"`
+	Passed    int                `json:"passed"`
+	Failed    int                `json:"failed"`
+	Cancelled int                `json:"cancelled"`
+	Rows      []DatasetRowResult `json:"rows"`
+}
+
+// DatasetRowResult is the outcome of a single dataset row. Failures lists a
+// readable reason for every failed test case or flow node.
+type DatasetRowResult struct {
+	Index      int               `json:"index"`
+	RunID      string            `json:"run_id,omitempty"` // ID the row was recorded under
+	Row        map[string]string `json:"row"`
+	Status     string            `json:"status"`
+	Failures   []string          `json:"failures,omitempty"`
+	StatusCode int               `json:"status_code,omitempty"`
+	Results    []TestResult      `json:"results,omitempty"`
+	Flow       *FlowRunReport    `json:"flow,omitempty"`
+}
@@ -0,0 +1,106 @@
+package types
+
+// Kinds of changes reported by run comparisons.
+const (
+	ChangeAdded   = "added"
+	ChangeRemoved = "removed"
+	ChangeChanged = "changed"
+)
+
+// ValueChange is a value that differs between two runs.
+type ValueChange struct {
+	Before interface{} `json:"before"`
+	After  interface{} `json:"after"`
+}
+
+// TimingDelta compares the duration of two runs; DeltaMs is positive when the
+// second run was slower.
+type TimingDelta struct {
+	BeforeMs int64 `json:"before_ms"`
+	AfterMs  int64 `json:"after_ms"`
+	DeltaMs  int64 `json:"delta_ms"`
+}
+
+// MapDiff lists the headers or env variables that only one run had and those
+// whose values differ. Multiple values of a header are joined with ", ".
+type MapDiff struct {
+	Added   map[string]string      `json:"added"`
+	Removed map[string]string      `json:"removed"`
+	Changed map[string]ValueChange `json:"changed"`
+}
+
+// BodyChange is a difference at a JSON pointer in the response body. Before
+// is null for added values and After for removed ones.
+type BodyChange struct {
+	Path   string      `json:"path"`
+	Kind   string      `json:"kind"` // added, removed or changed
+	Before interface{} `json:"before"`
+	After  interface{} `json:"after"`
+}
+
+// BodyDiff compares two response bodies. JSON bodies are compared value by
+// value; other bodies as a whole, under the path "".
+type BodyDiff struct {
+	Format    string       `json:"format"` // "json", "text" or "binary"
+	Equal     bool         `json:"equal"`
+	Changes   []BodyChange `json:"changes"`
+	Truncated bool         `json:"truncated,omitempty"` // more changes were found than listed
+}
+
+// AssertionChange is a test case whose outcome differs between two runs. A
+// case missing from one run has a nil outcome there.
+type AssertionChange struct {
+	Case   string `json:"case"`
+	Before *bool  `json:"before"`
+	After  *bool  `json:"after"`
+	Imp    bool   `json:"imp"`
+}
+
+// ExecutionDiff compares two executions of an AT. Fields other than Timing
+// are nil when both executions agree.
+type ExecutionDiff struct {
+	Status         *ValueChange      `json:"status,omitempty"`
+	Method         *ValueChange      `json:"method,omitempty"`
+	URL            *ValueChange      `json:"url,omitempty"`
+	StatusCode     *ValueChange      `json:"status_code,omitempty"`
+	RequestHeaders *MapDiff          `json:"request_headers,omitempty"`
+	Headers        *MapDiff          `json:"headers,omitempty"` // response headers
+	Body           *BodyDiff         `json:"body,omitempty"`    // response body
+	Assertions     []AssertionChange `json:"assertions"`
+	Timing         TimingDelta       `json:"timing"`
+}
+
+// ATRunDiff compares two runs of an AT.
+type ATRunDiff struct {
+	From   string   `json:"from"`
+	To     string   `json:"to"`
+	ATID   int      `json:"at_id"`
+	Env    *MapDiff `json:"env,omitempty"`     // env the runs started from
+	EnvOut *MapDiff `json:"env_out,omitempty"` // env the runs left behind
+	ExecutionDiff
+}
+
+// FlowRunDiff compares two runs of a flow node by node.
+type FlowRunDiff struct {
+	From   string        `json:"from"`
+	To     string        `json:"to"`
+	FID    int           `json:"fid"`
+	Status *ValueChange  `json:"status,omitempty"`
+	Timing TimingDelta   `json:"timing"`
+	Nodes  []NodeRunDiff `json:"nodes"`
+	Env    *MapDiff      `json:"env,omitempty"` // final env of the runs
+}
+
+// NodeRunDiff compares a node's results in two flow runs. Nodes inside loop
+// bodies and sub-flows are identified by their path, e.g. "loop[2]/check"
+// for the third iteration of a loop. OnlyIn is set for nodes that ran in
+// one run only.
+type NodeRunDiff struct {
+	Path    string       `json:"path"`
+	ATID    string       `json:"at_id,omitempty"`
+	OnlyIn  string       `json:"only_in,omitempty"` // "from" or "to"
+	Changed bool         `json:"changed"`           // differs in more than timing
+	Branch  *ValueChange `json:"branch,omitempty"`  // condition nodes
+	Error   *ValueChange `json:"error,omitempty"`
+	ExecutionDiff
+}
@@ -0,0 +1,54 @@
+package types
+
+// Time buckets of quality trends.
+const (
+	BucketHour = "hour"
+	BucketDay  = "day"
+	BucketWeek = "week" // weeks start on Monday
+)
+
+// QualityTrends aggregates the AT run history of a workspace.
+type QualityTrends struct {
+	Bucket    string            `json:"bucket"`
+	Points    []TrendPoint      `json:"points"`    // oldest first; buckets without runs are left out
+	Endpoints []EndpointLatency `json:"endpoints"` // by AT ID
+	Slowest   []EndpointLatency `json:"slowest"`   // by p95 latency, slowest first
+	Failures  []CaseFailures    `json:"failures"`  // by test case type, most failures first
+	Truncated bool              `json:"truncated"` // only the most recent runs of the range were aggregated
+}
+
+// TrendPoint sums up the runs started in one time bucket. PassRate is Passed
+// divided by Passed plus Failed; cancelled runs are not counted in either.
+type TrendPoint struct {
+	Start    string         `json:"start"` // RFC 3339, UTC
+	Runs     int            `json:"runs"`
+	Passed   int            `json:"passed"`
+	Failed   int            `json:"failed"`
+	PassRate float64        `json:"pass_rate"`
+	P50Ms    int64          `json:"p50_ms"`
+	P95Ms    int64          `json:"p95_ms"`
+	P99Ms    int64          `json:"p99_ms"`
+	Failures map[string]int `json:"failures"` // failed test cases by type
+}
+
+// EndpointLatency is the response time of an AT's endpoint across its runs.
+// Method and URL are those of the saved AT, before env substitution.
+type EndpointLatency struct {
+	ATID   int    `json:"at_id"`
+	Path   string `json:"path"`
+	Method string `json:"method"`
+	URL    string `json:"url"`
+	Runs   int    `json:"runs"`
+	P50Ms  int64  `json:"p50_ms"`
+	P95Ms  int64  `json:"p95_ms"`
+	P99Ms  int64  `json:"p99_ms"`
+	MaxMs  int64  `json:"max_ms"`
+}
+
+// CaseFailures counts the failed test cases of one type, such as
+// "check_status", out of all the test cases of that type that ran.
+type CaseFailures struct {
+	Case     string `json:"case"`
+	Runs     int    `json:"runs"`
+	Failures int    `json:"failures"`
+}